@@ -0,0 +1,265 @@
+package feedlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrGroupNotFound is returned when a group can't be found
+var ErrGroupNotFound = errors.New("group not found")
+
+// ErrGroupCycle is returned when a group contains itself, directly or
+// through nested groups
+var ErrGroupCycle = errors.New("group cycle")
+
+// GroupMembers describes who belongs to a group.
+//
+// A group's effective members are its users and the effective members of its
+// nested groups, less its excluded users and the effective members of its
+// excluded groups. Exclusions always win.
+type GroupMembers struct {
+	// Users that belong to the group directly
+	Users []string `json:"users,omitempty" firestore:"users,omitempty"`
+
+	// Groups whose members also belong to the group
+	Groups []string `json:"groups,omitempty" firestore:"groups,omitempty"`
+
+	// Users that never belong to the group, even through a nested group
+	ExcludedUsers []string `json:"excludedUsers,omitempty" firestore:"excludedUsers,omitempty"`
+
+	// Groups whose members never belong to the group
+	ExcludedGroups []string `json:"excludedGroups,omitempty" firestore:"excludedGroups,omitempty"`
+}
+
+// GroupResolver looks up group membership e.g from a directory service.
+//
+// Implementations must be safe for concurrent use and must wrap
+// ErrGroupNotFound when a group does not exist.
+type GroupResolver interface {
+	// GetGroupMembers returns the direct members and exclusions of a group
+	GetGroupMembers(ctx context.Context, groupID string) (*GroupMembers, error)
+}
+
+// MemoryGroupResolver is a goroutine-safe, in-memory GroupResolver.
+//
+// It is meant for tests and as a reference implementation. Members are
+// copied on the way in and out so callers can't mutate stored groups.
+type MemoryGroupResolver struct {
+	mu     sync.RWMutex
+	groups map[string]GroupMembers
+}
+
+// NewMemoryGroupResolver initializes an in-memory group resolver without
+// any groups
+func NewMemoryGroupResolver() *MemoryGroupResolver {
+	return &MemoryGroupResolver{
+		groups: map[string]GroupMembers{},
+	}
+}
+
+// PutGroup creates or replaces a group
+func (r *MemoryGroupResolver) PutGroup(groupID string, members GroupMembers) error {
+	if groupID == "" {
+		return fmt.Errorf("a group ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups[groupID] = members.copy()
+	return nil
+}
+
+// DeleteGroup removes a group
+func (r *MemoryGroupResolver) DeleteGroup(groupID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.groups[groupID]; !ok {
+		return fmt.Errorf("group %s: %w", groupID, ErrGroupNotFound)
+	}
+	delete(r.groups, groupID)
+	return nil
+}
+
+// GetGroupMembers returns the direct members and exclusions of a group
+func (r *MemoryGroupResolver) GetGroupMembers(
+	ctx context.Context, groupID string) (*GroupMembers, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	members, ok := r.groups[groupID]
+	if !ok {
+		return nil, fmt.Errorf("group %s: %w", groupID, ErrGroupNotFound)
+	}
+	found := members.copy()
+	return &found, nil
+}
+
+func (gm GroupMembers) copy() GroupMembers {
+	return GroupMembers{
+		Users:          copyStrings(gm.Users),
+		Groups:         copyStrings(gm.Groups),
+		ExcludedUsers:  copyStrings(gm.ExcludedUsers),
+		ExcludedGroups: copyStrings(gm.ExcludedGroups),
+	}
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+// Audience is who a feed element is meant for. It is resolved the same way
+// as a group's members (see GroupMembers).
+type Audience struct {
+	Users          []string
+	Groups         []string
+	ExcludedUsers  []string
+	ExcludedGroups []string
+}
+
+// Audience returns the users and groups that the item targets, less the ones
+// that it excludes
+func (it Item) Audience() Audience {
+	return Audience{
+		Users:          it.Users,
+		Groups:         it.Groups,
+		ExcludedUsers:  it.ExcludedUsers,
+		ExcludedGroups: it.ExcludedGroups,
+	}
+}
+
+// Audience returns the users and groups that the nudge targets, less the ones
+// that it excludes
+func (nu Nudge) Audience() Audience {
+	return Audience{
+		Users:          nu.Users,
+		Groups:         nu.Groups,
+		ExcludedUsers:  nu.ExcludedUsers,
+		ExcludedGroups: nu.ExcludedGroups,
+	}
+}
+
+// ResolveRecipients expands an audience into the user IDs of its effective
+// recipients, in order and without duplicates.
+//
+// Nested groups are followed to any depth. A group that contains itself
+// results in an error that wraps ErrGroupCycle, and an unknown group in one
+// that wraps ErrGroupNotFound.
+func ResolveRecipients(ctx context.Context, r GroupResolver, a Audience) ([]string, error) {
+	if r == nil && (len(a.Groups) > 0 || len(a.ExcludedGroups) > 0) {
+		return nil, fmt.Errorf("a group resolver is required to resolve groups")
+	}
+	res := &audienceResolution{
+		resolver: r,
+		resolved: map[string]map[string]bool{},
+		visiting: map[string]bool{},
+	}
+	members, err := res.resolve(ctx, GroupMembers(a))
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := []string{}
+	for uid := range members {
+		recipients = append(recipients, uid)
+	}
+	sort.Strings(recipients)
+	return recipients, nil
+}
+
+// IsRecipient returns true if a user is one of an audience's effective
+// recipients. See ResolveRecipients.
+func IsRecipient(ctx context.Context, r GroupResolver, a Audience, uid string) (bool, error) {
+	recipients, err := ResolveRecipients(ctx, r, a)
+	if err != nil {
+		return false, err
+	}
+	i := sort.SearchStrings(recipients, uid)
+	return i < len(recipients) && recipients[i] == uid, nil
+}
+
+// IsVisibleTo returns true if the item is shown and the user is one of its
+// effective recipients
+func (it Item) IsVisibleTo(ctx context.Context, r GroupResolver, uid string) (bool, error) {
+	if it.Visibility != VisibilityShow {
+		return false, nil
+	}
+	return IsRecipient(ctx, r, it.Audience(), uid)
+}
+
+// IsVisibleTo returns true if the nudge is shown and the user is one of its
+// effective recipients
+func (nu Nudge) IsVisibleTo(ctx context.Context, r GroupResolver, uid string) (bool, error) {
+	if nu.Visibility != VisibilityShow {
+		return false, nil
+	}
+	return IsRecipient(ctx, r, nu.Audience(), uid)
+}
+
+// audienceResolution expands groups, looking each one up only once
+type audienceResolution struct {
+	resolver GroupResolver
+	resolved map[string]map[string]bool
+	visiting map[string]bool
+}
+
+// resolve returns the effective members of a set of users and groups
+func (res *audienceResolution) resolve(
+	ctx context.Context, gm GroupMembers) (map[string]bool, error) {
+	members := map[string]bool{}
+	for _, uid := range gm.Users {
+		members[uid] = true
+	}
+	for _, groupID := range gm.Groups {
+		nested, err := res.group(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		for uid := range nested {
+			members[uid] = true
+		}
+	}
+	for _, uid := range gm.ExcludedUsers {
+		delete(members, uid)
+	}
+	for _, groupID := range gm.ExcludedGroups {
+		excluded, err := res.group(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		for uid := range excluded {
+			delete(members, uid)
+		}
+	}
+	return members, nil
+}
+
+// group returns the effective members of a single group
+func (res *audienceResolution) group(
+	ctx context.Context, groupID string) (map[string]bool, error) {
+	if members, ok := res.resolved[groupID]; ok {
+		return members, nil
+	}
+	if res.visiting[groupID] {
+		return nil, fmt.Errorf("group %s: %w", groupID, ErrGroupCycle)
+	}
+	res.visiting[groupID] = true
+	defer delete(res.visiting, groupID)
+
+	gm, err := res.resolver.GetGroupMembers(ctx, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve group %s: %w", groupID, err)
+	}
+	members, err := res.resolve(ctx, *gm)
+	if err != nil {
+		return nil, err
+	}
+	res.resolved[groupID] = members
+	return members, nil
+}
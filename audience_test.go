@@ -0,0 +1,200 @@
+package feedlib_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestGroupResolver(t *testing.T) *feedlib.MemoryGroupResolver {
+	r := feedlib.NewMemoryGroupResolver()
+	groups := map[string]feedlib.GroupMembers{
+		"nurses":    {Users: []string{"nurse-1", "nurse-2"}},
+		"doctors":   {Users: []string{"doctor-1", "doctor-2"}},
+		"suspended": {Users: []string{"nurse-2"}},
+		"clinicians": {
+			Groups:        []string{"nurses", "doctors"},
+			ExcludedUsers: []string{"doctor-2"},
+		},
+		"active-clinicians": {
+			Groups:         []string{"clinicians"},
+			ExcludedGroups: []string{"suspended"},
+		},
+		"cycle-a": {Groups: []string{"cycle-b"}},
+		"cycle-b": {Groups: []string{"cycle-a"}},
+		"broken":  {Groups: []string{"bogus"}},
+	}
+	for id, members := range groups {
+		assert.Nil(t, r.PutGroup(id, members))
+	}
+	return r
+}
+
+func TestMemoryGroupResolver(t *testing.T) {
+	ctx := context.Background()
+	r := feedlib.NewMemoryGroupResolver()
+	assert.NotNil(t, r.PutGroup("", feedlib.GroupMembers{}))
+
+	users := []string{"user-1"}
+	assert.Nil(t, r.PutGroup("group-1", feedlib.GroupMembers{Users: users}))
+	users[0] = "changed"
+
+	got, err := r.GetGroupMembers(ctx, "group-1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user-1"}, got.Users, "stored groups should be copies")
+
+	assert.Nil(t, r.DeleteGroup("group-1"))
+	_, err = r.GetGroupMembers(ctx, "group-1")
+	assert.True(t, errors.Is(err, feedlib.ErrGroupNotFound))
+	assert.True(t, errors.Is(r.DeleteGroup("group-1"), feedlib.ErrGroupNotFound))
+}
+
+func TestResolveRecipients(t *testing.T) {
+	r := getTestGroupResolver(t)
+	tests := []struct {
+		name     string
+		audience feedlib.Audience
+		want     []string
+		wantErr  error
+	}{
+		{
+			name:     "users only",
+			audience: feedlib.Audience{Users: []string{"user-2", "user-1", "user-2"}},
+			want:     []string{"user-1", "user-2"},
+		},
+		{
+			name:     "nested groups with exclusions",
+			audience: feedlib.Audience{Groups: []string{"active-clinicians"}},
+			want:     []string{"doctor-1", "nurse-1"},
+		},
+		{
+			name: "audience exclusions win over users",
+			audience: feedlib.Audience{
+				Users:          []string{"nurse-2", "user-1"},
+				Groups:         []string{"nurses"},
+				ExcludedUsers:  []string{"user-1"},
+				ExcludedGroups: []string{"suspended"},
+			},
+			want: []string{"nurse-1"},
+		},
+		{
+			name:     "empty audience",
+			audience: feedlib.Audience{},
+			want:     []string{},
+		},
+		{
+			name:     "cycle",
+			audience: feedlib.Audience{Groups: []string{"cycle-a"}},
+			wantErr:  feedlib.ErrGroupCycle,
+		},
+		{
+			name:     "unknown nested group",
+			audience: feedlib.Audience{Groups: []string{"broken"}},
+			wantErr:  feedlib.ErrGroupNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := feedlib.ResolveRecipients(context.Background(), r, tt.audience)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := feedlib.ResolveRecipients(
+		context.Background(), nil, feedlib.Audience{Groups: []string{"nurses"}})
+	assert.NotNil(t, err, "groups can't be resolved without a resolver")
+}
+
+func TestItem_IsVisibleTo(t *testing.T) {
+	ctx := context.Background()
+	r := getTestGroupResolver(t)
+
+	it := feedtest.Item("item-1", 1)
+	it.Users = []string{"user-1"}
+	it.Groups = []string{"active-clinicians"}
+
+	for uid, want := range map[string]bool{
+		"user-1":   true,
+		"nurse-1":  true,
+		"nurse-2":  false,
+		"doctor-2": false,
+		"stranger": false,
+	} {
+		got, err := it.IsVisibleTo(ctx, r, uid)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got, uid)
+	}
+
+	// exclusions on the item itself win over its users and groups
+	it.ExcludedUsers = []string{"user-1"}
+	it.ExcludedGroups = []string{"doctors"}
+	for uid, want := range map[string]bool{
+		"user-1":   false,
+		"nurse-1":  true,
+		"doctor-1": false,
+	} {
+		got, err := it.IsVisibleTo(ctx, r, uid)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got, uid)
+
+		got, err = feedlib.IsRecipient(ctx, r, it.Audience(), uid)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got, uid)
+	}
+	b, err := it.ValidateAndMarshal()
+	assert.Nil(t, err)
+	roundTripped := feedlib.Item{}
+	assert.Nil(t, roundTripped.ValidateAndUnmarshal(b))
+	assert.Equal(t, it.Audience(), roundTripped.Audience())
+
+	it.Visibility = feedlib.VisibilityHide
+	visible, err := it.IsVisibleTo(ctx, r, "user-1")
+	assert.Nil(t, err)
+	assert.False(t, visible, "hidden items aren't visible to anyone")
+}
+
+func TestNudge_IsVisibleTo(t *testing.T) {
+	ctx := context.Background()
+	r := getTestGroupResolver(t)
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.Users = nil
+	nu.Groups = []string{"clinicians"}
+
+	visible, err := nu.IsVisibleTo(ctx, r, "doctor-1")
+	assert.Nil(t, err)
+	assert.True(t, visible)
+
+	nu.ExcludedUsers = []string{"doctor-1"}
+	visible, err = nu.IsVisibleTo(ctx, r, "doctor-1")
+	assert.Nil(t, err)
+	assert.False(t, visible, "excluded users can't see the nudge")
+
+	nu.ExcludedUsers = nil
+	nu.ExcludedGroups = []string{"nurses"}
+	visible, err = nu.IsVisibleTo(ctx, r, "nurse-1")
+	assert.Nil(t, err)
+	assert.False(t, visible, "members of excluded groups can't see the nudge")
+	visible, err = nu.IsVisibleTo(ctx, r, "doctor-1")
+	assert.Nil(t, err)
+	assert.True(t, visible)
+
+	b, err := nu.ValidateAndMarshal()
+	assert.Nil(t, err)
+	roundTripped := feedlib.Nudge{}
+	assert.Nil(t, roundTripped.ValidateAndUnmarshal(b))
+	assert.Equal(t, nu.ExcludedGroups, roundTripped.ExcludedGroups)
+
+	nu.Groups = []string{"bogus"}
+	_, err = nu.IsVisibleTo(ctx, r, "doctor-1")
+	assert.True(t, errors.Is(err, feedlib.ErrGroupNotFound))
+}
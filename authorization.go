@@ -0,0 +1,241 @@
+package feedlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnauthenticated is returned when an anonymous caller tries to trigger an
+// action that needs a signed in user
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// ErrForbidden is returned when a caller is not allowed to trigger an action
+var ErrForbidden = errors.New("forbidden")
+
+// GraphQL error extension codes for authorization failures
+const (
+	UnauthenticatedErrorCode = "UNAUTHENTICATED"
+	ForbiddenErrorCode       = "FORBIDDEN"
+)
+
+// Principal identifies whoever is trying to trigger an action
+type Principal struct {
+	// The ID of the signed in user. It is empty for anonymous callers.
+	UserID string `json:"userID,omitempty"`
+
+	// The roles that the user has
+	Roles []string `json:"roles,omitempty"`
+
+	// The client (organization) that the user belongs to. It is informational:
+	// none of the policies in this package read it, so nothing enforces it
+	// unless a service adds its own policy that does.
+	OrganizationID string `json:"organizationID,omitempty"`
+}
+
+// IsAnonymous returns true if the caller is not signed in
+func (p Principal) IsAnonymous() bool {
+	return p.UserID == ""
+}
+
+// HasRole returns true if the caller has the role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationError is returned when a caller may not trigger an action.
+//
+// It wraps ErrUnauthenticated or ErrForbidden, so callers can use errors.Is
+// to tell the two apart.
+type AuthorizationError struct {
+	// The action that was denied
+	ActionID string `json:"actionID"`
+
+	// The caller that was denied; empty for anonymous callers
+	UserID string `json:"userID,omitempty"`
+
+	// The policy that denied the action
+	Policy string `json:"policy"`
+
+	// A human readable reason for the denial
+	Reason string `json:"reason"`
+
+	// Either ErrUnauthenticated or ErrForbidden
+	Err error `json:"-"`
+}
+
+func (e *AuthorizationError) Error() string {
+	caller := "an anonymous caller"
+	if e.UserID != "" {
+		caller = fmt.Sprintf("user %s", e.UserID)
+	}
+	return fmt.Sprintf(
+		"%s: %s may not trigger action %s: %s", e.Err, caller, e.ActionID, e.Reason)
+}
+
+// Unwrap returns ErrUnauthenticated or ErrForbidden
+func (e *AuthorizationError) Unwrap() error {
+	return e.Err
+}
+
+// GQLExtensions returns the authorization error in a form that is suitable
+// for use as GraphQL error extensions e.g gqlerror.Error.Extensions
+func (e *AuthorizationError) GQLExtensions() map[string]interface{} {
+	code := ForbiddenErrorCode
+	if errors.Is(e.Err, ErrUnauthenticated) {
+		code = UnauthenticatedErrorCode
+	}
+	return map[string]interface{}{
+		"code":     code,
+		"actionID": e.ActionID,
+		"policy":   e.Policy,
+		"reason":   e.Reason,
+	}
+}
+
+// ActionPolicy decides whether a caller may trigger an action.
+//
+// Authorize returns nil to allow the action. Denials should be returned as an
+// *AuthorizationError; any other error means that the policy could not decide
+// e.g because a lookup failed, and also stops the action from running.
+type ActionPolicy interface {
+	Name() string
+	Authorize(ctx context.Context, p Principal, ac Action) error
+}
+
+// ActionPolicyFunc adapts a function into a named ActionPolicy
+type ActionPolicyFunc struct {
+	PolicyName string
+	Fn         func(ctx context.Context, p Principal, ac Action) error
+}
+
+// Name returns the policy's name
+func (f ActionPolicyFunc) Name() string {
+	return f.PolicyName
+}
+
+// Authorize calls the policy's function
+func (f ActionPolicyFunc) Authorize(ctx context.Context, p Principal, ac Action) error {
+	return f.Fn(ctx, p, ac)
+}
+
+// AnonymousPolicy denies anonymous callers, unless the action allows
+// anonymous use
+type AnonymousPolicy struct{}
+
+// Name returns the policy's name
+func (AnonymousPolicy) Name() string {
+	return "anonymous"
+}
+
+// Authorize denies anonymous callers actions that don't allow anonymous use
+func (pol AnonymousPolicy) Authorize(ctx context.Context, p Principal, ac Action) error {
+	if !p.IsAnonymous() || ac.AllowAnonymous {
+		return nil
+	}
+	return &AuthorizationError{
+		ActionID: ac.ID,
+		Policy:   pol.Name(),
+		Reason:   "the action does not allow anonymous use",
+		Err:      ErrUnauthenticated,
+	}
+}
+
+// RolePolicy denies callers that have none of an action's required roles.
+// Actions without required roles are not restricted by it.
+type RolePolicy struct{}
+
+// Name returns the policy's name
+func (RolePolicy) Name() string {
+	return "role"
+}
+
+// Authorize denies callers that have none of the action's required roles
+func (pol RolePolicy) Authorize(ctx context.Context, p Principal, ac Action) error {
+	if len(ac.RequiredRoles) == 0 {
+		return nil
+	}
+	for _, role := range ac.RequiredRoles {
+		if p.HasRole(role) {
+			return nil
+		}
+	}
+	err := ErrForbidden
+	if p.IsAnonymous() {
+		err = ErrUnauthenticated
+	}
+	return &AuthorizationError{
+		ActionID: ac.ID,
+		UserID:   p.UserID,
+		Policy:   pol.Name(),
+		Reason:   fmt.Sprintf("one of the roles %v is required", ac.RequiredRoles),
+		Err:      err,
+	}
+}
+
+// Authorizer decides whether callers may trigger actions by consulting a
+// chain of policies. Every policy must allow an action for it to run.
+type Authorizer struct {
+	policies []ActionPolicy
+}
+
+// NewAuthorizer initializes an authorizer that consults the supplied policies
+// in order. Without policies, every action is allowed.
+func NewAuthorizer(policies ...ActionPolicy) *Authorizer {
+	return &Authorizer{
+		policies: append([]ActionPolicy{}, policies...),
+	}
+}
+
+// NewDefaultAuthorizer initializes an authorizer that enforces
+// Action.AllowAnonymous and Action.RequiredRoles. Services can add their own
+// policies with Use.
+func NewDefaultAuthorizer() *Authorizer {
+	return NewAuthorizer(AnonymousPolicy{}, RolePolicy{})
+}
+
+// Use adds policies to the end of the chain
+func (a *Authorizer) Use(policies ...ActionPolicy) {
+	a.policies = append(a.policies, policies...)
+}
+
+// Authorize returns nil if the caller may trigger the action, or the first
+// denial or error from the chain of policies
+func (a *Authorizer) Authorize(ctx context.Context, p Principal, ac Action) error {
+	for _, pol := range a.policies {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := pol.Authorize(ctx, p, ac); err != nil {
+			var authErr *AuthorizationError
+			if errors.As(err, &authErr) {
+				return err
+			}
+			return fmt.Errorf("policy %s failed for action %s: %w", pol.Name(), ac.ID, err)
+		}
+	}
+	return nil
+}
+
+// AllowedActions returns the actions that the caller may trigger e.g to
+// leave out global actions that a user can't use
+func (a *Authorizer) AllowedActions(
+	ctx context.Context, p Principal, actions []Action) ([]Action, error) {
+	allowed := []Action{}
+	for _, ac := range actions {
+		err := a.Authorize(ctx, p, ac)
+		if err == nil {
+			allowed = append(allowed, ac)
+			continue
+		}
+		if !errors.Is(err, ErrForbidden) && !errors.Is(err, ErrUnauthenticated) {
+			return nil, err
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,162 @@
+package feedlib_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	anonymous := feedtest.Action("anonymous", 1)
+	anonymous.AllowAnonymous = true
+
+	signedIn := feedtest.Action("signed-in", 1)
+	signedIn.AllowAnonymous = false
+
+	restricted := feedtest.Action("restricted", 1)
+	restricted.AllowAnonymous = false
+	restricted.RequiredRoles = []string{"admin", "clinician"}
+
+	restrictedAnonymous := restricted
+	restrictedAnonymous.AllowAnonymous = true
+
+	user := feedlib.Principal{UserID: "user-1"}
+	clinician := feedlib.Principal{UserID: "user-2", Roles: []string{"clinician"}}
+
+	tests := []struct {
+		name      string
+		principal feedlib.Principal
+		action    feedlib.Action
+		wantErr   error
+		policy    string
+	}{
+		{name: "anonymous action, anonymous caller", action: anonymous},
+		{name: "anonymous action, signed in caller", principal: user, action: anonymous},
+		{
+			name:    "signed in action, anonymous caller",
+			action:  signedIn,
+			wantErr: feedlib.ErrUnauthenticated,
+			policy:  "anonymous",
+		},
+		{name: "signed in action, signed in caller", principal: user, action: signedIn},
+		{
+			name:      "restricted action, caller without the role",
+			principal: user,
+			action:    restricted,
+			wantErr:   feedlib.ErrForbidden,
+			policy:    "role",
+		},
+		{name: "restricted action, caller with a role", principal: clinician, action: restricted},
+		{
+			name:    "roles apply to anonymous actions too",
+			action:  restrictedAnonymous,
+			wantErr: feedlib.ErrUnauthenticated,
+			policy:  "role",
+		},
+	}
+	a := feedlib.NewDefaultAuthorizer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.Authorize(context.Background(), tt.principal, tt.action)
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+			var authErr *feedlib.AuthorizationError
+			assert.True(t, errors.As(err, &authErr))
+			assert.Equal(t, tt.policy, authErr.Policy)
+			assert.Equal(t, tt.action.ID, authErr.ActionID)
+			assert.NotEmpty(t, authErr.Reason)
+		})
+	}
+}
+
+func TestAuthorizer_CustomPolicies(t *testing.T) {
+	ctx := context.Background()
+	ac := feedtest.Action("action-1", 1)
+	p := feedlib.Principal{UserID: "user-1", OrganizationID: "org-2"}
+
+	a := feedlib.NewAuthorizer()
+	assert.Nil(t, a.Authorize(ctx, feedlib.Principal{}, ac), "no policies allow everything")
+
+	a.Use(feedlib.ActionPolicyFunc{
+		PolicyName: "organization",
+		Fn: func(ctx context.Context, p feedlib.Principal, ac feedlib.Action) error {
+			if p.OrganizationID == "org-1" {
+				return nil
+			}
+			return &feedlib.AuthorizationError{
+				ActionID: ac.ID,
+				UserID:   p.UserID,
+				Policy:   "organization",
+				Reason:   "the action is only available to org-1",
+				Err:      feedlib.ErrForbidden,
+			}
+		},
+	})
+	err := a.Authorize(ctx, p, ac)
+	assert.True(t, errors.Is(err, feedlib.ErrForbidden))
+	assert.Contains(t, err.Error(), "user user-1 may not trigger action action-1")
+
+	p.OrganizationID = "org-1"
+	assert.Nil(t, a.Authorize(ctx, p, ac))
+
+	a.Use(feedlib.ActionPolicyFunc{
+		PolicyName: "broken",
+		Fn: func(ctx context.Context, p feedlib.Principal, ac feedlib.Action) error {
+			return fmt.Errorf("the directory is unavailable")
+		},
+	})
+	err = a.Authorize(ctx, p, ac)
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, feedlib.ErrForbidden))
+
+	_, err = a.AllowedActions(ctx, p, []feedlib.Action{ac})
+	assert.NotNil(t, err, "policy failures are not treated as denials")
+}
+
+func TestAuthorizer_AllowedActions(t *testing.T) {
+	open := feedtest.Action("open", 1)
+	open.AllowAnonymous = true
+	closed := feedtest.Action("closed", 2)
+	closed.AllowAnonymous = false
+
+	allowed, err := feedlib.NewDefaultAuthorizer().AllowedActions(
+		context.Background(), feedlib.Principal{}, []feedlib.Action{open, closed})
+	assert.Nil(t, err)
+	assert.Len(t, allowed, 1)
+	assert.Equal(t, "open", allowed[0].ID)
+}
+
+func TestAuthorizationError_GQLExtensions(t *testing.T) {
+	err := &feedlib.AuthorizationError{
+		ActionID: "action-1",
+		Policy:   "anonymous",
+		Reason:   "the action does not allow anonymous use",
+		Err:      feedlib.ErrUnauthenticated,
+	}
+	ext := err.GQLExtensions()
+	assert.Equal(t, feedlib.UnauthenticatedErrorCode, ext["code"])
+	assert.Equal(t, "action-1", ext["actionID"])
+	assert.Contains(t, err.Error(), "an anonymous caller")
+
+	err.Err = feedlib.ErrForbidden
+	assert.Equal(t, feedlib.ForbiddenErrorCode, err.GQLExtensions()["code"])
+}
+
+func TestActionRequiredRolesSchema(t *testing.T) {
+	ac := feedtest.Action("action-1", 1)
+	ac.RequiredRoles = []string{"admin", "admin"}
+	_, err := ac.ValidateAndMarshal()
+	assert.NotNil(t, err, "roles must be unique")
+
+	ac.RequiredRoles = []string{"admin"}
+	_, err = ac.ValidateAndMarshal()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,36 @@
+// Command feedsdl writes the GraphQL schema (SDL) for the feed types.
+//
+// Usage:
+//
+//	feedsdl [-o feed.graphql]
+//
+// The schema is written to standard output unless an output file is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/savannahghi/feedlib"
+)
+
+func main() {
+	out := flag.String("o", "", "the file to write the schema to, instead of standard output")
+	flag.Parse()
+
+	sdl, err := feedlib.GenerateSDL()
+	if err != nil {
+		log.Fatalf("can't generate the GraphQL schema: %s", err)
+	}
+	if *out == "" {
+		fmt.Print(sdl)
+		return
+	}
+	if err := ioutil.WriteFile(*out, []byte(sdl), 0o644); err != nil {
+		log.Fatalf("can't write the GraphQL schema: %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", *out)
+}
@@ -4,14 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/asaskevich/govalidator"
-	sv "github.com/savannahghi/serverutils"
 	"github.com/segmentio/ksuid"
 	"github.com/xeipuuv/gojsonschema"
 )
@@ -23,6 +18,7 @@ const (
 	SampleVideoURL             = "https://www.youtube.com/watch?v=bPiofmZGb8o"
 	FallbackSchemaHost         = "https://schema.healthcloud.co.ke"
 	SchemaHostEnvVarName       = "SCHEMA_HOST"
+	RemoteSchemasEnvVarName    = "REMOTE_SCHEMAS"
 	LinkSchemaFile             = "link.schema.json"
 	MessageSchemaFile          = "message.schema.json"
 	ActionSchemaFile           = "action.schema.json"
@@ -35,19 +31,26 @@ const (
 	StatusSchemaFile           = "status.schema.json"
 	VisibilitySchemaFile       = "visibility.schema.json"
 	NotificationBodySchemaFile = "notificationbody.schema.json"
+
+	LocalisedTextSchemaFile             = "localisedtext.schema.json"
+	LocalisedNotificationBodySchemaFile = "localisednotificationbody.schema.json"
 )
 
 // Element is a building block of a feed e.g a nudge, action, feed item etc
-// An element should know how to validate itself against it's JSON schema
+// An element should know how to validate itself against it's JSON schema.
+// Options e.g WithSanitization are passed on to the package level
+// ValidateAndUnmarshal.
 type Element interface {
-	ValidateAndUnmarshal(b []byte) error
+	ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error
 	ValidateAndMarshal() ([]byte, error)
 }
 
 // ValidateAndUnmarshal validates JSON against a named feed schema
 // file then unmarshals it into the supplied feed element, which should be a
-// pointer.
-func ValidateAndUnmarshal(sch string, b []byte, el Element) error {
+// pointer. Options e.g WithSanitization are applied to the unmarshalled
+// element, which is then validated again: sanitisation can leave an element
+// that the schema rejects e.g an item whose text was nothing but a script.
+func ValidateAndUnmarshal(sch string, b []byte, el Element, opts ...UnmarshalOption) error {
 	err := validateAgainstSchema(sch, b)
 	if err != nil {
 		return fmt.Errorf("invalid JSON: %w", err)
@@ -56,6 +59,21 @@ func ValidateAndUnmarshal(sch string, b []byte, el Element) error {
 	if err != nil {
 		return fmt.Errorf("can't unmarshal JSON to struct: %w", err)
 	}
+	o := &unmarshalOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if !o.sanitize(el) {
+		return nil
+	}
+	sanitized, err := json.Marshal(el)
+	if err != nil {
+		return fmt.Errorf("can't marshal sanitised %T to JSON: %w", el, err)
+	}
+	err = validateAgainstSchema(sch, sanitized)
+	if err != nil {
+		return fmt.Errorf("invalid JSON after sanitisation: %w", err)
+	}
 	return nil
 }
 
@@ -100,12 +118,19 @@ type Action struct {
 
 	// indicated whether this action should or can be triggered by na anoymous user
 	AllowAnonymous bool `json:"allowAnonymous" firestore:"allowAnonymous"`
+
+	// The roles that may trigger this action; a caller needs at least one.
+	// An action without required roles can be triggered by any signed in user.
+	RequiredRoles []string `json:"requiredRoles,omitempty" firestore:"requiredRoles,omitempty"`
+
+	// Translations of the name, keyed by language tag e.g sw
+	LocalisedName LocalisedText `json:"localisedName,omitempty" firestore:"localisedName,omitempty"`
 }
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (ac *Action) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(ActionSchemaFile, b, ac)
+func (ac *Action) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(ActionSchemaFile, b, ac, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid action JSON: %w", err)
 	}
@@ -137,16 +162,30 @@ type Event struct {
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (ev *Event) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(EventSchemaFile, b, ev)
+//
+// The event's payload data is also checked against the payload schema that is
+// registered for its name in the DefaultEventRegistry, if any.
+func (ev *Event) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	var candidate Event
+	err := ValidateAndUnmarshal(EventSchemaFile, b, &candidate, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid event JSON: %w", err)
 	}
+	err = DefaultEventRegistry.Validate(candidate)
+	if err != nil {
+		return fmt.Errorf("invalid event JSON: %w", err)
+	}
+	*ev = candidate
 	return nil
 }
 
-// ValidateAndMarshal validates against JSON schema then marshals to JSON
+// ValidateAndMarshal validates against JSON schema and the payload schema
+// registered in the DefaultEventRegistry, then marshals to JSON
 func (ev *Event) ValidateAndMarshal() ([]byte, error) {
+	err := DefaultEventRegistry.Validate(*ev)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
 	return ValidateAndMarshal(EventSchemaFile, ev)
 }
 
@@ -173,8 +212,8 @@ type Context struct {
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (ct *Context) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(ContextSchemaFile, b, ct)
+func (ct *Context) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(ContextSchemaFile, b, ct, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid context JSON: %w", err)
 	}
@@ -194,8 +233,8 @@ type Payload struct {
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (pl *Payload) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(PayloadSchemaFile, b, pl)
+func (pl *Payload) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(PayloadSchemaFile, b, pl, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid payload JSON: %w", err)
 	}
@@ -224,6 +263,10 @@ type Nudge struct {
 	// When this nudge should be expired/removed, automatically. RFC3339.
 	Expiry time.Time `json:"expiry" firestore:"expiry"`
 
+	// When this nudge should be published to its users' feeds. RFC3339.
+	// A nudge without one is published straight away.
+	PublishAt *time.Time `json:"publishAt,omitempty" firestore:"publishAt,omitempty"`
+
 	// the title (lead line) of the nudge
 	Title string `json:"title" firestore:"title"`
 
@@ -242,31 +285,52 @@ type Nudge struct {
 	// Identifiers of all the groups that got this message
 	Groups []string `json:"groups,omitempty" firestore:"groups,omitempty"`
 
+	// Identifiers of users that must not get this message, even as members
+	// of one of its groups
+	ExcludedUsers []string `json:"excludedUsers,omitempty" firestore:"excludedUsers,omitempty"`
+
+	// Identifiers of groups whose members must not get this message
+	ExcludedGroups []string `json:"excludedGroups,omitempty" firestore:"excludedGroups,omitempty"`
+
 	// How the user should be notified of this new item, if at all
 	NotificationChannels []Channel `json:"notificationChannels,omitempty" firestore:"notificationChannels,omitempty"`
 
 	// Text/Message the user will see in their notifications body when an action is performed on a nudge
 	NotificationBody NotificationBody `json:"notificationBody,omitempty" firestore:"notificationBody,omitempty"`
+
+	// Translations of the title, keyed by language tag e.g sw
+	LocalisedTitle LocalisedText `json:"localisedTitle,omitempty" firestore:"localisedTitle,omitempty"`
+
+	// Translations of the notification body, keyed by language tag e.g sw
+	LocalisedNotificationBody LocalisedNotificationBody `json:"localisedNotificationBody,omitempty" firestore:"localisedNotificationBody,omitempty"`
 }
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (nu *Nudge) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(NudgeSchemaFile, b, nu)
+func (nu *Nudge) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(NudgeSchemaFile, b, nu, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid nudge JSON: %w", err)
 	}
-	return nil
+	return nu.validate()
 }
 
 // ValidateAndMarshal verifies against JSON schema then marshals to JSON
 func (nu *Nudge) ValidateAndMarshal() ([]byte, error) {
+	if err := nu.validate(); err != nil {
+		return nil, err
+	}
 	return ValidateAndMarshal(NudgeSchemaFile, nu)
 }
 
 // IsEntity marks this as an Apollo federation GraphQL entity
 func (nu Nudge) IsEntity() {}
 
+// validate runs the checks that the nudge schema can't express
+func (nu *Nudge) validate() error {
+	return validatePublishWindow(NudgeSchemaFile, nu.PublishAt, nu.Expiry)
+}
+
 // Item is a single item in a feed or in an inbox
 type Item struct {
 	// A unique identifier for each feed item
@@ -278,6 +342,10 @@ type Item struct {
 	// When this feed item should be expired/removed, automatically. RFC3339.
 	Expiry time.Time `json:"expiry" firestore:"expiry"`
 
+	// When this feed item should be published to its users' feeds. RFC3339.
+	// An item without one is published straight away.
+	PublishAt *time.Time `json:"publishAt,omitempty" firestore:"publishAt,omitempty"`
+
 	// If a feed item is persistent, it also goes to the inbox
 	// AND triggers a push notification.
 	// Pinning a feed item makes it persistent.
@@ -330,32 +398,46 @@ type Item struct {
 	// Identifiers of all the groups that got this message
 	Groups []string `json:"groups,omitempty" firestore:"groups,omitempty"`
 
+	// Identifiers of users that must not get this message, even as members
+	// of one of its groups
+	ExcludedUsers []string `json:"excludedUsers,omitempty" firestore:"excludedUsers,omitempty"`
+
+	// Identifiers of groups whose members must not get this message
+	ExcludedGroups []string `json:"excludedGroups,omitempty" firestore:"excludedGroups,omitempty"`
+
 	// How the user should be notified of this new item, if at all
 	NotificationChannels []Channel `json:"notificationChannels,omitempty" firestore:"notificationChannels,omitempty"`
 
+	// Text/Message the user will see in their notifications body when an action is performed on an item
+	NotificationBody NotificationBody `json:"notificationBody,omitempty" firestore:"notificationBody,omitempty"`
+
+	// Translations of the tagline, keyed by language tag e.g sw
+	LocalisedTagline LocalisedText `json:"localisedTagline,omitempty" firestore:"localisedTagline,omitempty"`
+
+	// Translations of the text, keyed by language tag e.g sw
+	LocalisedText LocalisedText `json:"localisedText,omitempty" firestore:"localisedText,omitempty"`
+
+	// Translations of the notification body, keyed by language tag e.g sw
+	LocalisedNotificationBody LocalisedNotificationBody `json:"localisedNotificationBody,omitempty" firestore:"localisedNotificationBody,omitempty"`
+
 	// FeatureImage represents the image associated to a post
 	FeatureImage string `json:"feature_image"`
 }
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (it *Item) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(ItemSchemaFile, b, it)
+func (it *Item) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(ItemSchemaFile, b, it, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid item JSON: %w", err)
 	}
-
-	if it.Icon.LinkType != LinkTypePngImage {
-		return fmt.Errorf("an icon must be a PNG image")
-	}
-
-	return nil
+	return it.validate()
 }
 
 // ValidateAndMarshal validates against JSON schema then marshals to JSON
 func (it *Item) ValidateAndMarshal() ([]byte, error) {
-	if it.Icon.LinkType != LinkTypePngImage {
-		return nil, fmt.Errorf("an icon must be a PNG image")
+	if err := it.validate(); err != nil {
+		return nil, err
 	}
 
 	return ValidateAndMarshal(ItemSchemaFile, it)
@@ -364,6 +446,39 @@ func (it *Item) ValidateAndMarshal() ([]byte, error) {
 // IsEntity marks this as an Apollo federation GraphQL entity
 func (it Item) IsEntity() {}
 
+// validate runs the checks that the item schema can't express
+func (it *Item) validate() error {
+	if err := it.validateIcon(); err != nil {
+		return err
+	}
+	return validatePublishWindow(ItemSchemaFile, it.PublishAt, it.Expiry)
+}
+
+func (it *Item) validateIcon() error {
+	if it.Icon.LinkType != LinkTypePngImage {
+		return newValidationError(ItemSchemaFile, FieldViolation{
+			Field:       "/icon/linkType",
+			Rule:        RulePNGIcon,
+			Value:       it.Icon.LinkType,
+			Description: "an icon must be a PNG image",
+		})
+	}
+	return nil
+}
+
+// validatePublishWindow checks that an element is published before it expires
+func validatePublishWindow(sch string, publishAt *time.Time, expiry time.Time) error {
+	if publishAt == nil || expiry.IsZero() || publishAt.Before(expiry) {
+		return nil
+	}
+	return newValidationError(sch, FieldViolation{
+		Field:       "/publishAt",
+		Rule:        RulePublishWindow,
+		Value:       publishAt.Format(time.RFC3339),
+		Description: "an element must be published before it expires",
+	})
+}
+
 // Message is a message in a thread of conversations attached to a feed item
 type Message struct {
 	// A unique identifier for each message on the thread
@@ -390,8 +505,8 @@ type Message struct {
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (msg *Message) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(MessageSchemaFile, b, msg)
+func (msg *Message) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(MessageSchemaFile, b, msg, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid message JSON: %w", err)
 	}
@@ -427,35 +542,24 @@ type Link struct {
 	Thumbnail string `json:"thumbnail" firestore:"thumbnail"`
 }
 
+// validateLinkType checks that the link's URL is an http(s) URL that suits
+// the link's type e.g that a PDF link's path ends with .pdf
 func (l *Link) validateLinkType() error {
-	if !govalidator.IsURL(l.URL) {
-		return fmt.Errorf("%s is not a valid URL", l.URL)
-	}
-	switch l.LinkType {
-	case LinkTypePdfDocument:
-		if !strings.Contains(l.URL, ".png") {
-			return fmt.Errorf("%s does not end with .pdf", l.URL)
-		}
-	case LinkTypeMp4:
-		if !strings.Contains(l.URL, ".mp4") {
-			return fmt.Errorf("%s does not end with .mp4", l.URL)
-		}
-	case LinkTypePngImage:
-		if !strings.Contains(l.URL, ".png") {
-			return fmt.Errorf("%s does not end with .png", l.URL)
-		}
-	case LinkTypeYoutubeVideo:
-		if !strings.Contains(l.URL, "youtube.com") {
-			return fmt.Errorf("%s is not a youtube.com URL", l.URL)
-		}
+	if err := checkLinkURL(l.URL, l.LinkType); err != nil {
+		return newValidationError(LinkSchemaFile, FieldViolation{
+			Field:       "/url",
+			Rule:        RuleLinkType,
+			Value:       l.URL,
+			Description: err.Error(),
+		})
 	}
 	return nil
 }
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (l *Link) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(LinkSchemaFile, b, l)
+func (l *Link) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(LinkSchemaFile, b, l, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid video JSON: %w", err)
 	}
@@ -731,6 +835,11 @@ const (
 	LinkTypeSvgImage     LinkType = "SVG_IMAGE"
 	LinkTypeMp4          LinkType = "MP4"
 	LinkTypeDefault      LinkType = "DEFAULT"
+	LinkTypeAudio        LinkType = "AUDIO"
+	LinkTypeJpegImage    LinkType = "JPEG_IMAGE"
+	LinkTypeWebpImage    LinkType = "WEBP_IMAGE"
+	LinkTypeWebPage      LinkType = "WEB_PAGE"
+	LinkTypeDeepLink     LinkType = "DEEP_LINK"
 )
 
 // AllLinkType is the set of all known link types
@@ -741,12 +850,18 @@ var AllLinkType = []LinkType{
 	LinkTypeSvgImage,
 	LinkTypeMp4,
 	LinkTypeDefault,
+	LinkTypeAudio,
+	LinkTypeJpegImage,
+	LinkTypeWebpImage,
+	LinkTypeWebPage,
+	LinkTypeDeepLink,
 }
 
 // IsValid is true only when a link type is avalid
 func (e LinkType) IsValid() bool {
 	switch e {
-	case LinkTypeYoutubeVideo, LinkTypePngImage, LinkTypePdfDocument, LinkTypeSvgImage, LinkTypeMp4, LinkTypeDefault:
+	case LinkTypeYoutubeVideo, LinkTypePngImage, LinkTypePdfDocument, LinkTypeSvgImage, LinkTypeMp4, LinkTypeDefault,
+		LinkTypeAudio, LinkTypeJpegImage, LinkTypeWebpImage, LinkTypeWebPage, LinkTypeDeepLink:
 		return true
 	}
 	return false
@@ -930,13 +1045,18 @@ const (
 	BooleanFilterBoth  BooleanFilter = "BOTH"
 )
 
-// IsValid is a set of known boolean filters
-var IsValid = []BooleanFilter{
+// AllBooleanFilter is the set of known boolean filters
+var AllBooleanFilter = []BooleanFilter{
 	BooleanFilterTrue,
 	BooleanFilterFalse,
 	BooleanFilterBoth,
 }
 
+// IsValid is a set of known boolean filters.
+//
+// Deprecated: use AllBooleanFilter.
+var IsValid = AllBooleanFilter
+
 // IsValid returns True if the boolean filter value is valid
 func (e BooleanFilter) IsValid() bool {
 	switch e {
@@ -983,7 +1103,7 @@ func GetPNGImageLink(url string, title string, description string, thumbnailURL
 	}
 }
 
-// GetSVGImageLink returns an initialized PNG image link.
+// GetSVGImageLink returns an initialized SVG image link.
 //
 // It is used in testing and default data generation.
 func GetSVGImageLink(url string, title string, description string, thumbnailURL string) Link {
@@ -1039,84 +1159,91 @@ func GetMP4Link(url string, title string, description string, thumbnailURL strin
 	}
 }
 
-// getSchemaURL serves JSON schema from this server and only falls back to a
-// remote schema host when the local server cannot serve the JSON schema files.
-// This has been done so as to reduce the impact of the network and DNS on the
-// schema validation process - a critical path activity.
-func getSchemaURL() string {
-	schemaHost, err := sv.GetEnvVar(SchemaHostEnvVarName)
-	if err != nil {
-		log.Printf("can't get env var `%s`: %s", SchemaHostEnvVarName, err)
+// GetAudioLink returns an initialized MP3 or AAC audio link.
+//
+// It is used in testing and default data generation.
+func GetAudioLink(url string, title string, description string, thumbnailURL string) Link {
+	return Link{
+		ID:          ksuid.New().String(),
+		URL:         url,
+		LinkType:    LinkTypeAudio,
+		Title:       title,
+		Description: description,
+		Thumbnail:   thumbnailURL,
 	}
+}
 
-	client := http.Client{
-		Timeout: time.Second * 1, // aggressive timeout
+// GetJPEGImageLink returns an initialized JPEG image link.
+//
+// It is used in testing and default data generation.
+func GetJPEGImageLink(url string, title string, description string, thumbnailURL string) Link {
+	return Link{
+		ID:          ksuid.New().String(),
+		URL:         url,
+		LinkType:    LinkTypeJpegImage,
+		Title:       title,
+		Description: description,
+		Thumbnail:   thumbnailURL,
 	}
-	req, err := http.NewRequest(http.MethodGet, schemaHost, nil)
-	if err != nil {
-		log.Printf("can't create request to local schema URL: %s", err)
+}
+
+// GetWebPImageLink returns an initialized WebP image link.
+//
+// It is used in testing and default data generation.
+func GetWebPImageLink(url string, title string, description string, thumbnailURL string) Link {
+	return Link{
+		ID:          ksuid.New().String(),
+		URL:         url,
+		LinkType:    LinkTypeWebpImage,
+		Title:       title,
+		Description: description,
+		Thumbnail:   thumbnailURL,
 	}
-	if err == nil {
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("error accessing schema URL: %s", err)
-		}
-		if err == nil {
-			if resp.StatusCode != http.StatusOK {
-				log.Printf("schema URL error status code: %s", resp.Status)
-			}
-			if resp.StatusCode == http.StatusOK {
-				return schemaHost // we want this case to be the most common
-			}
-		}
+}
+
+// GetWebPageLink returns an initialized web page link.
+//
+// It is used in testing and default data generation.
+func GetWebPageLink(url string, title string, description string, thumbnailURL string) Link {
+	return Link{
+		ID:          ksuid.New().String(),
+		URL:         url,
+		LinkType:    LinkTypeWebPage,
+		Title:       title,
+		Description: description,
+		Thumbnail:   thumbnailURL,
 	}
+}
 
-	// fall back to an externally hosted schema
-	return FallbackSchemaHost
+// GetDeepLink returns an initialized in-app deep link e.g bewell://profile.
+//
+// It is used in testing and default data generation.
+func GetDeepLink(url string, title string, description string, thumbnailURL string) Link {
+	return Link{
+		ID:          ksuid.New().String(),
+		URL:         url,
+		LinkType:    LinkTypeDeepLink,
+		Title:       title,
+		Description: description,
+		Thumbnail:   thumbnailURL,
+	}
 }
 
 func validateAgainstSchema(sch string, b []byte) error {
-	schemaURL := fmt.Sprintf("%s/%s", getSchemaURL(), sch)
-	schemaLoader := gojsonschema.NewReferenceLoader(schemaURL)
+	schema, err := DefaultSchemaRegistry.Get(sch)
+	if err != nil {
+		return fmt.Errorf("can't load schema %s: %w", sch, err)
+	}
 	documentLoader := gojsonschema.NewStringLoader(string(b))
-	result, err := gojsonschema.Validate(schemaLoader, documentLoader)
+	result, err := schema.Validate(documentLoader)
 	if err != nil {
-		return fmt.Errorf(
-			"failed to validate `%s` against %s, got %#v: %w",
-			string(b),
-			sch,
-			result,
-			err,
-		)
+		return newValidationError(sch, FieldViolation{
+			Rule:        RuleInvalidJSON,
+			Description: err.Error(),
+		})
 	}
 	if !result.Valid() {
-		errMsgs := []string{}
-		for _, vErr := range result.Errors() {
-			errType := vErr.Type()
-			val := vErr.Value()
-			context := vErr.Context().String()
-			field := vErr.Field()
-			desc := vErr.Description()
-			descFormat := vErr.DescriptionFormat()
-			details := vErr.Details()
-			errMsg := fmt.Sprintf(
-				"errType: %s\nval: %s\ncontext: %s\nfield: %s\ndesc: %s\ndescFormat: %s\ndetails: %s\n",
-				errType,
-				val,
-				context,
-				field,
-				desc,
-				descFormat,
-				details,
-			)
-			errMsgs = append(errMsgs, errMsg)
-		}
-		return fmt.Errorf(
-			"the result of validating `%s` against %s is not valid: %#v",
-			string(b),
-			sch,
-			errMsgs,
-		)
+		return newSchemaValidationError(sch, result)
 	}
 	return nil
 }
@@ -1144,8 +1271,8 @@ type NotificationBody struct {
 
 // ValidateAndUnmarshal checks that the input data is valid as per the
 // relevant JSON schema and unmarshals it if it is
-func (nb *NotificationBody) ValidateAndUnmarshal(b []byte) error {
-	err := ValidateAndUnmarshal(NotificationBodySchemaFile, b, nb)
+func (nb *NotificationBody) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(NotificationBodySchemaFile, b, nb, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid notification body JSON: %w", err)
 	}
@@ -1156,6 +1283,3 @@ func (nb *NotificationBody) ValidateAndUnmarshal(b []byte) error {
 func (nb *NotificationBody) ValidateAndMarshal() ([]byte, error) {
 	return ValidateAndMarshal(NotificationBodySchemaFile, nb)
 }
-
-// IsEntity marks this as an Apollo federation GraphQL entity
-func (nb *NotificationBody) IsEntity() {}
@@ -2393,7 +2393,7 @@ func TestValidateAndUnmarshal(t *testing.T) {
 	}
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
-		t.Errorf("can't marshal message to JSON: %w", err)
+		t.Errorf("can't marshal message to JSON: %v", err)
 		return
 	}
 	type args struct {
@@ -0,0 +1,180 @@
+package feedlib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ValidationErrorCode is the GraphQL error extension code used for feed
+// element validation failures
+const ValidationErrorCode = "VALIDATION_FAILED"
+
+// rule names for violations that are detected outside the JSON schema
+const (
+	RuleInvalidJSON   = "invalid_json"
+	RuleLinkType      = "link_type"
+	RulePNGIcon       = "png_icon"
+	RuleEventName     = "event_name"
+	RulePublishWindow = "publish_window"
+)
+
+// FieldViolation describes one way in which a feed element breaks its rules
+type FieldViolation struct {
+	// A JSON pointer (RFC 6901) to the offending field e.g /icon/linkType.
+	// An empty pointer refers to the whole document.
+	Field string `json:"field"`
+
+	// The rule that was broken e.g required, enum, invalid_type
+	Rule string `json:"rule"`
+
+	// The offending value, if any
+	Value interface{} `json:"value,omitempty"`
+
+	// A human readable description of the violation
+	Description string `json:"description"`
+}
+
+func (v FieldViolation) String() string {
+	if v.Field == "" {
+		return v.Description
+	}
+	return fmt.Sprintf("%s: %s", v.Field, v.Description)
+}
+
+// ValidationError is returned when a feed element fails validation.
+//
+// It is wrapped by the errors that ValidateAndUnmarshal and ValidateAndMarshal
+// return, so callers should use errors.As to get at it.
+type ValidationError struct {
+	// The name of the schema file that the element was validated against.
+	// It is empty when an event's payload fails its registered schema.
+	Schema string `json:"schema"`
+
+	// The name of the event whose payload failed its registered schema, if
+	// any
+	Event string `json:"event,omitempty"`
+
+	// What exactly was wrong with the element
+	Violations []FieldViolation `json:"violations"`
+}
+
+// newValidationError initializes a validation error
+func newValidationError(sch string, violations ...FieldViolation) *ValidationError {
+	return &ValidationError{
+		Schema:     sch,
+		Violations: violations,
+	}
+}
+
+func (e *ValidationError) Error() string {
+	msgs := []string{}
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.String())
+	}
+	if e.Event != "" {
+		return fmt.Sprintf(
+			"the payload of the %s event is not valid: %s",
+			e.Event,
+			strings.Join(msgs, "; "),
+		)
+	}
+	return fmt.Sprintf(
+		"the result of validating against %s is not valid: %s",
+		e.Schema,
+		strings.Join(msgs, "; "),
+	)
+}
+
+// GQLExtensions returns the validation error in a form that is suitable for
+// use as GraphQL error extensions e.g gqlerror.Error.Extensions
+func (e *ValidationError) GQLExtensions() map[string]interface{} {
+	violations := []map[string]interface{}{}
+	for _, v := range e.Violations {
+		violation := map[string]interface{}{
+			"field":       v.Field,
+			"rule":        v.Rule,
+			"description": v.Description,
+		}
+		if v.Value != nil {
+			violation["value"] = v.Value
+		}
+		violations = append(violations, violation)
+	}
+	extensions := map[string]interface{}{
+		"code":       ValidationErrorCode,
+		"schema":     e.Schema,
+		"violations": violations,
+	}
+	if e.Event != "" {
+		extensions["event"] = e.Event
+	}
+	return extensions
+}
+
+// newSchemaValidationError translates a failed gojsonschema result into a
+// validation error
+func newSchemaValidationError(sch string, result *gojsonschema.Result) *ValidationError {
+	violations := []FieldViolation{}
+	for _, vErr := range result.Errors() {
+		field := jsonPointer(vErr.Context())
+		value := vErr.Value()
+		if vErr.Type() == "required" {
+			// the value of a "required" error is the parent object, which
+			// is more noise than signal; point at the missing field instead
+			field = fmt.Sprintf("%s/%s", field, escapeJSONPointer(
+				fmt.Sprintf("%v", vErr.Details()["property"])))
+			value = nil
+		}
+		violations = append(violations, FieldViolation{
+			Field:       field,
+			Rule:        vErr.Type(),
+			Value:       value,
+			Description: vErr.Description(),
+		})
+	}
+	return newValidationError(sch, violations...)
+}
+
+// jsonPointer converts a gojsonschema context e.g (root).icon.linkType into a
+// JSON pointer e.g /icon/linkType.
+//
+// The context only exposes its parts joined by a delimiter, and an object key
+// may contain any character, including the delimiter. The context is joined
+// twice with different delimiters, so the parts are split wherever the two
+// strings differ; a delimiter inside a key is the same in both.
+func jsonPointer(ctx *gojsonschema.JsonContext) string {
+	if ctx == nil {
+		return ""
+	}
+	first, second := ctx.String("\x00"), ctx.String("\x01")
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(first); i++ {
+		if first[i] != second[i] {
+			parts = append(parts, first[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, first[start:])
+
+	pointer := ""
+	for _, part := range parts[1:] { // the first part is always (root)
+		pointer += "/" + escapeJSONPointer(part)
+	}
+	return pointer
+}
+
+// escapeJSONPointer escapes a JSON pointer reference token as per RFC 6901
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// ErrElementNotFound is returned when a feed element can't be found
+var ErrElementNotFound = errors.New("feed element not found")
+
+// ErrDuplicateElement is returned when adding a feed element whose ID is
+// already in use
+var ErrDuplicateElement = errors.New("duplicate feed element")
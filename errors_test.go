@@ -0,0 +1,232 @@
+package feedlib_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationError_ValidateAndUnmarshal(t *testing.T) {
+	tests := []struct {
+		name           string
+		b              []byte
+		el             feedlib.Element
+		wantSchema     string
+		wantViolations []feedlib.FieldViolation
+	}{
+		{
+			name:       "missing required field",
+			b:          []byte(`{"url": "https://example.com/a.png", "linkType": "PNG_IMAGE"}`),
+			el:         &feedlib.Link{},
+			wantSchema: feedlib.LinkSchemaFile,
+			wantViolations: []feedlib.FieldViolation{
+				{
+					Field:       "/id",
+					Rule:        "required",
+					Description: "id is required",
+				},
+			},
+		},
+		{
+			name: "nested enum violation",
+			b: []byte(`{
+				"id": "action-1",
+				"sequenceNumber": 1,
+				"name": "First action",
+				"icon": {
+					"id": "icon-1",
+					"url": "https://example.com/a.png",
+					"linkType": "GIF_IMAGE"
+				},
+				"actionType": "PRIMARY",
+				"handling": "INLINE"
+			}`),
+			el:         &feedlib.Action{},
+			wantSchema: feedlib.ActionSchemaFile,
+			wantViolations: []feedlib.FieldViolation{
+				{
+					Field:       "/icon/linkType",
+					Rule:        "enum",
+					Value:       "GIF_IMAGE",
+					Description: `icon.linkType must be one of the following: "YOUTUBE_VIDEO", "PNG_IMAGE", "PDF_DOCUMENT", "SVG_IMAGE", "MP4", "DEFAULT", "AUDIO", "JPEG_IMAGE", "WEBP_IMAGE", "WEB_PAGE", "DEEP_LINK"`,
+				},
+			},
+		},
+		{
+			name:       "not JSON at all",
+			b:          []byte("this should not pass validation"),
+			el:         &feedlib.Message{},
+			wantSchema: feedlib.MessageSchemaFile,
+			wantViolations: []feedlib.FieldViolation{
+				{
+					Rule:        feedlib.RuleInvalidJSON,
+					Description: "invalid character 'h' in literal true (expecting 'r')",
+				},
+			},
+		},
+		{
+			name:       "link URL does not match link type",
+			b:          []byte(`{"id": "link-1", "url": "https://example.com/a.mp4", "linkType": "YOUTUBE_VIDEO"}`),
+			el:         &feedlib.Link{},
+			wantSchema: feedlib.LinkSchemaFile,
+			wantViolations: []feedlib.FieldViolation{
+				{
+					Field:       "/url",
+					Rule:        feedlib.RuleLinkType,
+					Value:       "https://example.com/a.mp4",
+					Description: "https://example.com/a.mp4 is not a YouTube URL",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.el.ValidateAndUnmarshal(tt.b)
+			assert.NotNil(t, err)
+
+			var vErr *feedlib.ValidationError
+			if !errors.As(err, &vErr) {
+				t.Errorf("expected a *feedlib.ValidationError, got %T", err)
+				return
+			}
+			assert.Equal(t, tt.wantSchema, vErr.Schema)
+			assert.Equal(t, tt.wantViolations, vErr.Violations)
+		})
+	}
+}
+
+func TestValidationError_KeyFields(t *testing.T) {
+	// object keys may contain anything, including the characters that JSON
+	// pointers escape and NUL
+	b := []byte(`{
+		"id": "nudge-1",
+		"sequenceNumber": 1,
+		"visibility": "SHOW",
+		"status": "PENDING",
+		"expiry": "2030-01-01T00:00:00Z",
+		"title": "Update your profile!",
+		"text": "Help us serve you better!",
+		"links": [],
+		"actions": [],
+		"localisedTitle": {"en": "Update your profile!", "sw\u0000a/b~c.d": 1}
+	}`)
+	err := (&feedlib.Nudge{}).ValidateAndUnmarshal(b)
+	var vErr *feedlib.ValidationError
+	if !errors.As(err, &vErr) {
+		t.Fatalf("expected a *feedlib.ValidationError, got %v", err)
+	}
+	fields := []string{}
+	for _, v := range vErr.Violations {
+		fields = append(fields, v.Field)
+	}
+	assert.Contains(t, fields, "/localisedTitle/sw\x00a~1b~0c.d")
+}
+
+func TestValidationError_ValidateAndMarshal(t *testing.T) {
+	it := &feedlib.Item{
+		Icon: feedlib.GetSVGImageLink(
+			"https://example.com/a.svg", "title", "description", feedlib.BlankImageURL),
+	}
+	_, err := it.ValidateAndMarshal()
+	assert.NotNil(t, err)
+
+	var vErr *feedlib.ValidationError
+	assert.True(t, errors.As(err, &vErr))
+	assert.Equal(t, []feedlib.FieldViolation{
+		{
+			Field:       "/icon/linkType",
+			Rule:        feedlib.RulePNGIcon,
+			Value:       feedlib.LinkTypeSvgImage,
+			Description: "an icon must be a PNG image",
+		},
+	}, vErr.Violations)
+
+	msg := &feedlib.Message{}
+	_, err = msg.ValidateAndMarshal()
+	assert.NotNil(t, err)
+	assert.True(t, errors.As(err, &vErr))
+	assert.Equal(t, feedlib.MessageSchemaFile, vErr.Schema)
+	assert.NotEmpty(t, vErr.Violations)
+	for _, v := range vErr.Violations {
+		assert.NotEmpty(t, v.Field)
+		assert.NotEmpty(t, v.Rule)
+		assert.NotEmpty(t, v.Description)
+	}
+}
+
+func TestValidationError_Error(t *testing.T) {
+	vErr := &feedlib.ValidationError{
+		Schema: feedlib.LinkSchemaFile,
+		Violations: []feedlib.FieldViolation{
+			{
+				Field:       "/id",
+				Rule:        "required",
+				Description: "id is required",
+			},
+			{
+				Rule:        feedlib.RuleInvalidJSON,
+				Description: "unexpected end of JSON input",
+			},
+		},
+	}
+	assert.Equal(
+		t,
+		"the result of validating against link.schema.json is not valid: /id: id is required; unexpected end of JSON input",
+		vErr.Error(),
+	)
+
+	vErr = &feedlib.ValidationError{
+		Event: "ITEM_COUNTED",
+		Violations: []feedlib.FieldViolation{
+			{
+				Field:       "/payload/data/itemID",
+				Rule:        "required",
+				Description: "itemID is required",
+			},
+		},
+	}
+	assert.Equal(
+		t,
+		"the payload of the ITEM_COUNTED event is not valid: /payload/data/itemID: itemID is required",
+		vErr.Error(),
+	)
+	assert.Equal(t, "ITEM_COUNTED", vErr.GQLExtensions()["event"])
+}
+
+func TestValidationError_GQLExtensions(t *testing.T) {
+	vErr := &feedlib.ValidationError{
+		Schema: feedlib.ActionSchemaFile,
+		Violations: []feedlib.FieldViolation{
+			{
+				Field:       "/actionType",
+				Rule:        "enum",
+				Value:       "BOGUS",
+				Description: "actionType must be one of the following: ...",
+			},
+			{
+				Field:       "/id",
+				Rule:        "required",
+				Description: "id is required",
+			},
+		},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"code":   feedlib.ValidationErrorCode,
+		"schema": feedlib.ActionSchemaFile,
+		"violations": []map[string]interface{}{
+			{
+				"field":       "/actionType",
+				"rule":        "enum",
+				"value":       "BOGUS",
+				"description": "actionType must be one of the following: ...",
+			},
+			{
+				"field":       "/id",
+				"rule":        "required",
+				"description": "id is required",
+			},
+		},
+	}, vErr.GQLExtensions())
+}
@@ -0,0 +1,143 @@
+package feedlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// eventNamePattern matches two upper case words separated by an underscore
+// e.g ITEM_RESOLVED. It must be kept in sync with the event schema.
+var eventNamePattern = regexp.MustCompile(`^[A-Z]+_[A-Z]+$`)
+
+// IsValidEventName returns true if the name is two upper case words
+// separated by an underscore e.g ITEM_RESOLVED
+func IsValidEventName(name string) bool {
+	return eventNamePattern.MatchString(name)
+}
+
+// EventRegistry holds the JSON schemas that the payloads of known events
+// must conform to. It is safe for concurrent use.
+//
+// Events whose names are not registered are not checked beyond the event
+// schema, so that services only need to register the events they own.
+type EventRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string]*gojsonschema.Schema
+}
+
+// NewEventRegistry initializes an empty event registry
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{
+		schemas: map[string]*gojsonschema.Schema{},
+	}
+}
+
+// DefaultEventRegistry is the registry that Event.ValidateAndUnmarshal and
+// Event.ValidateAndMarshal check event payloads against
+var DefaultEventRegistry = NewEventRegistry()
+
+// Register records the JSON schema that the payload data of the named event
+// must conform to. The schema must be self contained: it can't `$ref` the
+// schemas that ship with the library.
+//
+// Registering a name that is already registered replaces its schema.
+func (r *EventRegistry) Register(name string, payloadSchema []byte) error {
+	if !IsValidEventName(name) {
+		return fmt.Errorf(
+			"%s is not a valid event name: expected two upper case words separated by an underscore",
+			name,
+		)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(payloadSchema))
+	if err != nil {
+		return fmt.Errorf("can't compile payload schema for %s: %w", name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.schemas[name] = schema
+	return nil
+}
+
+// Unregister forgets the named event
+func (r *EventRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.schemas, name)
+}
+
+// IsRegistered returns true if a payload schema is registered for the event
+func (r *EventRegistry) IsRegistered(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.schemas[name]
+	return ok
+}
+
+// Names returns the registered event names, sorted
+func (r *EventRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := []string{}
+	for name := range r.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks the event's name and, if the event is registered, its
+// payload data.
+//
+// Violations are reported as a *ValidationError whose fields point into the
+// event e.g /payload/data/itemID.
+func (r *EventRegistry) Validate(ev Event) error {
+	if !IsValidEventName(ev.Name) {
+		return newValidationError(EventSchemaFile, FieldViolation{
+			Field:       "/name",
+			Rule:        RuleEventName,
+			Value:       ev.Name,
+			Description: "the event name must be two upper case words separated by an underscore",
+		})
+	}
+
+	r.mu.RLock()
+	schema, ok := r.schemas[ev.Name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(ev.Payload.Data)
+	if err != nil {
+		return fmt.Errorf("can't marshal the payload of %s to JSON: %w", ev.Name, err)
+	}
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		vErr := newValidationError("", FieldViolation{
+			Field:       "/payload/data",
+			Rule:        RuleInvalidJSON,
+			Description: err.Error(),
+		})
+		vErr.Event = ev.Name
+		return vErr
+	}
+	if !result.Valid() {
+		vErr := newSchemaValidationError("", result)
+		vErr.Event = ev.Name
+		for i := range vErr.Violations {
+			vErr.Violations[i].Field = "/payload/data" + vErr.Violations[i].Field
+		}
+		return vErr
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package feedlib_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPayloadSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {
+    "itemID": {"type": "string", "minLength": 1},
+    "count": {"type": "integer"}
+  },
+  "required": ["itemID"]
+}`
+
+func getTestEvent(name string, data map[string]interface{}) feedlib.Event {
+	ctx := getTestContext()
+	ctx.Timestamp = time.Now()
+	return feedlib.Event{
+		ID:      "event-1",
+		Name:    name,
+		Context: ctx,
+		Payload: feedlib.Payload{
+			Data: data,
+		},
+	}
+}
+
+func TestIsValidEventName(t *testing.T) {
+	valid := []string{"ITEM_RESOLVED", "THIS_EVENT", "A_B"}
+	invalid := []string{"", "ITEM", "item_resolved", "ITEM_RESOLVED_NOW", "ITEM-RESOLVED", "ITEM_", "_ITEM", "ITEM_1"}
+	for _, name := range valid {
+		assert.True(t, feedlib.IsValidEventName(name), "%s should be valid", name)
+	}
+	for _, name := range invalid {
+		assert.False(t, feedlib.IsValidEventName(name), "%s should be invalid", name)
+	}
+}
+
+func TestEventRegistry_Register(t *testing.T) {
+	r := feedlib.NewEventRegistry()
+
+	assert.NotNil(t, r.Register("not_valid", []byte(testPayloadSchema)))
+	assert.NotNil(t, r.Register("ITEM_COUNTED", []byte("{not json")))
+	assert.Empty(t, r.Names())
+
+	assert.Nil(t, r.Register("ITEM_COUNTED", []byte(testPayloadSchema)))
+	assert.Nil(t, r.Register("ITEM_ADDED", []byte(`{}`)))
+	assert.True(t, r.IsRegistered("ITEM_COUNTED"))
+	assert.Equal(t, []string{"ITEM_ADDED", "ITEM_COUNTED"}, r.Names())
+
+	r.Unregister("ITEM_ADDED")
+	assert.False(t, r.IsRegistered("ITEM_ADDED"))
+}
+
+func TestEventRegistry_Validate(t *testing.T) {
+	r := feedlib.NewEventRegistry()
+	assert.Nil(t, r.Register("ITEM_COUNTED", []byte(testPayloadSchema)))
+
+	tests := []struct {
+		name      string
+		ev        feedlib.Event
+		wantField string
+	}{
+		{
+			name: "valid registered event",
+			ev:   getTestEvent("ITEM_COUNTED", map[string]interface{}{"itemID": "item-1", "count": 2}),
+		},
+		{
+			name: "unregistered events are not checked",
+			ev:   getTestEvent("ITEM_ADDED", map[string]interface{}{"anything": true}),
+		},
+		{
+			name:      "invalid name",
+			ev:        getTestEvent("item added", nil),
+			wantField: "/name",
+		},
+		{
+			name:      "missing payload field",
+			ev:        getTestEvent("ITEM_COUNTED", map[string]interface{}{"count": 2}),
+			wantField: "/payload/data/itemID",
+		},
+		{
+			name:      "wrong payload field type",
+			ev:        getTestEvent("ITEM_COUNTED", map[string]interface{}{"itemID": "item-1", "count": "two"}),
+			wantField: "/payload/data/count",
+		},
+		{
+			name:      "nil payload",
+			ev:        getTestEvent("ITEM_COUNTED", nil),
+			wantField: "/payload/data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.Validate(tt.ev)
+			if tt.wantField == "" {
+				assert.Nil(t, err)
+				return
+			}
+			var vErr *feedlib.ValidationError
+			if !errors.As(err, &vErr) {
+				t.Errorf("expected a *feedlib.ValidationError, got %v", err)
+				return
+			}
+			assert.Len(t, vErr.Violations, 1)
+			assert.Equal(t, tt.wantField, vErr.Violations[0].Field)
+		})
+	}
+}
+
+func TestEvent_ValidateAgainstDefaultEventRegistry(t *testing.T) {
+	assert.Nil(t, feedlib.DefaultEventRegistry.Register("ITEM_COUNTED", []byte(testPayloadSchema)))
+	t.Cleanup(func() {
+		feedlib.DefaultEventRegistry.Unregister("ITEM_COUNTED")
+	})
+
+	valid := getTestEvent("ITEM_COUNTED", map[string]interface{}{"itemID": "item-1"})
+	validBytes, err := valid.ValidateAndMarshal()
+	assert.Nil(t, err)
+
+	ev := &feedlib.Event{}
+	assert.Nil(t, ev.ValidateAndUnmarshal(validBytes))
+	assert.Equal(t, "item-1", ev.Payload.Data["itemID"])
+
+	invalid := getTestEvent("ITEM_COUNTED", map[string]interface{}{"count": 1})
+	_, err = invalid.ValidateAndMarshal()
+	assert.NotNil(t, err)
+
+	invalidBytes, err := json.Marshal(invalid)
+	assert.Nil(t, err)
+	ev = &feedlib.Event{}
+	err = ev.ValidateAndUnmarshal(invalidBytes)
+	var vErr *feedlib.ValidationError
+	assert.True(t, errors.As(err, &vErr))
+	assert.Equal(t, "ITEM_COUNTED", vErr.Event)
+	assert.Empty(t, vErr.Schema)
+	assert.Empty(t, ev.Name, "an invalid event should not be unmarshalled")
+
+	badName := getTestEvent("item counted", map[string]interface{}{"itemID": "item-1"})
+	badNameBytes, err := json.Marshal(badName)
+	assert.Nil(t, err)
+	err = ev.ValidateAndUnmarshal(badNameBytes)
+	assert.True(t, errors.As(err, &vErr))
+	assert.Equal(t, "/name", vErr.Violations[0].Field)
+	assert.Equal(t, feedlib.EventSchemaFile, vErr.Schema)
+	assert.Empty(t, vErr.Event)
+}
@@ -0,0 +1,464 @@
+package feedlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ExpiryPolicy determines what happens to elements once they expire
+type ExpiryPolicy string
+
+// known expiry policies
+const (
+	ExpiryPolicyHide   ExpiryPolicy = "HIDE"
+	ExpiryPolicyDelete ExpiryPolicy = "DELETE"
+)
+
+// AllExpiryPolicy is the set of all known expiry policies
+var AllExpiryPolicy = []ExpiryPolicy{
+	ExpiryPolicyHide,
+	ExpiryPolicyDelete,
+}
+
+// IsValid returns true only for valid expiry policies
+func (e ExpiryPolicy) IsValid() bool {
+	switch e {
+	case ExpiryPolicyHide, ExpiryPolicyDelete:
+		return true
+	}
+	return false
+}
+
+func (e ExpiryPolicy) String() string {
+	return string(e)
+}
+
+// UnmarshalGQL reads and validates an expiry policy from the supplied input
+func (e *ExpiryPolicy) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ExpiryPolicy(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ExpiryPolicy", str)
+	}
+	return nil
+}
+
+// MarshalGQL writes the expiry policy to the supplied writer
+func (e ExpiryPolicy) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// names of the events that are emitted when elements expire
+const (
+	EventNameItemExpired  = "ITEM_EXPIRED"
+	EventNameNudgeExpired = "NUDGE_EXPIRED"
+)
+
+// IsExpired returns true if the item has an expiry that is not after now
+func (it Item) IsExpired(now time.Time) bool {
+	return !it.Expiry.IsZero() && !it.Expiry.After(now)
+}
+
+// IsExpired returns true if the nudge has an expiry that is not after now
+func (nu Nudge) IsExpired(now time.Time) bool {
+	return !nu.Expiry.IsZero() && !nu.Expiry.After(now)
+}
+
+// ExpirySweeper finds expired items and nudges and hides or deletes them
+// according to its policy.
+//
+// An event is emitted for every element that is expired. Elements that are
+// hidden get the next sequence number in their feed. Elements that are
+// already hidden are left alone by the hide policy, so sweeping is idempotent.
+type ExpirySweeper struct {
+	policy ExpiryPolicy
+	alloc  SequenceAllocator
+	now    func() time.Time
+}
+
+// NewExpirySweeper initializes an expiry sweeper that takes sequence numbers
+// from the supplied allocator and uses the supplied clock to decide what has
+// expired. A nil allocator means DefaultSequenceAllocator and a nil clock
+// means time.Now.
+func NewExpirySweeper(
+	policy ExpiryPolicy,
+	alloc SequenceAllocator,
+	now func() time.Time,
+) (*ExpirySweeper, error) {
+	if !policy.IsValid() {
+		return nil, fmt.Errorf("%s is not a valid ExpiryPolicy", policy)
+	}
+	if alloc == nil {
+		alloc = DefaultSequenceAllocator
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &ExpirySweeper{
+		policy: policy,
+		alloc:  alloc,
+		now:    now,
+	}, nil
+}
+
+// Policy returns the sweeper's expiry policy
+func (s *ExpirySweeper) Policy() ExpiryPolicy {
+	return s.policy
+}
+
+// SweepItems expires the items in a slice.
+//
+// The returned slice holds the items that remain: with the hide policy
+// expired items are hidden, with the delete policy they are dropped. If a
+// sequence number can't be allocated, only the error is returned.
+// Hidden items take new sequence numbers from the feed that is owned by the
+// user with the supplied UID, in the supplied flavour, and ctx bounds their
+// allocation. The event context identifies who the sweep is run by and goes
+// into the events; its timestamp is set by the sweeper. The input slice is
+// not modified.
+func (s *ExpirySweeper) SweepItems(
+	ctx context.Context,
+	items []Item,
+	uid string,
+	flavour Flavour,
+	evCtx Context,
+) ([]Item, []*Event, error) {
+	now := s.now()
+	evCtx.Timestamp = now
+	kept := []Item{}
+	events := []*Event{}
+	for _, it := range items {
+		if !s.shouldExpire(it.IsExpired(now), it.Visibility) {
+			kept = append(kept, it)
+			continue
+		}
+		if s.policy == ExpiryPolicyHide {
+			it.Visibility = VisibilityHide
+			err := restampItem(ctx, s.alloc, uid, flavour, &it)
+			if err != nil {
+				return nil, nil, err
+			}
+			kept = append(kept, it)
+		}
+		events = append(events, s.itemExpiredEvent(it, evCtx))
+	}
+	return kept, events, nil
+}
+
+// SweepNudges expires the nudges in a slice.
+//
+// The returned slice holds the nudges that remain: with the hide policy
+// expired nudges are hidden, with the delete policy they are dropped. If a
+// sequence number can't be allocated, only the error is returned.
+// The contexts and feed owner are used as they are by SweepItems. The input
+// slice is not modified.
+func (s *ExpirySweeper) SweepNudges(
+	ctx context.Context,
+	nudges []Nudge,
+	uid string,
+	flavour Flavour,
+	evCtx Context,
+) ([]Nudge, []*Event, error) {
+	now := s.now()
+	evCtx.Timestamp = now
+	kept := []Nudge{}
+	events := []*Event{}
+	for _, nu := range nudges {
+		if !s.shouldExpire(nu.IsExpired(now), nu.Visibility) {
+			kept = append(kept, nu)
+			continue
+		}
+		if s.policy == ExpiryPolicyHide {
+			nu.Visibility = VisibilityHide
+			err := restampNudge(ctx, s.alloc, uid, flavour, &nu)
+			if err != nil {
+				return nil, nil, err
+			}
+			kept = append(kept, nu)
+		}
+		events = append(events, s.nudgeExpiredEvent(nu, evCtx))
+	}
+	return kept, events, nil
+}
+
+// SweepFeed expires the items and nudges in a single user's feed in the
+// repository and returns an event for every element that was expired.
+//
+// Elements that were expired before an error occurred stay expired and their
+// events are returned along with the error.
+func (s *ExpirySweeper) SweepFeed(
+	ctx context.Context,
+	repo FeedRepository,
+	uid string,
+	flavour Flavour,
+) ([]*Event, error) {
+	now := s.now()
+	evCtx := Context{UserID: uid, Flavour: flavour, Timestamp: now}
+	events := []*Event{}
+
+	items, err := repo.ListItems(ctx, uid, flavour, s.candidateFilter())
+	if err != nil {
+		return events, fmt.Errorf("can't list items for %s: %w", uid, err)
+	}
+	for _, it := range items {
+		if !it.IsExpired(now) {
+			continue
+		}
+		switch s.policy {
+		case ExpiryPolicyHide:
+			it.Visibility = VisibilityHide
+			err = restampItem(ctx, s.alloc, uid, flavour, &it)
+			if err == nil {
+				err = repo.PutItem(ctx, uid, flavour, it)
+			}
+		case ExpiryPolicyDelete:
+			err = repo.DeleteItem(ctx, uid, flavour, it.ID)
+		}
+		if errors.Is(err, ErrElementNotFound) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return events, fmt.Errorf("can't expire item %s: %w", it.ID, err)
+		}
+		events = append(events, s.itemExpiredEvent(it, evCtx))
+	}
+
+	nudges, err := repo.ListNudges(ctx, uid, flavour, s.candidateFilter())
+	if err != nil {
+		return events, fmt.Errorf("can't list nudges for %s: %w", uid, err)
+	}
+	for _, nu := range nudges {
+		if !nu.IsExpired(now) {
+			continue
+		}
+		switch s.policy {
+		case ExpiryPolicyHide:
+			nu.Visibility = VisibilityHide
+			err = restampNudge(ctx, s.alloc, uid, flavour, &nu)
+			if err == nil {
+				err = repo.PutNudge(ctx, uid, flavour, nu)
+			}
+		case ExpiryPolicyDelete:
+			err = repo.DeleteNudge(ctx, uid, flavour, nu.ID)
+		}
+		if errors.Is(err, ErrElementNotFound) {
+			continue
+		}
+		if err != nil {
+			return events, fmt.Errorf("can't expire nudge %s: %w", nu.ID, err)
+		}
+		events = append(events, s.nudgeExpiredEvent(nu, evCtx))
+	}
+	return events, nil
+}
+
+// shouldExpire decides whether an element is acted on by the sweeper
+func (s *ExpirySweeper) shouldExpire(expired bool, visibility Visibility) bool {
+	if !expired {
+		return false
+	}
+	return s.policy != ExpiryPolicyHide || visibility != VisibilityHide
+}
+
+// candidateFilter returns the repository filter for elements that the
+// sweeper may need to act on
+func (s *ExpirySweeper) candidateFilter() *FeedFilter {
+	if s.policy == ExpiryPolicyHide {
+		return &FeedFilter{Visibility: []Visibility{VisibilityShow}}
+	}
+	return nil
+}
+
+func (s *ExpirySweeper) itemExpiredEvent(it Item, ctx Context) *Event {
+	return newEvent(EventNameItemExpired, ctx, map[string]interface{}{
+		"itemID":         it.ID,
+		"policy":         s.policy.String(),
+		"expiry":         it.Expiry.Format(time.RFC3339),
+		"sequenceNumber": it.SequenceNumber,
+	})
+}
+
+func (s *ExpirySweeper) nudgeExpiredEvent(nu Nudge, ctx Context) *Event {
+	return newEvent(EventNameNudgeExpired, ctx, map[string]interface{}{
+		"nudgeID":        nu.ID,
+		"policy":         s.policy.String(),
+		"expiry":         nu.Expiry.Format(time.RFC3339),
+		"sequenceNumber": nu.SequenceNumber,
+	})
+}
+
+// FeedRef identifies a single user's feed of a given flavour
+type FeedRef struct {
+	UID     string
+	Flavour Flavour
+}
+
+// FeedListerFunc returns the feeds that an ExpiryWorker should sweep
+type FeedListerFunc func(ctx context.Context) ([]FeedRef, error)
+
+// ExpiryEventHandlerFunc receives the events that an ExpiryWorker emits
+// e.g to publish them or to dispatch notifications
+type ExpiryEventHandlerFunc func(ctx context.Context, events []*Event)
+
+// ExpiryWorker periodically sweeps a set of feeds in a repository in the
+// background.
+//
+// Errors are logged and do not stop the worker; a failure to sweep one feed
+// does not prevent the other feeds from being swept.
+type ExpiryWorker struct {
+	sweeper  *ExpirySweeper
+	repo     FeedRepository
+	feeds    FeedListerFunc
+	interval time.Duration
+	onEvents ExpiryEventHandlerFunc
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewExpiryWorker initializes a worker that sweeps the listed feeds every
+// interval
+func NewExpiryWorker(
+	sweeper *ExpirySweeper,
+	repo FeedRepository,
+	feeds FeedListerFunc,
+	interval time.Duration,
+) (*ExpiryWorker, error) {
+	if sweeper == nil || repo == nil || feeds == nil {
+		return nil, fmt.Errorf("a sweeper, repository and feed lister are required")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("the sweep interval must be positive, got %s", interval)
+	}
+	return &ExpiryWorker{
+		sweeper:  sweeper,
+		repo:     repo,
+		feeds:    feeds,
+		interval: interval,
+	}, nil
+}
+
+// SetEventHandler sets the function that receives the events of each sweep.
+// A running worker keeps the handler that it was started with until it is
+// stopped.
+func (w *ExpiryWorker) SetEventHandler(fn ExpiryEventHandlerFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onEvents = fn
+}
+
+// Sweep sweeps every listed feed once and returns the events that were
+// emitted. The event handler is called for the feeds that had expired
+// elements.
+func (w *ExpiryWorker) Sweep(ctx context.Context) ([]*Event, error) {
+	w.mu.Lock()
+	onEvents := w.onEvents
+	w.mu.Unlock()
+	return w.sweep(ctx, onEvents)
+}
+
+// sweep is Sweep with the event handler to use
+func (w *ExpiryWorker) sweep(ctx context.Context, onEvents ExpiryEventHandlerFunc) ([]*Event, error) {
+	refs, err := w.feeds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't list feeds to sweep: %w", err)
+	}
+	events := []*Event{}
+	var sweepErr error
+	for _, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return events, err
+		}
+		feedEvents, err := w.sweeper.SweepFeed(ctx, w.repo, ref.UID, ref.Flavour)
+		if err != nil && sweepErr == nil {
+			sweepErr = err
+		}
+		if len(feedEvents) > 0 {
+			events = append(events, feedEvents...)
+			if onEvents != nil {
+				onEvents(ctx, feedEvents)
+			}
+		}
+	}
+	return events, sweepErr
+}
+
+// Start sweeps once and then every interval in a background goroutine, until
+// Stop is called
+func (w *ExpiryWorker) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done != nil {
+		return fmt.Errorf("the expiry worker is already running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.run(ctx, w.onEvents, w.stop, w.done)
+	return nil
+}
+
+// Stop stops the worker gracefully, waiting for an in-flight sweep to finish.
+// If the supplied context is done first, the in-flight sweep is cancelled and
+// the context's error is returned. Stopping a worker that is not running is a
+// no-op.
+func (w *ExpiryWorker) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done == nil {
+		return nil
+	}
+	stop, done, cancel := w.stop, w.done, w.cancel
+	w.stop, w.done, w.cancel = nil, nil, nil
+	defer cancel()
+
+	close(stop)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// run is the worker's loop. Sweeps use ctx, which is only cancelled when a
+// graceful stop takes too long. The event handler is passed in because Stop
+// holds the worker's lock until the loop ends.
+func (w *ExpiryWorker) run(
+	ctx context.Context,
+	onEvents ExpiryEventHandlerFunc,
+	stop <-chan struct{},
+	done chan<- struct{},
+) {
+	defer close(done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.sweep(ctx, onEvents); err != nil && ctx.Err() == nil {
+			log.Printf("expiry sweep failed: %s", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package feedlib_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryPolicy_IsValid(t *testing.T) {
+	for _, p := range feedlib.AllExpiryPolicy {
+		assert.True(t, p.IsValid(), "%s should be valid", p)
+	}
+	assert.False(t, feedlib.ExpiryPolicy("bogus").IsValid())
+}
+
+func TestExpiryPolicy_UnmarshalGQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       interface{}
+		wantErr bool
+	}{
+		{
+			name:    "valid policy",
+			v:       "DELETE",
+			wantErr: false,
+		},
+		{
+			name:    "invalid policy",
+			v:       "bogus",
+			wantErr: true,
+		},
+		{
+			name:    "not a string",
+			v:       1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := feedlib.ExpiryPolicy("")
+			if err := p.UnmarshalGQL(tt.v); (err != nil) != tt.wantErr {
+				t.Errorf("ExpiryPolicy.UnmarshalGQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpiryPolicy_MarshalGQL(t *testing.T) {
+	w := &bytes.Buffer{}
+	feedlib.ExpiryPolicyHide.MarshalGQL(w)
+	assert.Equal(t, strconv.Quote("HIDE"), w.String())
+}
+
+func TestNewExpirySweeper(t *testing.T) {
+	_, err := feedlib.NewExpirySweeper(feedlib.ExpiryPolicy("bogus"), nil, nil)
+	assert.NotNil(t, err)
+
+	s, err := feedlib.NewExpirySweeper(feedlib.ExpiryPolicyHide, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, feedlib.ExpiryPolicyHide, s.Policy())
+}
+
+func TestIsExpired(t *testing.T) {
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+
+	it := feedtest.Item("item-1", 1)
+	it.Expiry = now
+	assert.True(t, it.IsExpired(now), "an item expires at its expiry")
+	assert.False(t, it.IsExpired(now.Add(-time.Second)))
+	it.Expiry = time.Time{}
+	assert.False(t, it.IsExpired(now), "an item without an expiry never expires")
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.Expiry = now.Add(-time.Minute)
+	assert.True(t, nu.IsExpired(now))
+	nu.Expiry = time.Time{}
+	assert.False(t, nu.IsExpired(now))
+}
+
+func getExpiryTestItems(now time.Time) []feedlib.Item {
+	expired := feedtest.Item("expired", 1)
+	expired.Expiry = now.Add(-time.Hour)
+	alreadyHidden := feedtest.Item("already-hidden", 2)
+	alreadyHidden.Expiry = now.Add(-time.Hour)
+	alreadyHidden.Visibility = feedlib.VisibilityHide
+	current := feedtest.Item("current", 3)
+	current.Expiry = now.Add(time.Hour)
+	return []feedlib.Item{expired, alreadyHidden, current}
+}
+
+func TestExpirySweeper_SweepItems(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	t.Run("hide", func(t *testing.T) {
+		alloc := feedlib.NewMemorySequenceAllocator()
+		assert.Nil(t, alloc.Observe(ctx, "user-1", feedlib.FlavourConsumer, 3))
+		s, err := feedlib.NewExpirySweeper(feedlib.ExpiryPolicyHide, alloc, clock)
+		assert.Nil(t, err)
+
+		items := getExpiryTestItems(now)
+		kept, events, err := s.SweepItems(ctx, items, "user-1", feedlib.FlavourConsumer, getTestContext())
+		assert.Nil(t, err)
+		assert.Len(t, kept, 3)
+		assert.Equal(t, feedlib.VisibilityHide, kept[0].Visibility)
+		assert.Equal(t, 4, kept[0].SequenceNumber, "the next number in the feed should be used")
+		assert.Equal(t, 2, kept[1].SequenceNumber, "hidden items are left alone")
+		assert.Equal(t, feedlib.VisibilityShow, kept[2].Visibility)
+		assert.Equal(t, feedlib.VisibilityShow, items[0].Visibility, "the input is not modified")
+
+		assert.Len(t, events, 1)
+		assert.Equal(t, feedlib.EventNameItemExpired, events[0].Name)
+		assert.Equal(t, "expired", events[0].Payload.Data["itemID"])
+		assert.Equal(t, now, events[0].Context.Timestamp)
+		_, err = events[0].ValidateAndMarshal()
+		assert.Nil(t, err)
+
+		_, again, err := s.SweepItems(ctx, kept, "user-1", feedlib.FlavourConsumer, getTestContext())
+		assert.Nil(t, err)
+		assert.Empty(t, again, "sweeping is idempotent")
+
+		_, _, err = s.SweepItems(ctx, items, "", "", getTestContext())
+		assert.NotNil(t, err, "a sequence number can't be allocated without a feed")
+
+		// the actor that runs the sweep does not own the feed
+		system := getTestContext()
+		system.UserID = "system"
+		hidden, _, err := s.SweepItems(ctx, items, "user-1", feedlib.FlavourConsumer, system)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, hidden[0].SequenceNumber, "the number should come from the owner's feed")
+
+		// a sweep stops when its caller gives up on it
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, _, err = s.SweepItems(cancelled, items, "user-1", feedlib.FlavourConsumer, getTestContext())
+		assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+		nudge := feedtest.Nudge("expired", 1)
+		nudge.Expiry = now
+		_, _, err = s.SweepNudges(cancelled, []feedlib.Nudge{nudge}, "user-1", feedlib.FlavourConsumer, getTestContext())
+		assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	})
+
+	t.Run("hide a batch", func(t *testing.T) {
+		alloc := feedlib.NewMemorySequenceAllocator()
+		s, err := feedlib.NewExpirySweeper(feedlib.ExpiryPolicyHide, alloc, clock)
+		assert.Nil(t, err)
+
+		items := []feedlib.Item{}
+		for i, id := range []string{"item-1", "item-2", "item-3"} {
+			it := feedtest.Item(id, i+1)
+			it.Conversations = nil
+			it.Expiry = now.Add(-time.Hour)
+			items = append(items, it)
+		}
+		fe := feedlib.Feed{UID: "user-1", Flavour: feedlib.FlavourConsumer, Items: items}
+		assert.Nil(t, feedlib.ObserveFeed(ctx, alloc, &fe))
+
+		kept, events, err := s.SweepItems(ctx, items, "user-1", feedlib.FlavourConsumer, getTestContext())
+		assert.Nil(t, err)
+		assert.Len(t, events, 3)
+		fe.Items = kept
+		assert.Nil(t, fe.VerifySequence(), "swept items should not share sequence numbers")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		alloc := feedlib.NewMemorySequenceAllocator()
+		s, err := feedlib.NewExpirySweeper(feedlib.ExpiryPolicyDelete, alloc, clock)
+		assert.Nil(t, err)
+
+		kept, events, err := s.SweepItems(ctx, getExpiryTestItems(now), "user-1", feedlib.FlavourConsumer, getTestContext())
+		assert.Nil(t, err)
+		assert.Len(t, kept, 1)
+		assert.Equal(t, "current", kept[0].ID)
+		assert.Len(t, events, 2)
+	})
+}
+
+func TestExpirySweeper_SweepNudges(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+	s, err := feedlib.NewExpirySweeper(
+		feedlib.ExpiryPolicyDelete, nil, func() time.Time { return now })
+	assert.Nil(t, err)
+
+	expired := feedtest.Nudge("expired", 1)
+	expired.Expiry = now
+	current := feedtest.Nudge("current", 2)
+	current.Expiry = now.Add(time.Hour)
+
+	kept, events, err := s.SweepNudges(ctx, []feedlib.Nudge{expired, current}, "user-1", feedlib.FlavourConsumer, getTestContext())
+	assert.Nil(t, err)
+	assert.Len(t, kept, 1)
+	assert.Equal(t, "current", kept[0].ID)
+	assert.Len(t, events, 1)
+	assert.Equal(t, feedlib.EventNameNudgeExpired, events[0].Name)
+	assert.Equal(t, "expired", events[0].Payload.Data["nudgeID"])
+}
+
+func TestExpirySweeper_SweepFeed(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	uid, flavour := "user-1", feedlib.FlavourConsumer
+
+	setup := func(t *testing.T) *feedlib.MemoryFeedRepository {
+		repo := feedlib.NewMemoryFeedRepository()
+		for _, it := range getExpiryTestItems(now) {
+			assert.Nil(t, repo.PutItem(ctx, uid, flavour, it))
+		}
+		nu := feedtest.Nudge("expired", 4)
+		nu.Expiry = now.Add(-time.Minute)
+		assert.Nil(t, repo.PutNudge(ctx, uid, flavour, nu))
+		return repo
+	}
+
+	t.Run("hide", func(t *testing.T) {
+		repo := setup(t)
+		alloc := feedlib.NewMemorySequenceAllocator()
+		assert.Nil(t, alloc.Observe(ctx, uid, flavour, 4))
+		s, err := feedlib.NewExpirySweeper(feedlib.ExpiryPolicyHide, alloc, clock)
+		assert.Nil(t, err)
+
+		events, err := s.SweepFeed(ctx, repo, uid, flavour)
+		assert.Nil(t, err)
+		assert.Len(t, events, 2)
+		for _, ev := range events {
+			assert.Equal(t, uid, ev.Context.UserID)
+			assert.Equal(t, flavour, ev.Context.Flavour)
+		}
+
+		it, err := repo.GetItem(ctx, uid, flavour, "expired")
+		assert.Nil(t, err)
+		assert.Equal(t, feedlib.VisibilityHide, it.Visibility)
+		assert.Equal(t, 5, it.SequenceNumber)
+		nu, err := repo.GetNudge(ctx, uid, flavour, "expired")
+		assert.Nil(t, err)
+		assert.Equal(t, feedlib.VisibilityHide, nu.Visibility)
+		assert.Equal(t, 6, nu.SequenceNumber)
+
+		events, err = s.SweepFeed(ctx, repo, uid, flavour)
+		assert.Nil(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := setup(t)
+		alloc := feedlib.NewMemorySequenceAllocator()
+		s, err := feedlib.NewExpirySweeper(feedlib.ExpiryPolicyDelete, alloc, clock)
+		assert.Nil(t, err)
+
+		events, err := s.SweepFeed(ctx, repo, uid, flavour)
+		assert.Nil(t, err)
+		assert.Len(t, events, 3)
+
+		items, err := repo.ListItems(ctx, uid, flavour, nil)
+		assert.Nil(t, err)
+		assert.Len(t, items, 1)
+		nudges, err := repo.ListNudges(ctx, uid, flavour, nil)
+		assert.Nil(t, err)
+		assert.Empty(t, nudges)
+	})
+
+	t.Run("invalid feed", func(t *testing.T) {
+		alloc := feedlib.NewMemorySequenceAllocator()
+		s, err := feedlib.NewExpirySweeper(feedlib.ExpiryPolicyDelete, alloc, clock)
+		assert.Nil(t, err)
+
+		_, err = s.SweepFeed(ctx, setup(t), "", flavour)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestExpiryWorker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+	s, err := feedlib.NewExpirySweeper(
+		feedlib.ExpiryPolicyDelete, nil, func() time.Time { return now })
+	assert.Nil(t, err)
+
+	repo := feedlib.NewMemoryFeedRepository()
+	for _, uid := range []string{"user-1", "user-2"} {
+		it := feedtest.Item("expired", 1)
+		it.Expiry = now.Add(-time.Hour)
+		assert.Nil(t, repo.PutItem(ctx, uid, feedlib.FlavourPro, it))
+	}
+
+	_, err = feedlib.NewExpiryWorker(s, repo, repo.Feeds, 0)
+	assert.NotNil(t, err)
+	_, err = feedlib.NewExpiryWorker(s, nil, repo.Feeds, time.Second)
+	assert.NotNil(t, err)
+
+	w, err := feedlib.NewExpiryWorker(s, repo, repo.Feeds, 10*time.Millisecond)
+	assert.Nil(t, err)
+
+	var mu sync.Mutex
+	handled := []*feedlib.Event{}
+	swept := make(chan struct{}, 10)
+	w.SetEventHandler(func(ctx context.Context, events []*feedlib.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, events...)
+		swept <- struct{}{}
+	})
+
+	assert.Nil(t, w.Start())
+	assert.NotNil(t, w.Start(), "a running worker can't be started again")
+	<-swept
+	<-swept
+	assert.Nil(t, w.Stop(ctx))
+	assert.Nil(t, w.Stop(ctx), "stopping a stopped worker is a no-op")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, handled, 2)
+	for _, uid := range []string{"user-1", "user-2"} {
+		items, err := repo.ListItems(ctx, uid, feedlib.FlavourPro, nil)
+		assert.Nil(t, err)
+		assert.Empty(t, items)
+	}
+}
+
+func TestExpiryWorker_SetEventHandlerWhileRunning(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+	s, err := feedlib.NewExpirySweeper(
+		feedlib.ExpiryPolicyDelete, nil, func() time.Time { return now })
+	assert.Nil(t, err)
+	repo := feedlib.NewMemoryFeedRepository()
+	w, err := feedlib.NewExpiryWorker(s, repo, repo.Feeds, time.Millisecond)
+	assert.Nil(t, err)
+
+	started := make(chan struct{}, 100)
+	w.SetEventHandler(func(ctx context.Context, events []*feedlib.Event) {
+		started <- struct{}{}
+	})
+	assert.Nil(t, w.Start())
+	for i := 0; i < 3; i++ {
+		it := feedtest.Item(fmt.Sprintf("expired-%d", i), 1)
+		it.Expiry = now.Add(-time.Hour)
+		assert.Nil(t, repo.PutItem(ctx, "user-1", feedlib.FlavourPro, it))
+		<-started
+		// the running worker keeps the handler that it was started with
+		w.SetEventHandler(func(ctx context.Context, events []*feedlib.Event) {
+			t.Error("the running worker should not use a new handler")
+		})
+	}
+	assert.Nil(t, w.Stop(ctx))
+
+	later := make(chan int, 1)
+	w.SetEventHandler(func(ctx context.Context, events []*feedlib.Event) {
+		later <- len(events)
+	})
+	it := feedtest.Item("expired", 1)
+	it.Expiry = now.Add(-time.Hour)
+	assert.Nil(t, repo.PutItem(ctx, "user-1", feedlib.FlavourPro, it))
+	_, err = w.Sweep(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, <-later)
+}
+
+func TestExpiryWorker_Sweep(t *testing.T) {
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+	s, err := feedlib.NewExpirySweeper(
+		feedlib.ExpiryPolicyHide, nil, func() time.Time { return now })
+	assert.Nil(t, err)
+
+	repo := feedlib.NewMemoryFeedRepository()
+	feeds := func(ctx context.Context) ([]feedlib.FeedRef, error) {
+		return []feedlib.FeedRef{
+			{UID: "", Flavour: feedlib.FlavourPro},
+			{UID: "user-1", Flavour: feedlib.FlavourPro},
+		}, nil
+	}
+	it := feedtest.Item("expired", 1)
+	it.Expiry = now.Add(-time.Hour)
+	assert.Nil(t, repo.PutItem(context.Background(), "user-1", feedlib.FlavourPro, it))
+
+	w, err := feedlib.NewExpiryWorker(s, repo, feeds, time.Minute)
+	assert.Nil(t, err)
+
+	events, err := w.Sweep(context.Background())
+	assert.NotNil(t, err, "the invalid feed should fail")
+	assert.Len(t, events, 1, "the other feeds should still be swept")
+}
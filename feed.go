@@ -0,0 +1,224 @@
+package feedlib
+
+import (
+	"fmt"
+)
+
+// Feed is the aggregate of the global actions, nudges and items that a
+// user sees
+type Feed struct {
+	// A unique identifier for the feed
+	ID string `json:"id" firestore:"id"`
+
+	// A higher sequence number means that it came later
+	SequenceNumber int `json:"sequenceNumber" firestore:"sequenceNumber"`
+
+	// The UID of the user that owns this feed
+	UID string `json:"uid" firestore:"uid"`
+
+	// Whether this is a consumer or pro feed
+	Flavour Flavour `json:"flavour" firestore:"flavour"`
+
+	// Whether the feed belongs to an anonymous user
+	IsAnonymous bool `json:"isAnonymous" firestore:"isAnonymous"`
+
+	// Global actions e.g the feed's floating action button
+	Actions []Action `json:"actions" firestore:"actions"`
+
+	// Prompts for the user e.g to set a PIN
+	Nudges []Nudge `json:"nudges" firestore:"nudges"`
+
+	// The feed items
+	Items []Item `json:"items" firestore:"items"`
+
+	// Free-form metadata that applies to the whole feed e.g the version of
+	// the client that it was assembled for
+	Metadata map[string]interface{} `json:"metadata,omitempty" firestore:"metadata,omitempty"`
+}
+
+// ValidateAndUnmarshal checks that the input data is valid as per the
+// relevant JSON schema and unmarshals it if it is
+func (fe *Feed) ValidateAndUnmarshal(b []byte, opts ...UnmarshalOption) error {
+	err := ValidateAndUnmarshal(FeedSchemaFile, b, fe, opts...)
+	if err != nil {
+		return fmt.Errorf("invalid feed JSON: %w", err)
+	}
+
+	return fe.validateElements()
+}
+
+// ValidateAndMarshal validates against JSON schema then marshals to JSON
+func (fe *Feed) ValidateAndMarshal() ([]byte, error) {
+	if err := fe.validateElements(); err != nil {
+		return nil, err
+	}
+
+	return ValidateAndMarshal(FeedSchemaFile, fe)
+}
+
+// IsEntity marks this as an Apollo federation GraphQL entity
+func (fe Feed) IsEntity() {}
+
+// validateElements runs the checks that the feed's nudges and items get when
+// they are validated on their own, but that the feed schema can't express
+func (fe *Feed) validateElements() error {
+	for _, nu := range fe.Nudges {
+		if err := nu.validate(); err != nil {
+			return fmt.Errorf("invalid feed nudge %s: %w", nu.ID, err)
+		}
+	}
+	for _, it := range fe.Items {
+		if err := it.validate(); err != nil {
+			return fmt.Errorf("invalid feed item %s: %w", it.ID, err)
+		}
+	}
+	return nil
+}
+
+// GetAction returns the global action with the supplied ID
+func (fe *Feed) GetAction(id string) (*Action, error) {
+	i := fe.actionIndex(id)
+	if i == -1 {
+		return nil, fmt.Errorf("action %s: %w", id, ErrElementNotFound)
+	}
+	ac := fe.Actions[i]
+	return &ac, nil
+}
+
+// AddAction adds a global action to the feed. The action's ID must not be
+// in use by another action.
+func (fe *Feed) AddAction(ac Action) error {
+	if fe.actionIndex(ac.ID) != -1 {
+		return fmt.Errorf("action %s: %w", ac.ID, ErrDuplicateElement)
+	}
+	fe.Actions = append(fe.Actions, ac)
+	return nil
+}
+
+// ReplaceAction replaces the global action that has the same ID as the
+// supplied action
+func (fe *Feed) ReplaceAction(ac Action) error {
+	i := fe.actionIndex(ac.ID)
+	if i == -1 {
+		return fmt.Errorf("action %s: %w", ac.ID, ErrElementNotFound)
+	}
+	fe.Actions[i] = ac
+	return nil
+}
+
+// RemoveAction removes the global action with the supplied ID
+func (fe *Feed) RemoveAction(id string) error {
+	i := fe.actionIndex(id)
+	if i == -1 {
+		return fmt.Errorf("action %s: %w", id, ErrElementNotFound)
+	}
+	fe.Actions = append(fe.Actions[:i], fe.Actions[i+1:]...)
+	return nil
+}
+
+func (fe *Feed) actionIndex(id string) int {
+	for i, ac := range fe.Actions {
+		if ac.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetNudge returns the nudge with the supplied ID
+func (fe *Feed) GetNudge(id string) (*Nudge, error) {
+	i := fe.nudgeIndex(id)
+	if i == -1 {
+		return nil, fmt.Errorf("nudge %s: %w", id, ErrElementNotFound)
+	}
+	nu := fe.Nudges[i]
+	return &nu, nil
+}
+
+// AddNudge adds a nudge to the feed. The nudge's ID must not be in use by
+// another nudge.
+func (fe *Feed) AddNudge(nu Nudge) error {
+	if fe.nudgeIndex(nu.ID) != -1 {
+		return fmt.Errorf("nudge %s: %w", nu.ID, ErrDuplicateElement)
+	}
+	fe.Nudges = append(fe.Nudges, nu)
+	return nil
+}
+
+// ReplaceNudge replaces the nudge that has the same ID as the supplied nudge
+func (fe *Feed) ReplaceNudge(nu Nudge) error {
+	i := fe.nudgeIndex(nu.ID)
+	if i == -1 {
+		return fmt.Errorf("nudge %s: %w", nu.ID, ErrElementNotFound)
+	}
+	fe.Nudges[i] = nu
+	return nil
+}
+
+// RemoveNudge removes the nudge with the supplied ID
+func (fe *Feed) RemoveNudge(id string) error {
+	i := fe.nudgeIndex(id)
+	if i == -1 {
+		return fmt.Errorf("nudge %s: %w", id, ErrElementNotFound)
+	}
+	fe.Nudges = append(fe.Nudges[:i], fe.Nudges[i+1:]...)
+	return nil
+}
+
+func (fe *Feed) nudgeIndex(id string) int {
+	for i, nu := range fe.Nudges {
+		if nu.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetItem returns the feed item with the supplied ID
+func (fe *Feed) GetItem(id string) (*Item, error) {
+	i := fe.itemIndex(id)
+	if i == -1 {
+		return nil, fmt.Errorf("item %s: %w", id, ErrElementNotFound)
+	}
+	it := fe.Items[i]
+	return &it, nil
+}
+
+// AddItem adds an item to the feed. The item's ID must not be in use by
+// another item.
+func (fe *Feed) AddItem(it Item) error {
+	if fe.itemIndex(it.ID) != -1 {
+		return fmt.Errorf("item %s: %w", it.ID, ErrDuplicateElement)
+	}
+	fe.Items = append(fe.Items, it)
+	return nil
+}
+
+// ReplaceItem replaces the feed item that has the same ID as the supplied item
+func (fe *Feed) ReplaceItem(it Item) error {
+	i := fe.itemIndex(it.ID)
+	if i == -1 {
+		return fmt.Errorf("item %s: %w", it.ID, ErrElementNotFound)
+	}
+	fe.Items[i] = it
+	return nil
+}
+
+// RemoveItem removes the feed item with the supplied ID
+func (fe *Feed) RemoveItem(id string) error {
+	i := fe.itemIndex(id)
+	if i == -1 {
+		return fmt.Errorf("item %s: %w", id, ErrElementNotFound)
+	}
+	fe.Items = append(fe.Items[:i], fe.Items[i+1:]...)
+	return nil
+}
+
+func (fe *Feed) itemIndex(id string) int {
+	for i, it := range fe.Items {
+		if it.ID == id {
+			return i
+		}
+	}
+	return -1
+}
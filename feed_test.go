@@ -0,0 +1,264 @@
+package feedlib_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestFeed() *feedlib.Feed {
+	return &feedlib.Feed{
+		ID:             "feed-1",
+		SequenceNumber: 1,
+		UID:            "user-1",
+		Flavour:        feedlib.FlavourConsumer,
+		Actions:        []feedlib.Action{feedtest.Action("action-1", 1)},
+		Nudges:         []feedlib.Nudge{feedtest.Nudge("nudge-1", 1)},
+		Items:          []feedlib.Item{feedtest.Item("item-1", 1)},
+	}
+}
+
+func TestFeed_ValidateAndMarshal(t *testing.T) {
+	itemWithSVGIcon := feedtest.Item("item-2", 1)
+	itemWithSVGIcon.Icon = feedlib.GetSVGImageLink(
+		"https://example.com/a.svg", "title", "description", feedlib.BlankImageURL)
+
+	lateNudge := feedtest.Nudge("nudge-2", 1)
+	publishAt := lateNudge.Expiry.Add(time.Hour)
+	lateNudge.PublishAt = &publishAt
+
+	withMetadata := getTestFeed()
+	withMetadata.Metadata = map[string]interface{}{"clientVersion": "1.2.0"}
+
+	tests := []struct {
+		name    string
+		fe      *feedlib.Feed
+		wantErr bool
+	}{
+		{
+			name:    "valid feed",
+			fe:      getTestFeed(),
+			wantErr: false,
+		},
+		{
+			name: "valid empty feed",
+			fe: &feedlib.Feed{
+				UID:     "user-1",
+				Flavour: feedlib.FlavourPro,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid item icon",
+			fe: &feedlib.Feed{
+				UID:     "user-1",
+				Flavour: feedlib.FlavourPro,
+				Items:   []feedlib.Item{itemWithSVGIcon},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "valid feed with metadata",
+			fe:      withMetadata,
+			wantErr: false,
+		},
+		{
+			name: "nudge published after it expires",
+			fe: &feedlib.Feed{
+				UID:     "user-1",
+				Flavour: feedlib.FlavourPro,
+				Nudges:  []feedlib.Nudge{lateNudge},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "invalid case - empty",
+			fe:      &feedlib.Feed{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fe.ValidateAndMarshal()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Feed.ValidateAndMarshal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.NotZero(t, got)
+			}
+		})
+	}
+}
+
+func TestFeed_ValidateAndUnmarshal(t *testing.T) {
+	emptyJSONBytes := getEmptyJson(t)
+
+	validBytes, err := json.Marshal(getTestFeed())
+	assert.Nil(t, err)
+
+	invalidNudge := feedtest.Nudge("nudge-2", 1)
+	invalidNudge.Status = feedlib.Status("bogus")
+	invalidFeed := getTestFeed()
+	invalidFeed.Nudges = append(invalidFeed.Nudges, invalidNudge)
+	invalidBytes, err := json.Marshal(invalidFeed)
+	assert.Nil(t, err)
+
+	lateItem := feedtest.Item("item-2", 1)
+	publishAt := lateItem.Expiry.Add(time.Hour)
+	lateItem.PublishAt = &publishAt
+	lateFeed := getTestFeed()
+	lateFeed.Items = append(lateFeed.Items, lateItem)
+	lateBytes, err := json.Marshal(lateFeed)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		b       []byte
+		wantErr bool
+	}{
+		{
+			name:    "valid JSON",
+			b:       validBytes,
+			wantErr: false,
+		},
+		{
+			name:    "invalid nested nudge",
+			b:       invalidBytes,
+			wantErr: true,
+		},
+		{
+			name:    "item published after it expires",
+			b:       lateBytes,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			b:       emptyJSONBytes,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fe := &feedlib.Feed{}
+			if err := fe.ValidateAndUnmarshal(tt.b); (err != nil) != tt.wantErr {
+				t.Errorf("Feed.ValidateAndUnmarshal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, "user-1", fe.UID)
+				assert.Len(t, fe.Items, 1)
+			}
+		})
+	}
+}
+
+func TestFeed_Actions(t *testing.T) {
+	fe := getTestFeed()
+
+	ac, err := fe.GetAction("action-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "action-1", ac.ID)
+
+	_, err = fe.GetAction("bogus")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	err = fe.AddAction(feedtest.Action("action-1", 1))
+	assert.True(t, errors.Is(err, feedlib.ErrDuplicateElement))
+
+	assert.Nil(t, fe.AddAction(feedtest.Action("action-2", 1)))
+	assert.Len(t, fe.Actions, 2)
+
+	replacement := feedtest.Action("action-2", 1)
+	replacement.Name = "Replaced"
+	assert.Nil(t, fe.ReplaceAction(replacement))
+	ac, err = fe.GetAction("action-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Replaced", ac.Name)
+
+	err = fe.ReplaceAction(feedtest.Action("bogus", 1))
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	assert.Nil(t, fe.RemoveAction("action-1"))
+	assert.Len(t, fe.Actions, 1)
+	assert.Equal(t, "action-2", fe.Actions[0].ID)
+
+	err = fe.RemoveAction("action-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+}
+
+func TestFeed_Nudges(t *testing.T) {
+	fe := getTestFeed()
+
+	nu, err := fe.GetNudge("nudge-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "nudge-1", nu.ID)
+
+	nu.Title = "changing a copy does not change the feed"
+	original, err := fe.GetNudge("nudge-1")
+	assert.Nil(t, err)
+	assert.NotEqual(t, nu.Title, original.Title)
+
+	_, err = fe.GetNudge("bogus")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	err = fe.AddNudge(feedtest.Nudge("nudge-1", 1))
+	assert.True(t, errors.Is(err, feedlib.ErrDuplicateElement))
+
+	assert.Nil(t, fe.AddNudge(feedtest.Nudge("nudge-2", 1)))
+	assert.Len(t, fe.Nudges, 2)
+
+	replacement := feedtest.Nudge("nudge-2", 1)
+	replacement.Title = "Replaced"
+	assert.Nil(t, fe.ReplaceNudge(replacement))
+	nu, err = fe.GetNudge("nudge-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Replaced", nu.Title)
+
+	err = fe.ReplaceNudge(feedtest.Nudge("bogus", 1))
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	assert.Nil(t, fe.RemoveNudge("nudge-1"))
+	assert.Len(t, fe.Nudges, 1)
+
+	err = fe.RemoveNudge("nudge-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+}
+
+func TestFeed_Items(t *testing.T) {
+	fe := getTestFeed()
+
+	it, err := fe.GetItem("item-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "item-1", it.ID)
+
+	_, err = fe.GetItem("bogus")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	err = fe.AddItem(feedtest.Item("item-1", 1))
+	assert.True(t, errors.Is(err, feedlib.ErrDuplicateElement))
+
+	assert.Nil(t, fe.AddItem(feedtest.Item("item-2", 1)))
+	assert.Len(t, fe.Items, 2)
+
+	replacement := feedtest.Item("item-2", 1)
+	replacement.Text = "Replaced"
+	assert.Nil(t, fe.ReplaceItem(replacement))
+	it, err = fe.GetItem("item-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Replaced", it.Text)
+
+	err = fe.ReplaceItem(feedtest.Item("bogus", 1))
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	assert.Nil(t, fe.RemoveItem("item-1"))
+	assert.Len(t, fe.Items, 1)
+
+	err = fe.RemoveItem("item-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+}
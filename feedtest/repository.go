@@ -0,0 +1,553 @@
+// Package feedtest holds helpers for testing code that builds on feedlib.
+package feedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// RunFeedRepositoryTests runs the conformance tests that every
+// feedlib.FeedRepository implementation should pass.
+//
+// newRepository is called once per test and should return an empty
+// repository, so that tests do not see each other's data.
+func RunFeedRepositoryTests(t *testing.T, newRepository func() feedlib.FeedRepository) {
+	t.Run("actions", func(t *testing.T) {
+		testActions(t, newRepository())
+	})
+	t.Run("nudges", func(t *testing.T) {
+		testNudges(t, newRepository())
+	})
+	t.Run("items", func(t *testing.T) {
+		testItems(t, newRepository())
+	})
+	t.Run("feeds are scoped per user and flavour", func(t *testing.T) {
+		testScoping(t, newRepository())
+	})
+	t.Run("stored elements can't be mutated by callers", func(t *testing.T) {
+		testIsolation(t, newRepository())
+	})
+	t.Run("invalid input is rejected", func(t *testing.T) {
+		testInvalidInput(t, newRepository())
+	})
+	t.Run("concurrent use", func(t *testing.T) {
+		testConcurrency(t, newRepository())
+	})
+}
+
+const (
+	testUID     = "user-1"
+	testFlavour = feedlib.FlavourConsumer
+)
+
+// Action returns a valid action, for use in tests
+func Action(id string, sequenceNumber int) feedlib.Action {
+	return feedlib.Action{
+		ID:             id,
+		SequenceNumber: sequenceNumber,
+		Name:           "First action",
+		Icon: feedlib.GetPNGImageLink(
+			feedlib.LogoURL, "title", "description", feedlib.BlankImageURL),
+		ActionType: feedlib.ActionTypePrimary,
+		Handling:   feedlib.HandlingInline,
+	}
+}
+
+// Nudge returns a valid, pending and visible nudge, for use in tests
+func Nudge(id string, sequenceNumber int) feedlib.Nudge {
+	return feedlib.Nudge{
+		ID:             id,
+		SequenceNumber: sequenceNumber,
+		Visibility:     feedlib.VisibilityShow,
+		Status:         feedlib.StatusPending,
+		Expiry:         time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+		Title:          "Update your profile!",
+		Text:           "An up to date profile will help us serve you better!",
+		Links: []feedlib.Link{
+			feedlib.GetPNGImageLink(
+				feedlib.LogoURL, "title", "description", feedlib.BlankImageURL),
+		},
+		Actions: []feedlib.Action{Action("action-1", 1)},
+		Users:   []string{testUID},
+		Groups:  []string{"group-1"},
+		NotificationChannels: []feedlib.Channel{
+			feedlib.ChannelFcm,
+		},
+	}
+}
+
+// Item returns a valid, pending, visible and persistent item, for use in tests
+func Item(id string, sequenceNumber int) feedlib.Item {
+	now := time.Now().UTC().Truncate(time.Second)
+	return feedlib.Item{
+		ID:             id,
+		SequenceNumber: sequenceNumber,
+		Expiry:         now.Add(time.Hour),
+		Persistent:     true,
+		Status:         feedlib.StatusPending,
+		Visibility:     feedlib.VisibilityShow,
+		Icon: feedlib.GetPNGImageLink(
+			feedlib.LogoURL, "title", "description", feedlib.BlankImageURL),
+		Author:    "Bot 1",
+		Tagline:   "Bot speaks...",
+		Label:     "DRUGS",
+		Timestamp: now,
+		Summary:   "I am a bot...",
+		Text:      "This bot can speak",
+		TextType:  feedlib.TextTypePlain,
+		Links: []feedlib.Link{
+			feedlib.GetPNGImageLink(
+				feedlib.LogoURL, "title", "description", feedlib.BlankImageURL),
+		},
+		Actions: []feedlib.Action{Action("action-1", 1)},
+		Conversations: []feedlib.Message{
+			{
+				ID:             "msg-1",
+				SequenceNumber: 1,
+				Text:           "hii ni message",
+				PostedByUID:    testUID,
+				PostedByName:   "User 1",
+				Timestamp:      now,
+			},
+		},
+		Users:  []string{testUID},
+		Groups: []string{"group-1"},
+		NotificationChannels: []feedlib.Channel{
+			feedlib.ChannelFcm,
+		},
+	}
+}
+
+func testActions(t *testing.T, repo feedlib.FeedRepository) {
+	ctx := context.Background()
+
+	_, err := repo.GetAction(ctx, testUID, testFlavour, "action-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	actions, err := repo.ListActions(ctx, testUID, testFlavour)
+	assert.Nil(t, err)
+	assert.Empty(t, actions)
+
+	assert.Nil(t, repo.PutAction(ctx, testUID, testFlavour, Action("action-2", 2)))
+	assert.Nil(t, repo.PutAction(ctx, testUID, testFlavour, Action("action-1", 1)))
+
+	got, err := repo.GetAction(ctx, testUID, testFlavour, "action-1")
+	assert.Nil(t, err)
+	assert.Equal(t, Action("action-1", 1).Name, got.Name)
+
+	replacement := Action("action-1", 3)
+	replacement.Name = "Replaced"
+	assert.Nil(t, repo.PutAction(ctx, testUID, testFlavour, replacement))
+	got, err = repo.GetAction(ctx, testUID, testFlavour, "action-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Replaced", got.Name)
+
+	actions, err = repo.ListActions(ctx, testUID, testFlavour)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"action-2", "action-1"}, actionIDs(actions))
+
+	assert.Nil(t, repo.DeleteAction(ctx, testUID, testFlavour, "action-1"))
+	_, err = repo.GetAction(ctx, testUID, testFlavour, "action-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	err = repo.DeleteAction(ctx, testUID, testFlavour, "action-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+}
+
+func testNudges(t *testing.T, repo feedlib.FeedRepository) {
+	ctx := context.Background()
+
+	_, err := repo.GetNudge(ctx, testUID, testFlavour, "nudge-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	nudges, err := repo.ListNudges(ctx, testUID, testFlavour, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, nudges)
+
+	done := Nudge("nudge-3", 3)
+	done.Status = feedlib.StatusDone
+	hidden := Nudge("nudge-2", 2)
+	hidden.Visibility = feedlib.VisibilityHide
+	for _, nu := range []feedlib.Nudge{done, hidden, Nudge("nudge-1", 1)} {
+		assert.Nil(t, repo.PutNudge(ctx, testUID, testFlavour, nu))
+	}
+
+	got, err := repo.GetNudge(ctx, testUID, testFlavour, "nudge-3")
+	assert.Nil(t, err)
+	assert.Equal(t, done, *got)
+
+	nudges, err = repo.ListNudges(ctx, testUID, testFlavour, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nudge-1", "nudge-2", "nudge-3"}, nudgeIDs(nudges))
+
+	filters := []struct {
+		name   string
+		filter *feedlib.FeedFilter
+		want   []string
+	}{
+		{
+			name:   "empty filter",
+			filter: &feedlib.FeedFilter{},
+			want:   []string{"nudge-1", "nudge-2", "nudge-3"},
+		},
+		{
+			name: "by status",
+			filter: &feedlib.FeedFilter{
+				Status: []feedlib.Status{feedlib.StatusPending},
+			},
+			want: []string{"nudge-1", "nudge-2"},
+		},
+		{
+			name: "by visibility",
+			filter: &feedlib.FeedFilter{
+				Visibility: []feedlib.Visibility{feedlib.VisibilityShow},
+			},
+			want: []string{"nudge-1", "nudge-3"},
+		},
+		{
+			name: "by status and visibility",
+			filter: &feedlib.FeedFilter{
+				Status:     []feedlib.Status{feedlib.StatusPending},
+				Visibility: []feedlib.Visibility{feedlib.VisibilityShow},
+			},
+			want: []string{"nudge-1"},
+		},
+	}
+	for _, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			nudges, err := repo.ListNudges(ctx, testUID, testFlavour, tt.filter)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, nudgeIDs(nudges))
+		})
+	}
+
+	assert.Nil(t, repo.DeleteNudge(ctx, testUID, testFlavour, "nudge-1"))
+	_, err = repo.GetNudge(ctx, testUID, testFlavour, "nudge-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	err = repo.DeleteNudge(ctx, testUID, testFlavour, "nudge-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+}
+
+func testItems(t *testing.T, repo feedlib.FeedRepository) {
+	ctx := context.Background()
+
+	_, err := repo.GetItem(ctx, testUID, testFlavour, "item-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	items, err := repo.ListItems(ctx, testUID, testFlavour, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, items)
+
+	transient := Item("item-3", 3)
+	transient.Persistent = false
+	inProgress := Item("item-2", 2)
+	inProgress.Status = feedlib.StatusInProgress
+	inProgress.Label = "WELLNESS"
+	for _, it := range []feedlib.Item{transient, inProgress, Item("item-1", 1)} {
+		assert.Nil(t, repo.PutItem(ctx, testUID, testFlavour, it))
+	}
+
+	got, err := repo.GetItem(ctx, testUID, testFlavour, "item-3")
+	assert.Nil(t, err)
+	assert.Equal(t, transient, *got)
+
+	filters := []struct {
+		name   string
+		filter *feedlib.FeedFilter
+		want   []string
+	}{
+		{
+			name:   "nil filter",
+			filter: nil,
+			want:   []string{"item-1", "item-2", "item-3"},
+		},
+		{
+			name: "by status",
+			filter: &feedlib.FeedFilter{
+				Status: []feedlib.Status{feedlib.StatusPending, feedlib.StatusDone},
+			},
+			want: []string{"item-1", "item-3"},
+		},
+		{
+			name: "persistent",
+			filter: &feedlib.FeedFilter{
+				Persistent: feedlib.BooleanFilterTrue,
+			},
+			want: []string{"item-1", "item-2"},
+		},
+		{
+			name: "not persistent",
+			filter: &feedlib.FeedFilter{
+				Persistent: feedlib.BooleanFilterFalse,
+			},
+			want: []string{"item-3"},
+		},
+		{
+			name: "both persistent and not persistent",
+			filter: &feedlib.FeedFilter{
+				Persistent: feedlib.BooleanFilterBoth,
+			},
+			want: []string{"item-1", "item-2", "item-3"},
+		},
+		{
+			name: "by label",
+			filter: &feedlib.FeedFilter{
+				Label: "WELLNESS",
+			},
+			want: []string{"item-2"},
+		},
+		{
+			name: "same flavour",
+			filter: &feedlib.FeedFilter{
+				Flavour: testFlavour,
+			},
+			want: []string{"item-1", "item-2", "item-3"},
+		},
+		{
+			name: "other flavour",
+			filter: &feedlib.FeedFilter{
+				Flavour: feedlib.FlavourPro,
+			},
+			want: []string{},
+		},
+	}
+	for _, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := repo.ListItems(ctx, testUID, testFlavour, tt.filter)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, itemIDs(items))
+		})
+	}
+
+	_, err = repo.ListItems(ctx, testUID, testFlavour, &feedlib.FeedFilter{
+		Status: []feedlib.Status{"bogus"},
+	})
+	assert.NotNil(t, err, "invalid filters should be rejected")
+	_, err = repo.ListNudges(ctx, testUID, testFlavour, &feedlib.FeedFilter{
+		Persistent: "bogus",
+	})
+	assert.NotNil(t, err, "invalid filters should be rejected")
+
+	replacement := Item("item-1", 4)
+	replacement.Text = "Replaced"
+	assert.Nil(t, repo.PutItem(ctx, testUID, testFlavour, replacement))
+	items, err = repo.ListItems(ctx, testUID, testFlavour, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"item-2", "item-3", "item-1"}, itemIDs(items))
+	assert.Equal(t, "Replaced", items[2].Text)
+
+	assert.Nil(t, repo.DeleteItem(ctx, testUID, testFlavour, "item-1"))
+	_, err = repo.GetItem(ctx, testUID, testFlavour, "item-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	err = repo.DeleteItem(ctx, testUID, testFlavour, "item-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+}
+
+func testScoping(t *testing.T, repo feedlib.FeedRepository) {
+	ctx := context.Background()
+
+	assert.Nil(t, repo.PutItem(ctx, testUID, feedlib.FlavourConsumer, Item("item-1", 1)))
+	assert.Nil(t, repo.PutNudge(ctx, testUID, feedlib.FlavourConsumer, Nudge("nudge-1", 1)))
+	assert.Nil(t, repo.PutAction(ctx, testUID, feedlib.FlavourConsumer, Action("action-1", 1)))
+
+	for _, scope := range []struct {
+		uid     string
+		flavour feedlib.Flavour
+	}{
+		{uid: testUID, flavour: feedlib.FlavourPro},
+		{uid: "user-2", flavour: feedlib.FlavourConsumer},
+	} {
+		_, err := repo.GetItem(ctx, scope.uid, scope.flavour, "item-1")
+		assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+		_, err = repo.GetNudge(ctx, scope.uid, scope.flavour, "nudge-1")
+		assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+		_, err = repo.GetAction(ctx, scope.uid, scope.flavour, "action-1")
+		assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+		items, err := repo.ListItems(ctx, scope.uid, scope.flavour, nil)
+		assert.Nil(t, err)
+		assert.Empty(t, items)
+
+		err = repo.DeleteItem(ctx, scope.uid, scope.flavour, "item-1")
+		assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+	}
+
+	_, err := repo.GetItem(ctx, testUID, feedlib.FlavourConsumer, "item-1")
+	assert.Nil(t, err)
+}
+
+func testIsolation(t *testing.T, repo feedlib.FeedRepository) {
+	ctx := context.Background()
+
+	it := Item("item-1", 1)
+	assert.Nil(t, repo.PutItem(ctx, testUID, testFlavour, it))
+	it.Users[0] = "mutated after put"
+
+	got, err := repo.GetItem(ctx, testUID, testFlavour, "item-1")
+	assert.Nil(t, err)
+	assert.Equal(t, testUID, got.Users[0])
+	got.Links[0].Title = "mutated after get"
+
+	items, err := repo.ListItems(ctx, testUID, testFlavour, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "title", items[0].Links[0].Title)
+	items[0].Groups[0] = "mutated after list"
+
+	nu := Nudge("nudge-1", 1)
+	assert.Nil(t, repo.PutNudge(ctx, testUID, testFlavour, nu))
+	nu.Groups[0] = "mutated after put"
+
+	gotNudge, err := repo.GetNudge(ctx, testUID, testFlavour, "nudge-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "group-1", gotNudge.Groups[0])
+
+	got, err = repo.GetItem(ctx, testUID, testFlavour, "item-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "group-1", got.Groups[0])
+
+	testDeepIsolation(t, repo)
+}
+
+// testDeepIsolation checks that the maps, pointers and nested actions of
+// stored elements are not shared with callers either
+func testDeepIsolation(t *testing.T, repo feedlib.FeedRepository) {
+	ctx := context.Background()
+
+	publishAt := time.Now().UTC().Truncate(time.Second)
+	ac := Action("action-2", 2)
+	ac.RequiredRoles = []string{"admin"}
+	ac.LocalisedName = feedlib.LocalisedText{"sw": "Kitendo"}
+
+	itemPublishAt := publishAt
+	it := Item("item-2", 2)
+	it.PublishAt = &itemPublishAt
+	it.Actions = []feedlib.Action{ac}
+	it.LocalisedText = feedlib.LocalisedText{"sw": "Habari"}
+	it.LocalisedNotificationBody = feedlib.LocalisedNotificationBody{
+		"sw": {PublishMessage: "Habari mpya"},
+	}
+	assert.Nil(t, repo.PutItem(ctx, testUID, testFlavour, it))
+
+	got, err := repo.GetItem(ctx, testUID, testFlavour, "item-2")
+	assert.Nil(t, err)
+	*got.PublishAt = got.PublishAt.Add(time.Hour)
+	got.Actions[0].RequiredRoles[0] = "mutated after get"
+	got.Actions[0].LocalisedName["sw"] = "mutated after get"
+	got.LocalisedText["sw"] = "mutated after get"
+	got.LocalisedNotificationBody["sw"] = feedlib.NotificationBody{}
+
+	got, err = repo.GetItem(ctx, testUID, testFlavour, "item-2")
+	assert.Nil(t, err)
+	assert.True(t, publishAt.Equal(*got.PublishAt))
+	assert.Equal(t, "admin", got.Actions[0].RequiredRoles[0])
+	assert.Equal(t, "Kitendo", got.Actions[0].LocalisedName["sw"])
+	assert.Equal(t, "Habari", got.LocalisedText["sw"])
+	assert.Equal(t, "Habari mpya", got.LocalisedNotificationBody["sw"].PublishMessage)
+
+	nudgePublishAt := publishAt
+	nu := Nudge("nudge-2", 2)
+	nu.PublishAt = &nudgePublishAt
+	nu.LocalisedTitle = feedlib.LocalisedText{"sw": "Sasisha"}
+	assert.Nil(t, repo.PutNudge(ctx, testUID, testFlavour, nu))
+	*nu.PublishAt = publishAt.Add(time.Hour)
+	nu.LocalisedTitle["sw"] = "mutated after put"
+
+	gotNudge, err := repo.GetNudge(ctx, testUID, testFlavour, "nudge-2")
+	assert.Nil(t, err)
+	assert.True(t, publishAt.Equal(*gotNudge.PublishAt))
+	assert.Equal(t, "Sasisha", gotNudge.LocalisedTitle["sw"])
+
+	assert.Nil(t, repo.PutAction(ctx, testUID, testFlavour, ac))
+	ac.RequiredRoles[0] = "mutated after put"
+
+	gotAction, err := repo.GetAction(ctx, testUID, testFlavour, "action-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", gotAction.RequiredRoles[0])
+	gotAction.LocalisedName["sw"] = "mutated after get"
+
+	actions, err := repo.ListActions(ctx, testUID, testFlavour)
+	assert.Nil(t, err)
+	assert.Len(t, actions, 1)
+	assert.Equal(t, "Kitendo", actions[0].LocalisedName["sw"])
+	actions[0].RequiredRoles[0] = "mutated after list"
+
+	gotAction, err = repo.GetAction(ctx, testUID, testFlavour, "action-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", gotAction.RequiredRoles[0])
+}
+
+func testInvalidInput(t *testing.T, repo feedlib.FeedRepository) {
+	ctx := context.Background()
+
+	assert.NotNil(t, repo.PutItem(ctx, "", testFlavour, Item("item-1", 1)))
+	assert.NotNil(t, repo.PutItem(ctx, testUID, feedlib.Flavour("bogus"), Item("item-1", 1)))
+	assert.NotNil(t, repo.PutItem(ctx, testUID, testFlavour, Item("", 1)))
+	assert.NotNil(t, repo.PutNudge(ctx, testUID, testFlavour, Nudge("", 1)))
+	assert.NotNil(t, repo.PutAction(ctx, testUID, testFlavour, Action("", 1)))
+
+	_, err := repo.ListItems(ctx, "", testFlavour, nil)
+	assert.NotNil(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.NotNil(t, repo.PutItem(cancelled, testUID, testFlavour, Item("item-1", 1)))
+	_, err = repo.ListNudges(cancelled, testUID, testFlavour, nil)
+	assert.NotNil(t, err)
+}
+
+func testConcurrency(t *testing.T, repo feedlib.FeedRepository) {
+	ctx := context.Background()
+
+	const workers = 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			id := fmt.Sprintf("item-%d", w)
+			assert.Nil(t, repo.PutItem(ctx, testUID, testFlavour, Item(id, w)))
+			_, err := repo.GetItem(ctx, testUID, testFlavour, id)
+			assert.Nil(t, err)
+			_, err = repo.ListItems(ctx, testUID, testFlavour, nil)
+			assert.Nil(t, err)
+		}(w)
+	}
+	wg.Wait()
+
+	items, err := repo.ListItems(ctx, testUID, testFlavour, nil)
+	assert.Nil(t, err)
+	assert.Len(t, items, workers)
+}
+
+func actionIDs(actions []feedlib.Action) []string {
+	ids := []string{}
+	for _, ac := range actions {
+		ids = append(ids, ac.ID)
+	}
+	return ids
+}
+
+func nudgeIDs(nudges []feedlib.Nudge) []string {
+	ids := []string{}
+	for _, nu := range nudges {
+		ids = append(ids, nu.ID)
+	}
+	return ids
+}
+
+func itemIDs(items []feedlib.Item) []string {
+	ids := []string{}
+	for _, it := range items {
+		ids = append(ids, it.ID)
+	}
+	return ids
+}
@@ -0,0 +1,138 @@
+package feedtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// RunScheduleStoreTests runs the conformance tests that every
+// feedlib.ScheduleStore implementation should pass.
+//
+// newStore is called once per test and should return an empty store, so
+// that tests do not see each other's data.
+func RunScheduleStoreTests(t *testing.T, newStore func() feedlib.ScheduleStore) {
+	t.Run("scheduled elements", func(t *testing.T) {
+		testScheduled(t, newStore())
+	})
+	t.Run("scheduled elements can't be mutated by callers", func(t *testing.T) {
+		testScheduledIsolation(t, newStore())
+	})
+	t.Run("invalid scheduled elements are rejected", func(t *testing.T) {
+		testInvalidScheduled(t, newStore())
+	})
+}
+
+func scheduledItem(uid string, flavour feedlib.Flavour, it feedlib.Item) feedlib.ScheduledElement {
+	return feedlib.ScheduledElement{
+		Feed: feedlib.FeedRef{UID: uid, Flavour: flavour},
+		Item: &it,
+	}
+}
+
+func scheduledNudge(uid string, flavour feedlib.Flavour, nu feedlib.Nudge) feedlib.ScheduledElement {
+	return feedlib.ScheduledElement{
+		Feed:  feedlib.FeedRef{UID: uid, Flavour: flavour},
+		Nudge: &nu,
+	}
+}
+
+func testScheduled(t *testing.T, store feedlib.ScheduleStore) {
+	ctx := context.Background()
+
+	pending, err := store.ListScheduled(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+
+	later := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	it := Item("item-1", 1)
+	it.PublishAt = &later
+	elements := []feedlib.ScheduledElement{
+		scheduledItem(testUID, testFlavour, it),
+		scheduledNudge(testUID, testFlavour, Nudge("item-1", 1)),
+		scheduledItem(testUID, feedlib.FlavourPro, Item("item-1", 1)),
+		scheduledItem("user-2", testFlavour, Item("item-1", 1)),
+	}
+	for _, se := range elements {
+		assert.Nil(t, store.PutScheduled(ctx, se))
+	}
+
+	pending, err = store.ListScheduled(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, pending, 4, "elements are scoped by feed and kind")
+
+	replacement := Item("item-1", 2)
+	replacement.Text = "Replaced"
+	assert.Nil(t, store.PutScheduled(ctx, scheduledItem(testUID, testFlavour, replacement)))
+	pending, err = store.ListScheduled(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, pending, 4)
+	replaced := 0
+	for _, se := range pending {
+		if se.Item != nil && se.Item.Text == "Replaced" {
+			replaced++
+			assert.Equal(t, feedlib.FeedRef{UID: testUID, Flavour: testFlavour}, se.Feed)
+		}
+	}
+	assert.Equal(t, 1, replaced)
+
+	assert.Nil(t, store.DeleteScheduled(ctx, elements[1]))
+	err = store.DeleteScheduled(ctx, elements[1])
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	pending, err = store.ListScheduled(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, pending, 3)
+	for _, se := range pending {
+		assert.Nil(t, se.Nudge)
+	}
+}
+
+func testScheduledIsolation(t *testing.T, store feedlib.ScheduleStore) {
+	ctx := context.Background()
+
+	it := Item("item-1", 1)
+	se := scheduledItem(testUID, testFlavour, it)
+	assert.Nil(t, store.PutScheduled(ctx, se))
+	se.Item.Text = "Changed after scheduling"
+	se.Item.Users[0] = "someone-else"
+
+	pending, err := store.ListScheduled(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, it.Text, pending[0].Item.Text)
+	assert.Equal(t, testUID, pending[0].Item.Users[0])
+
+	pending[0].Item.Text = "Changed after listing"
+	pending, err = store.ListScheduled(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, it.Text, pending[0].Item.Text)
+}
+
+func testInvalidScheduled(t *testing.T, store feedlib.ScheduleStore) {
+	ctx := context.Background()
+	it := Item("item-1", 1)
+	nu := Nudge("nudge-1", 1)
+
+	invalid := []feedlib.ScheduledElement{
+		scheduledItem("", testFlavour, it),
+		scheduledItem(testUID, feedlib.Flavour("bogus"), it),
+		{Feed: feedlib.FeedRef{UID: testUID, Flavour: testFlavour}},
+		{Feed: feedlib.FeedRef{UID: testUID, Flavour: testFlavour}, Item: &it, Nudge: &nu},
+	}
+	for _, se := range invalid {
+		assert.NotNil(t, store.PutScheduled(ctx, se))
+	}
+	pending, err := store.ListScheduled(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.NotNil(t, store.PutScheduled(cancelled, scheduledItem(testUID, testFlavour, it)))
+	_, err = store.ListScheduled(cancelled)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,250 @@
+package feedlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FeedFilter selects the nudges and items of a feed e.g for a feed query or
+// when listing them from a FeedRepository. Empty fields do not filter, so the
+// zero value matches everything.
+//
+// It can be used directly as a GraphQL input: it implements the gqlgen
+// Marshaler and Unmarshaler interfaces.
+type FeedFilter struct {
+	// Only return items that are (or are not) persistent.
+	// Nudges are not affected by this field.
+	Persistent BooleanFilter `json:"persistent,omitempty"`
+
+	// Only return elements with one of these statuses
+	Status []Status `json:"status,omitempty"`
+
+	// Only return elements with one of these visibility values
+	Visibility []Visibility `json:"visibility,omitempty"`
+
+	// Only return elements that expire at or after this time
+	ExpiresAfter *time.Time `json:"expiresAfter,omitempty"`
+
+	// Only return elements that expire before this time
+	ExpiresBefore *time.Time `json:"expiresBefore,omitempty"`
+
+	// Only return items with this label.
+	// Nudges are not affected by this field.
+	Label string `json:"label,omitempty"`
+
+	// Only return items by this author.
+	// Nudges are not affected by this field.
+	Author string `json:"author,omitempty"`
+
+	// Only return elements that are addressed to at least one of these users
+	Users []string `json:"users,omitempty"`
+
+	// Only return elements that are addressed to at least one of these groups
+	Groups []string `json:"groups,omitempty"`
+
+	// Only return elements from feeds of this flavour. Elements don't know
+	// which feed they are in, so this field only affects FilterFeed and
+	// FeedRepository listings.
+	Flavour Flavour `json:"flavour,omitempty"`
+}
+
+// Validate checks that the filter's enum values are valid and that its
+// expiry window is not empty
+func (f *FeedFilter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	if f.Persistent != "" && !f.Persistent.IsValid() {
+		return fmt.Errorf("%s is not a valid BooleanFilter", f.Persistent)
+	}
+	for _, s := range f.Status {
+		if !s.IsValid() {
+			return fmt.Errorf("%s is not a valid Status", s)
+		}
+	}
+	for _, v := range f.Visibility {
+		if !v.IsValid() {
+			return fmt.Errorf("%s is not a valid Visibility", v)
+		}
+	}
+	if f.Flavour != "" && !f.Flavour.IsValid() {
+		return fmt.Errorf("%s is not a valid Flavour", f.Flavour)
+	}
+	if f.ExpiresAfter != nil && f.ExpiresBefore != nil &&
+		!f.ExpiresAfter.Before(*f.ExpiresBefore) {
+		return fmt.Errorf("expiresAfter must be before expiresBefore")
+	}
+	return nil
+}
+
+// MatchesNudge returns true if the nudge passes the filter
+func (f *FeedFilter) MatchesNudge(nu Nudge) bool {
+	if f == nil {
+		return true
+	}
+	return matchesStatus(f.Status, nu.Status) &&
+		matchesVisibility(f.Visibility, nu.Visibility) &&
+		f.matchesExpiry(nu.Expiry) &&
+		matchesAny(f.Users, nu.Users) &&
+		matchesAny(f.Groups, nu.Groups)
+}
+
+// MatchesItem returns true if the item passes the filter
+func (f *FeedFilter) MatchesItem(it Item) bool {
+	if f == nil {
+		return true
+	}
+	return matchesBooleanFilter(f.Persistent, it.Persistent) &&
+		matchesStatus(f.Status, it.Status) &&
+		matchesVisibility(f.Visibility, it.Visibility) &&
+		f.matchesExpiry(it.Expiry) &&
+		(f.Label == "" || f.Label == it.Label) &&
+		(f.Author == "" || f.Author == it.Author) &&
+		matchesAny(f.Users, it.Users) &&
+		matchesAny(f.Groups, it.Groups)
+}
+
+// matchesFlavour returns true if the filter selects elements from a feed of
+// the supplied flavour
+func (f *FeedFilter) matchesFlavour(flavour Flavour) bool {
+	return f == nil || f.Flavour == "" || f.Flavour == flavour
+}
+
+// matchesExpiry returns true if the expiry is in the filter's window
+func (f *FeedFilter) matchesExpiry(expiry time.Time) bool {
+	if f.ExpiresAfter != nil && expiry.Before(*f.ExpiresAfter) {
+		return false
+	}
+	if f.ExpiresBefore != nil && !expiry.Before(*f.ExpiresBefore) {
+		return false
+	}
+	return true
+}
+
+func matchesStatus(want []Status, got Status) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, s := range want {
+		if s == got {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesVisibility(want []Visibility, got Visibility) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, v := range want {
+		if v == got {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesBooleanFilter(want BooleanFilter, got bool) bool {
+	switch want {
+	case BooleanFilterTrue:
+		return got
+	case BooleanFilterFalse:
+		return !got
+	}
+	return true
+}
+
+// matchesAny returns true if nothing is wanted or if got has at least one
+// of the wanted values
+func matchesAny(want []string, got []string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		for _, g := range got {
+			if w == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FilterNudges returns the nudges that pass the filter, in their original
+// order. A nil filter matches every nudge.
+func FilterNudges(nudges []Nudge, f *FeedFilter) []Nudge {
+	filtered := []Nudge{}
+	for _, nu := range nudges {
+		if f.MatchesNudge(nu) {
+			filtered = append(filtered, nu)
+		}
+	}
+	return filtered
+}
+
+// FilterItems returns the items that pass the filter, in their original
+// order. A nil filter matches every item.
+func FilterItems(items []Item, f *FeedFilter) []Item {
+	filtered := []Item{}
+	for _, it := range items {
+		if f.MatchesItem(it) {
+			filtered = append(filtered, it)
+		}
+	}
+	return filtered
+}
+
+// FilterFeed returns a copy of the feed with only the nudges and items that
+// pass the filter. When the filter asks for a different flavour, the copy
+// has no nudges or items. Global actions are not filtered.
+func FilterFeed(fe Feed, f *FeedFilter) Feed {
+	filtered := fe
+	if !f.matchesFlavour(fe.Flavour) {
+		filtered.Nudges = []Nudge{}
+		filtered.Items = []Item{}
+		return filtered
+	}
+	filtered.Nudges = FilterNudges(fe.Nudges, f)
+	filtered.Items = FilterItems(fe.Items, f)
+	return filtered
+}
+
+// UnmarshalGQL reads a feed filter from a GraphQL input object and
+// validates it
+func (f *FeedFilter) UnmarshalGQL(v interface{}) error {
+	input, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("a FeedFilter must be an input object")
+	}
+	b, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("can't read the FeedFilter: %w", err)
+	}
+
+	candidate := FeedFilter{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&candidate); err != nil {
+		return fmt.Errorf("%s is not a valid FeedFilter: %w", string(b), err)
+	}
+	if err := candidate.Validate(); err != nil {
+		return err
+	}
+	*f = candidate
+	return nil
+}
+
+// MarshalGQL writes the feed filter to the supplied writer as a JSON object
+func (f FeedFilter) MarshalGQL(w io.Writer) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		// a FeedFilter only has fields that can be marshalled
+		fmt.Fprint(w, "{}")
+		return
+	}
+	_, _ = w.Write(b)
+}
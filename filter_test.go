@@ -0,0 +1,204 @@
+package feedlib_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedFilter_FilterItems(t *testing.T) {
+	now := time.Now()
+	soon := now.Add(30 * time.Minute)
+	later := now.Add(2 * time.Hour)
+
+	pinned := feedtest.Item("pinned", 1)
+	pinned.Expiry = later
+	done := feedtest.Item("done", 2)
+	done.Persistent = false
+	done.Status = feedlib.StatusDone
+	hidden := feedtest.Item("hidden", 3)
+	hidden.Persistent = false
+	hidden.Visibility = feedlib.VisibilityHide
+	hidden.Label = "WELLNESS"
+	hidden.Author = "Nurse 1"
+	hidden.Users = []string{"user-2"}
+	hidden.Groups = []string{"group-2"}
+	items := []feedlib.Item{pinned, done, hidden}
+
+	tests := []struct {
+		name   string
+		filter *feedlib.FeedFilter
+		want   []string
+	}{
+		{name: "nil filter", filter: nil, want: []string{"pinned", "done", "hidden"}},
+		{name: "empty filter", filter: &feedlib.FeedFilter{}, want: []string{"pinned", "done", "hidden"}},
+		{
+			name:   "persistent",
+			filter: &feedlib.FeedFilter{Persistent: feedlib.BooleanFilterTrue},
+			want:   []string{"pinned"},
+		},
+		{
+			name:   "not persistent",
+			filter: &feedlib.FeedFilter{Persistent: feedlib.BooleanFilterFalse},
+			want:   []string{"done", "hidden"},
+		},
+		{
+			name:   "both",
+			filter: &feedlib.FeedFilter{Persistent: feedlib.BooleanFilterBoth},
+			want:   []string{"pinned", "done", "hidden"},
+		},
+		{
+			name:   "status",
+			filter: &feedlib.FeedFilter{Status: []feedlib.Status{feedlib.StatusDone}},
+			want:   []string{"done"},
+		},
+		{
+			name:   "visibility",
+			filter: &feedlib.FeedFilter{Visibility: []feedlib.Visibility{feedlib.VisibilityShow}},
+			want:   []string{"pinned", "done"},
+		},
+		{
+			name:   "expiry window",
+			filter: &feedlib.FeedFilter{ExpiresAfter: &soon, ExpiresBefore: &later},
+			want:   []string{"done", "hidden"},
+		},
+		{
+			name:   "expires after",
+			filter: &feedlib.FeedFilter{ExpiresAfter: &later},
+			want:   []string{"pinned"},
+		},
+		{name: "label", filter: &feedlib.FeedFilter{Label: "WELLNESS"}, want: []string{"hidden"}},
+		{name: "author", filter: &feedlib.FeedFilter{Author: "Bot 1"}, want: []string{"pinned", "done"}},
+		{
+			name:   "users",
+			filter: &feedlib.FeedFilter{Users: []string{"user-2", "user-3"}},
+			want:   []string{"hidden"},
+		},
+		{name: "groups", filter: &feedlib.FeedFilter{Groups: []string{"group-1"}}, want: []string{"pinned", "done"}},
+		{
+			name: "combined",
+			filter: &feedlib.FeedFilter{
+				Persistent: feedlib.BooleanFilterFalse,
+				Visibility: []feedlib.Visibility{feedlib.VisibilityShow},
+			},
+			want: []string{"done"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, it := range feedlib.FilterItems(items, tt.filter) {
+				got = append(got, it.ID)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFeedFilter_FilterNudges(t *testing.T) {
+	shown := feedtest.Nudge("shown", 1)
+	hidden := feedtest.Nudge("hidden", 2)
+	hidden.Visibility = feedlib.VisibilityHide
+	hidden.Groups = []string{"group-2"}
+	nudges := []feedlib.Nudge{shown, hidden}
+
+	got := feedlib.FilterNudges(nudges, &feedlib.FeedFilter{
+		Visibility: []feedlib.Visibility{feedlib.VisibilityShow},
+		Persistent: feedlib.BooleanFilterTrue,
+		Label:      "ignored for nudges",
+	})
+	assert.Equal(t, []feedlib.Nudge{shown}, got)
+
+	got = feedlib.FilterNudges(nudges, &feedlib.FeedFilter{Groups: []string{"group-2"}})
+	assert.Equal(t, []feedlib.Nudge{hidden}, got)
+
+	assert.Empty(t, feedlib.FilterNudges(nil, nil))
+}
+
+func TestFilterFeed(t *testing.T) {
+	fe := getTestFeed()
+	fe.Flavour = feedlib.FlavourConsumer
+
+	filtered := feedlib.FilterFeed(*fe, &feedlib.FeedFilter{Flavour: feedlib.FlavourPro})
+	assert.Empty(t, filtered.Nudges)
+	assert.Empty(t, filtered.Items)
+	assert.Equal(t, fe.Actions, filtered.Actions)
+
+	filtered = feedlib.FilterFeed(*fe, &feedlib.FeedFilter{Flavour: feedlib.FlavourConsumer})
+	assert.Equal(t, fe.Nudges, filtered.Nudges)
+	assert.Equal(t, fe.Items, filtered.Items)
+}
+
+func TestFeedFilter_UnmarshalGQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       interface{}
+		want    feedlib.FeedFilter
+		wantErr bool
+	}{
+		{
+			name: "valid input",
+			v: map[string]interface{}{
+				"persistent":   "TRUE",
+				"status":       []interface{}{"PENDING", "DONE"},
+				"visibility":   []interface{}{"SHOW"},
+				"expiresAfter": "2021-06-01T00:00:00Z",
+				"label":        "DRUGS",
+				"users":        []interface{}{"user-1"},
+				"flavour":      "CONSUMER",
+			},
+			want: feedlib.FeedFilter{
+				Persistent:   feedlib.BooleanFilterTrue,
+				Status:       []feedlib.Status{feedlib.StatusPending, feedlib.StatusDone},
+				Visibility:   []feedlib.Visibility{feedlib.VisibilityShow},
+				ExpiresAfter: timePtr(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)),
+				Label:        "DRUGS",
+				Users:        []string{"user-1"},
+				Flavour:      feedlib.FlavourConsumer,
+			},
+		},
+		{name: "empty input", v: map[string]interface{}{}, want: feedlib.FeedFilter{}},
+		{name: "not an object", v: "TRUE", wantErr: true},
+		{name: "unknown field", v: map[string]interface{}{"colour": "red"}, wantErr: true},
+		{name: "invalid enum", v: map[string]interface{}{"persistent": "MAYBE"}, wantErr: true},
+		{name: "invalid status", v: map[string]interface{}{"status": []interface{}{"BOGUS"}}, wantErr: true},
+		{name: "invalid flavour", v: map[string]interface{}{"flavour": "BOGUS"}, wantErr: true},
+		{
+			name: "empty expiry window",
+			v: map[string]interface{}{
+				"expiresAfter":  "2021-06-01T00:00:00Z",
+				"expiresBefore": "2021-05-01T00:00:00Z",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got feedlib.FeedFilter
+			err := got.UnmarshalGQL(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FeedFilter.UnmarshalGQL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFeedFilter_MarshalGQL(t *testing.T) {
+	f := feedlib.FeedFilter{
+		Persistent: feedlib.BooleanFilterFalse,
+		Groups:     []string{"group-1"},
+	}
+	w := &bytes.Buffer{}
+	f.MarshalGQL(w)
+	assert.Equal(t, `{"persistent":"FALSE","groups":["group-1"]}`, w.String())
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,340 @@
+package feedlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoActionHandler is returned when an action is triggered that no handler
+// is registered for
+var ErrNoActionHandler = errors.New("no action handler")
+
+// ErrHandlingMismatch is returned when an action's handling does not match
+// what its handler supports or what the handler returned
+var ErrHandlingMismatch = errors.New("action handling mismatch")
+
+// ActionRequest is a client's request to trigger an action
+type ActionRequest struct {
+	// The action that was triggered. Its name picks the handler; by the time
+	// the request reaches middleware and handlers, its AllowAnonymous and
+	// RequiredRoles are those of the action's registered definition.
+	Action Action
+
+	// The event that the client sent when it triggered the action
+	Event Event
+
+	// Whoever triggered the action
+	Principal Principal
+}
+
+// ActionResult is what a handler returns for a triggered action
+type ActionResult struct {
+	// How the client should present the result. It is set to the action's
+	// handling when a handler leaves it empty.
+	Handling Handling `json:"handling"`
+
+	// A message to show the user e.g "Your PIN has been changed"
+	Message string `json:"message,omitempty"`
+
+	// The page to open. Required for FULL_PAGE actions and not allowed for
+	// INLINE ones, which are presented in place.
+	Link *Link `json:"link,omitempty"`
+
+	// Any other data for the client
+	Data map[string]interface{} `json:"data,omitempty"`
+
+	// Events that the handler emitted e.g for auditing
+	Events []*Event `json:"events,omitempty"`
+}
+
+// ActionHandlerFunc handles a triggered action
+type ActionHandlerFunc func(ctx context.Context, req ActionRequest) (*ActionResult, error)
+
+// ActionMiddleware wraps an action handler e.g to log or authorize requests
+type ActionMiddleware func(next ActionHandlerFunc) ActionHandlerFunc
+
+type actionHandler struct {
+	action Action
+	fn     ActionHandlerFunc
+}
+
+// ActionRegistry maps action names to the canonical definitions of the
+// actions and the handlers that run them. It is safe for concurrent use.
+type ActionRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]actionHandler
+}
+
+// NewActionRegistry initializes an empty action registry
+func NewActionRegistry() *ActionRegistry {
+	return &ActionRegistry{
+		handlers: map[string]actionHandler{},
+	}
+}
+
+// Register records the canonical definition of an action and its handler,
+// keyed by the action's name. The definition's handling, AllowAnonymous and
+// RequiredRoles are what dispatched requests are checked and authorized
+// against; the copies that clients send are not trusted.
+//
+// Registering a name that is already registered replaces its definition and
+// handler.
+func (r *ActionRegistry) Register(ac Action, fn ActionHandlerFunc) error {
+	if ac.Name == "" {
+		return fmt.Errorf("an action name is required")
+	}
+	if !ac.Handling.IsValid() {
+		return fmt.Errorf("%s is not a valid Handling", ac.Handling)
+	}
+	if fn == nil {
+		return fmt.Errorf("a handler is required for %s", ac.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[ac.Name] = actionHandler{action: copyAction(ac), fn: fn}
+	return nil
+}
+
+// Unregister forgets the named action
+func (r *ActionRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.handlers, name)
+}
+
+// Definition returns the registered definition of the named action
+func (r *ActionRegistry) Definition(name string) (Action, bool) {
+	h, ok := r.lookup(name)
+	if !ok {
+		return Action{}, false
+	}
+	return copyAction(h.action), true
+}
+
+// IsRegistered returns true if a handler is registered for the action
+func (r *ActionRegistry) IsRegistered(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.handlers[name]
+	return ok
+}
+
+// Names returns the registered action names, sorted
+func (r *ActionRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := []string{}
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *ActionRegistry) lookup(name string) (actionHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.handlers[name]
+	return h, ok
+}
+
+// ActionDispatcher runs triggered actions through the handlers in a registry,
+// authorizing every request before it reaches its handler
+type ActionDispatcher struct {
+	registry   *ActionRegistry
+	authorizer *Authorizer
+	middleware []ActionMiddleware
+}
+
+// NewActionDispatcher initializes a dispatcher for the handlers in the
+// registry. A nil authorizer means NewDefaultAuthorizer(), so that
+// Action.AllowAnonymous and Action.RequiredRoles are always enforced.
+// Middleware runs in the order that it is supplied, the first middleware
+// being the outermost.
+func NewActionDispatcher(
+	registry *ActionRegistry,
+	authorizer *Authorizer,
+	middleware ...ActionMiddleware,
+) (*ActionDispatcher, error) {
+	if registry == nil {
+		return nil, fmt.Errorf("an action registry is required")
+	}
+	if authorizer == nil {
+		authorizer = NewDefaultAuthorizer()
+	}
+	return &ActionDispatcher{
+		registry:   registry,
+		authorizer: authorizer,
+		middleware: append([]ActionMiddleware{}, middleware...),
+	}, nil
+}
+
+// Use adds middleware inside the middleware that is already in use
+func (d *ActionDispatcher) Use(middleware ...ActionMiddleware) {
+	d.middleware = append(d.middleware, middleware...)
+}
+
+// Dispatch validates a request, runs it through the middleware and the
+// handler that is registered for the action's name and checks the result.
+//
+// The action and event must pass validation; the event's payload is also
+// checked against the DefaultEventRegistry. The caller must be the user that
+// the event says triggered the action, so an anonymous caller is rejected
+// when the event names a user. The action's handling must match its
+// registered definition, and so must the result.
+//
+// The request's AllowAnonymous and RequiredRoles are replaced with those of
+// the registered definition before the request is authorized, so a client
+// can't loosen an action's policy. The dispatcher's authorizer and the event
+// actor check run inside the middleware, so middleware sees denied requests
+// but their handlers never run.
+func (d *ActionDispatcher) Dispatch(ctx context.Context, req ActionRequest) (*ActionResult, error) {
+	if _, err := req.Action.ValidateAndMarshal(); err != nil {
+		return nil, err
+	}
+	if _, err := req.Event.ValidateAndMarshal(); err != nil {
+		return nil, err
+	}
+	h, ok := d.registry.lookup(req.Action.Name)
+	if !ok {
+		return nil, fmt.Errorf("action %s: %w", req.Action.Name, ErrNoActionHandler)
+	}
+	if h.action.Handling != req.Action.Handling {
+		return nil, fmt.Errorf(
+			"action %s is handled %s but its handler supports %s: %w",
+			req.Action.Name, req.Action.Handling, h.action.Handling, ErrHandlingMismatch,
+		)
+	}
+	req.Action.AllowAnonymous = h.action.AllowAnonymous
+	req.Action.RequiredRoles = copyStrings(h.action.RequiredRoles)
+
+	fn := authorize(d.authorizer, h.fn)
+	for i := len(d.middleware) - 1; i >= 0; i-- {
+		fn = d.middleware[i](fn)
+	}
+	result, err := fn(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkActionResult(req.Action, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkEventActor makes sure that a caller does not send an event on someone
+// else's behalf: an event that names a user must come from that user, so an
+// anonymous caller can't send one.
+func checkEventActor(req ActionRequest) error {
+	if req.Event.Context.UserID == "" || req.Event.Context.UserID == req.Principal.UserID {
+		return nil
+	}
+	return &AuthorizationError{
+		ActionID: req.Action.ID,
+		UserID:   req.Principal.UserID,
+		Policy:   "actor",
+		Reason: fmt.Sprintf(
+			"the event was sent on behalf of user %s", req.Event.Context.UserID),
+		Err: ErrForbidden,
+	}
+}
+
+// checkActionResult makes sure that a handler's result can be presented as
+// the action's handling requires, defaulting the result's handling
+func checkActionResult(ac Action, result *ActionResult) error {
+	if result == nil {
+		return fmt.Errorf("the handler of action %s returned no result", ac.Name)
+	}
+	if result.Handling == "" {
+		result.Handling = ac.Handling
+	}
+	if result.Handling != ac.Handling {
+		return fmt.Errorf(
+			"action %s is handled %s but its handler returned a %s result: %w",
+			ac.Name, ac.Handling, result.Handling, ErrHandlingMismatch,
+		)
+	}
+	switch result.Handling {
+	case HandlingFullPage:
+		if result.Link == nil || result.Link.URL == "" {
+			return fmt.Errorf(
+				"the %s result of action %s needs a link to open: %w",
+				result.Handling, ac.Name, ErrHandlingMismatch,
+			)
+		}
+	case HandlingInline:
+		if result.Link != nil {
+			return fmt.Errorf(
+				"the %s result of action %s can't open a link: %w",
+				result.Handling, ac.Name, ErrHandlingMismatch,
+			)
+		}
+	}
+	return nil
+}
+
+// AuthorizationMiddleware only lets requests through that the authorizer
+// allows for the request's principal and whose event names the principal as
+// the user that sent it. Every ActionDispatcher already has an
+// authorizer; this middleware is for checks that should run further out, e.g
+// before expensive middleware.
+func AuthorizationMiddleware(a *Authorizer) (ActionMiddleware, error) {
+	if a == nil {
+		return nil, fmt.Errorf("an authorizer is required")
+	}
+	return func(next ActionHandlerFunc) ActionHandlerFunc {
+		return authorize(a, next)
+	}, nil
+}
+
+// authorize wraps a handler so that it only runs for requests that the
+// authorizer allows and whose event was sent by the caller
+func authorize(a *Authorizer, next ActionHandlerFunc) ActionHandlerFunc {
+	return func(ctx context.Context, req ActionRequest) (*ActionResult, error) {
+		if err := a.Authorize(ctx, req.Principal, req.Action); err != nil {
+			return nil, err
+		}
+		if err := checkEventActor(req); err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+// LoggingMiddleware logs every request, how long it took and how it ended.
+// A nil logger means the standard logger.
+func LoggingMiddleware(logger *log.Logger) ActionMiddleware {
+	logf := log.Printf
+	if logger != nil {
+		logf = logger.Printf
+	}
+	return func(next ActionHandlerFunc) ActionHandlerFunc {
+		return func(ctx context.Context, req ActionRequest) (*ActionResult, error) {
+			start := time.Now()
+			result, err := next(ctx, req)
+			user := req.Principal.UserID
+			if user == "" {
+				user = "anonymous"
+			}
+			if err != nil {
+				logf("action %s (%s) by %s failed after %s: %s",
+					req.Action.Name, req.Action.ID, user, time.Since(start), err)
+				return nil, err
+			}
+			logf("action %s (%s) by %s handled in %s",
+				req.Action.Name, req.Action.ID, user, time.Since(start))
+			return result, nil
+		}
+	}
+}
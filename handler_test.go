@@ -0,0 +1,317 @@
+package feedlib_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestActionRequest(handling feedlib.Handling) feedlib.ActionRequest {
+	ac := feedtest.Action("action-1", 1)
+	ac.Name = "Change PIN"
+	ac.Handling = handling
+	return feedlib.ActionRequest{
+		Action:    ac,
+		Event:     getTestEvent("ACTION_TRIGGERED", map[string]interface{}{"actionID": ac.ID}),
+		Principal: feedlib.Principal{UserID: "user-1"},
+	}
+}
+
+func TestActionRegistry(t *testing.T) {
+	r := feedlib.NewActionRegistry()
+	fn := func(ctx context.Context, req feedlib.ActionRequest) (*feedlib.ActionResult, error) {
+		return &feedlib.ActionResult{}, nil
+	}
+
+	assert.NotNil(t, r.Register(feedlib.Action{Name: "", Handling: feedlib.HandlingInline}, fn))
+	assert.NotNil(t, r.Register(
+		feedlib.Action{Name: "Change PIN", Handling: feedlib.Handling("bogus")}, fn))
+	assert.NotNil(t, r.Register(feedlib.Action{Name: "Change PIN", Handling: feedlib.HandlingInline}, nil))
+
+	assert.Nil(t, r.Register(feedlib.Action{Name: "Change PIN", Handling: feedlib.HandlingInline}, fn))
+	assert.Nil(t, r.Register(feedlib.Action{Name: "Accept terms", Handling: feedlib.HandlingFullPage}, fn))
+	assert.True(t, r.IsRegistered("Change PIN"))
+	assert.Equal(t, []string{"Accept terms", "Change PIN"}, r.Names())
+
+	r.Unregister("Change PIN")
+	assert.False(t, r.IsRegistered("Change PIN"))
+}
+
+func TestActionDispatcher_Dispatch(t *testing.T) {
+	page := feedlib.GetPNGImageLink(
+		feedlib.LogoURL, "title", "description", feedlib.BlankImageURL)
+
+	tests := []struct {
+		name           string
+		registered     feedlib.Handling
+		handling       feedlib.Handling
+		result         *feedlib.ActionResult
+		mutate         func(req *feedlib.ActionRequest)
+		wantErr        bool
+		wantErrIs      error
+		wantValidation bool
+	}{
+		{
+			name:       "inline",
+			registered: feedlib.HandlingInline,
+			handling:   feedlib.HandlingInline,
+			result:     &feedlib.ActionResult{Message: "Your PIN has been changed"},
+		},
+		{
+			name:       "full page",
+			registered: feedlib.HandlingFullPage,
+			handling:   feedlib.HandlingFullPage,
+			result:     &feedlib.ActionResult{Link: &page},
+		},
+		{
+			name:       "no handler",
+			registered: feedlib.HandlingInline,
+			handling:   feedlib.HandlingInline,
+			mutate: func(req *feedlib.ActionRequest) {
+				req.Action.Name = "Unknown"
+			},
+			wantErr:   true,
+			wantErrIs: feedlib.ErrNoActionHandler,
+		},
+		{
+			name:       "handler supports different handling",
+			registered: feedlib.HandlingFullPage,
+			handling:   feedlib.HandlingInline,
+			wantErr:    true,
+			wantErrIs:  feedlib.ErrHandlingMismatch,
+		},
+		{
+			name:       "full page result without a link",
+			registered: feedlib.HandlingFullPage,
+			handling:   feedlib.HandlingFullPage,
+			result:     &feedlib.ActionResult{},
+			wantErr:    true,
+			wantErrIs:  feedlib.ErrHandlingMismatch,
+		},
+		{
+			name:       "inline result with a link",
+			registered: feedlib.HandlingInline,
+			handling:   feedlib.HandlingInline,
+			result:     &feedlib.ActionResult{Link: &page},
+			wantErr:    true,
+			wantErrIs:  feedlib.ErrHandlingMismatch,
+		},
+		{
+			name:       "result with the wrong handling",
+			registered: feedlib.HandlingInline,
+			handling:   feedlib.HandlingInline,
+			result:     &feedlib.ActionResult{Handling: feedlib.HandlingFullPage, Link: &page},
+			wantErr:    true,
+			wantErrIs:  feedlib.ErrHandlingMismatch,
+		},
+		{
+			name:       "no result",
+			registered: feedlib.HandlingInline,
+			handling:   feedlib.HandlingInline,
+			wantErr:    true,
+		},
+		{
+			name:       "invalid event",
+			registered: feedlib.HandlingInline,
+			handling:   feedlib.HandlingInline,
+			mutate: func(req *feedlib.ActionRequest) {
+				req.Event.Name = "bogus"
+			},
+			wantErr:        true,
+			wantValidation: true,
+		},
+		{
+			name:       "invalid action",
+			registered: feedlib.HandlingInline,
+			handling:   feedlib.HandlingInline,
+			mutate: func(req *feedlib.ActionRequest) {
+				req.Action.ID = ""
+			},
+			wantErr:        true,
+			wantValidation: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := feedlib.NewActionRegistry()
+			assert.Nil(t, r.Register(feedlib.Action{Name: "Change PIN", Handling: tt.registered},
+				func(ctx context.Context, req feedlib.ActionRequest) (*feedlib.ActionResult, error) {
+					return tt.result, nil
+				}))
+			d, err := feedlib.NewActionDispatcher(r, nil)
+			assert.Nil(t, err)
+
+			req := getTestActionRequest(tt.handling)
+			if tt.mutate != nil {
+				tt.mutate(&req)
+			}
+			got, err := d.Dispatch(context.Background(), req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ActionDispatcher.Dispatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if tt.wantErrIs != nil {
+					assert.True(t, errors.Is(err, tt.wantErrIs), "got %v", err)
+				}
+				var vErr *feedlib.ValidationError
+				assert.Equal(t, tt.wantValidation, errors.As(err, &vErr))
+				return
+			}
+			assert.Equal(t, tt.handling, got.Handling, "the result's handling is defaulted")
+		})
+	}
+
+	_, err := feedlib.NewActionDispatcher(nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestActionDispatcher_Middleware(t *testing.T) {
+	calls := []string{}
+	trace := func(name string) feedlib.ActionMiddleware {
+		return func(next feedlib.ActionHandlerFunc) feedlib.ActionHandlerFunc {
+			return func(ctx context.Context, req feedlib.ActionRequest) (*feedlib.ActionResult, error) {
+				calls = append(calls, name)
+				return next(ctx, req)
+			}
+		}
+	}
+
+	r := feedlib.NewActionRegistry()
+	assert.Nil(t, r.Register(feedlib.Action{Name: "Change PIN", Handling: feedlib.HandlingInline},
+		func(ctx context.Context, req feedlib.ActionRequest) (*feedlib.ActionResult, error) {
+			calls = append(calls, "handler")
+			return &feedlib.ActionResult{Message: "done"}, nil
+		}))
+
+	w := &bytes.Buffer{}
+	d, err := feedlib.NewActionDispatcher(r, nil,
+		feedlib.LoggingMiddleware(log.New(w, "", 0)), trace("first"))
+	assert.Nil(t, err)
+	d.Use(trace("second"))
+
+	req := getTestActionRequest(feedlib.HandlingInline)
+	_, err = d.Dispatch(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+	assert.Contains(t, w.String(), "action Change PIN (action-1) by user-1 handled in")
+
+	calls = nil
+	w.Reset()
+	req.Principal = feedlib.Principal{}
+	_, err = d.Dispatch(context.Background(), req)
+	assert.True(t, errors.Is(err, feedlib.ErrUnauthenticated))
+	assert.Equal(t, []string{"first", "second"}, calls, "denied requests don't reach the handler")
+	assert.Contains(t, w.String(), "by anonymous failed")
+}
+
+func TestActionDispatcher_Authorization(t *testing.T) {
+	r := feedlib.NewActionRegistry()
+	assert.Nil(t, r.Register(feedlib.Action{
+		Name:          "Change PIN",
+		Handling:      feedlib.HandlingInline,
+		RequiredRoles: []string{"admin"},
+	}, func(ctx context.Context, req feedlib.ActionRequest) (*feedlib.ActionResult, error) {
+		assert.Equal(t, []string{"admin"}, req.Action.RequiredRoles,
+			"handlers see the registered policy")
+		return &feedlib.ActionResult{Message: "done"}, nil
+	}))
+
+	req := getTestActionRequest(feedlib.HandlingInline)
+	req.Action.RequiredRoles = []string{"admin"}
+
+	d, err := feedlib.NewActionDispatcher(r, nil)
+	assert.Nil(t, err)
+	_, err = d.Dispatch(context.Background(), req)
+	var authErr *feedlib.AuthorizationError
+	assert.True(t, errors.As(err, &authErr), "required roles are enforced by default")
+
+	req.Principal.Roles = []string{"admin"}
+	_, err = d.Dispatch(context.Background(), req)
+	assert.Nil(t, err)
+
+	_, err = feedlib.AuthorizationMiddleware(nil)
+	assert.NotNil(t, err, "a nil authorizer must fail at construction")
+
+	deny, err := feedlib.AuthorizationMiddleware(feedlib.NewAuthorizer(feedlib.RolePolicy{}))
+	assert.Nil(t, err)
+	d, err = feedlib.NewActionDispatcher(r, feedlib.NewAuthorizer(), deny)
+	assert.Nil(t, err)
+	req.Principal.Roles = nil
+	_, err = d.Dispatch(context.Background(), req)
+	assert.True(t, errors.As(err, &authErr))
+}
+
+func TestActionDispatcher_SpoofedPolicy(t *testing.T) {
+	handled := false
+	fn := func(ctx context.Context, req feedlib.ActionRequest) (*feedlib.ActionResult, error) {
+		handled = true
+		return &feedlib.ActionResult{Message: "done"}, nil
+	}
+	r := feedlib.NewActionRegistry()
+	assert.Nil(t, r.Register(feedlib.Action{
+		Name:          "Change PIN",
+		Handling:      feedlib.HandlingInline,
+		RequiredRoles: []string{"admin"},
+	}, fn))
+	d, err := feedlib.NewActionDispatcher(r, nil)
+	assert.Nil(t, err)
+
+	// the client drops the required roles from its copy of the action
+	req := getTestActionRequest(feedlib.HandlingInline)
+	req.Action.RequiredRoles = nil
+	_, err = d.Dispatch(context.Background(), req)
+	assert.True(t, errors.Is(err, feedlib.ErrForbidden), "got %v", err)
+
+	// the client claims that the action allows anonymous use
+	req = getTestActionRequest(feedlib.HandlingInline)
+	req.Action.AllowAnonymous = true
+	req.Principal = feedlib.Principal{}
+	_, err = d.Dispatch(context.Background(), req)
+	assert.True(t, errors.Is(err, feedlib.ErrUnauthenticated), "got %v", err)
+	assert.False(t, handled, "spoofed requests should not reach the handler")
+
+	registered, ok := r.Definition("Change PIN")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"admin"}, registered.RequiredRoles)
+	_, ok = r.Definition("Unknown")
+	assert.False(t, ok)
+}
+
+func TestActionDispatcher_EventActor(t *testing.T) {
+	r := feedlib.NewActionRegistry()
+	assert.Nil(t, r.Register(feedlib.Action{
+		Name:           "Change PIN",
+		Handling:       feedlib.HandlingInline,
+		AllowAnonymous: true,
+	}, func(ctx context.Context, req feedlib.ActionRequest) (*feedlib.ActionResult, error) {
+		return &feedlib.ActionResult{Message: "done"}, nil
+	}))
+	d, err := feedlib.NewActionDispatcher(r, nil)
+	assert.Nil(t, err)
+
+	req := getTestActionRequest(feedlib.HandlingInline)
+	req.Principal.UserID = "user-2"
+	_, err = d.Dispatch(context.Background(), req)
+	var authErr *feedlib.AuthorizationError
+	assert.True(t, errors.As(err, &authErr), "got %v", err)
+	assert.True(t, errors.Is(err, feedlib.ErrForbidden))
+
+	req.Principal.UserID = req.Event.Context.UserID
+	_, err = d.Dispatch(context.Background(), req)
+	assert.Nil(t, err)
+
+	// an anonymous caller can't send an event on a named user's behalf, even
+	// for an action that allows anonymous use
+	req.Principal = feedlib.Principal{}
+	_, err = d.Dispatch(context.Background(), req)
+	assert.True(t, errors.As(err, &authErr), "got %v", err)
+	assert.True(t, errors.Is(err, feedlib.ErrForbidden))
+	assert.Equal(t, "actor", authErr.Policy)
+}
@@ -0,0 +1,321 @@
+package feedlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// Command is a lifecycle change that can be applied to a feed item or nudge
+type Command string
+
+// known lifecycle commands
+const (
+	CommandResolve   Command = "RESOLVE"
+	CommandUnresolve Command = "UNRESOLVE"
+	CommandShow      Command = "SHOW"
+	CommandHide      Command = "HIDE"
+	CommandPin       Command = "PIN"
+	CommandUnpin     Command = "UNPIN"
+)
+
+// AllCommand is the set of all known lifecycle commands
+var AllCommand = []Command{
+	CommandResolve,
+	CommandUnresolve,
+	CommandShow,
+	CommandHide,
+	CommandPin,
+	CommandUnpin,
+}
+
+// IsValid returns true only for valid lifecycle commands
+func (e Command) IsValid() bool {
+	switch e {
+	case CommandResolve, CommandUnresolve, CommandShow, CommandHide, CommandPin, CommandUnpin:
+		return true
+	}
+	return false
+}
+
+func (e Command) String() string {
+	return string(e)
+}
+
+// UnmarshalGQL reads and validates a lifecycle command from the supplied input
+func (e *Command) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Command(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Command", str)
+	}
+	return nil
+}
+
+// MarshalGQL writes the lifecycle command to the supplied writer
+func (e Command) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// names of the events that are emitted when lifecycle commands are applied
+const (
+	EventNameItemResolved    = "ITEM_RESOLVED"
+	EventNameItemUnresolved  = "ITEM_UNRESOLVED"
+	EventNameItemShown       = "ITEM_SHOWN"
+	EventNameItemHidden      = "ITEM_HIDDEN"
+	EventNameItemPinned      = "ITEM_PINNED"
+	EventNameItemUnpinned    = "ITEM_UNPINNED"
+	EventNameNudgeResolved   = "NUDGE_RESOLVED"
+	EventNameNudgeUnresolved = "NUDGE_UNRESOLVED"
+	EventNameNudgeShown      = "NUDGE_SHOWN"
+	EventNameNudgeHidden     = "NUDGE_HIDDEN"
+)
+
+// ErrIllegalTransition is returned when a lifecycle command can't be applied
+// to an element in its current state
+var ErrIllegalTransition = errors.New("illegal lifecycle transition")
+
+// LifecycleEngine applies lifecycle commands to feed items and nudges.
+//
+// Every successful command gives the element the next sequence number in its
+// feed and produces an Event that describes the change.
+type LifecycleEngine struct {
+	alloc SequenceAllocator
+	now   func() time.Time
+}
+
+// NewLifecycleEngine initializes a lifecycle engine that takes sequence
+// numbers from the supplied allocator and stamps events using the supplied
+// clock. A nil allocator means DefaultSequenceAllocator and a nil clock means
+// time.Now.
+func NewLifecycleEngine(alloc SequenceAllocator, now func() time.Time) *LifecycleEngine {
+	if alloc == nil {
+		alloc = DefaultSequenceAllocator
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &LifecycleEngine{alloc: alloc, now: now}
+}
+
+// defaultLifecycleEngine backs the lifecycle methods on Item and Nudge
+var defaultLifecycleEngine = NewLifecycleEngine(nil, nil)
+
+// ApplyToItem applies a lifecycle command to a feed item.
+//
+// The item's new sequence number is taken from the feed that is owned by the
+// user with the supplied UID, in the supplied flavour, and ctx bounds its
+// allocation. The event context identifies who is making the change, who need
+// not be the feed's owner e.g an admin; its timestamp is set by the engine.
+// The item is left untouched if the command is illegal or a sequence number
+// can't be allocated.
+func (le *LifecycleEngine) ApplyToItem(
+	ctx context.Context,
+	item *Item,
+	cmd Command,
+	uid string,
+	flavour Flavour,
+	evCtx Context,
+) (*Event, error) {
+	it := *item
+	var name string
+	switch cmd {
+	case CommandResolve:
+		if it.Status == StatusDone {
+			return nil, illegalTransition("item", it.ID, cmd, it.Status)
+		}
+		it.Status, name = StatusDone, EventNameItemResolved
+	case CommandUnresolve:
+		if it.Status != StatusDone {
+			return nil, illegalTransition("item", it.ID, cmd, it.Status)
+		}
+		it.Status, name = StatusPending, EventNameItemUnresolved
+	case CommandShow:
+		if it.Visibility == VisibilityShow {
+			return nil, illegalTransition("item", it.ID, cmd, it.Visibility)
+		}
+		it.Visibility, name = VisibilityShow, EventNameItemShown
+	case CommandHide:
+		if it.Visibility == VisibilityHide {
+			return nil, illegalTransition("item", it.ID, cmd, it.Visibility)
+		}
+		it.Visibility, name = VisibilityHide, EventNameItemHidden
+	case CommandPin:
+		if it.Persistent {
+			return nil, illegalTransition("item", it.ID, cmd, "pinned")
+		}
+		it.Persistent, name = true, EventNameItemPinned
+	case CommandUnpin:
+		if !it.Persistent {
+			return nil, illegalTransition("item", it.ID, cmd, "unpinned")
+		}
+		it.Persistent, name = false, EventNameItemUnpinned
+	default:
+		return nil, fmt.Errorf("%s is not a valid Command", cmd)
+	}
+	err := restampItem(ctx, le.alloc, uid, flavour, &it)
+	if err != nil {
+		return nil, err
+	}
+	*item = it
+
+	return le.newEvent(name, evCtx, map[string]interface{}{
+		"itemID":         it.ID,
+		"command":        cmd.String(),
+		"sequenceNumber": it.SequenceNumber,
+		"status":         it.Status.String(),
+		"visibility":     it.Visibility.String(),
+		"persistent":     it.Persistent,
+	}), nil
+}
+
+// ApplyToNudge applies a lifecycle command to a nudge.
+//
+// Nudges can't be pinned so only the resolve and visibility commands apply.
+// The contexts and feed owner are used as they are by ApplyToItem. The nudge
+// is left untouched if the command is illegal or a sequence number can't be
+// allocated.
+func (le *LifecycleEngine) ApplyToNudge(
+	ctx context.Context,
+	nudge *Nudge,
+	cmd Command,
+	uid string,
+	flavour Flavour,
+	evCtx Context,
+) (*Event, error) {
+	nu := *nudge
+	var name string
+	switch cmd {
+	case CommandResolve:
+		if nu.Status == StatusDone {
+			return nil, illegalTransition("nudge", nu.ID, cmd, nu.Status)
+		}
+		nu.Status, name = StatusDone, EventNameNudgeResolved
+	case CommandUnresolve:
+		if nu.Status != StatusDone {
+			return nil, illegalTransition("nudge", nu.ID, cmd, nu.Status)
+		}
+		nu.Status, name = StatusPending, EventNameNudgeUnresolved
+	case CommandShow:
+		if nu.Visibility == VisibilityShow {
+			return nil, illegalTransition("nudge", nu.ID, cmd, nu.Visibility)
+		}
+		nu.Visibility, name = VisibilityShow, EventNameNudgeShown
+	case CommandHide:
+		if nu.Visibility == VisibilityHide {
+			return nil, illegalTransition("nudge", nu.ID, cmd, nu.Visibility)
+		}
+		nu.Visibility, name = VisibilityHide, EventNameNudgeHidden
+	case CommandPin, CommandUnpin:
+		return nil, fmt.Errorf(
+			"nudge %s: nudges can't be pinned: %w", nu.ID, ErrIllegalTransition)
+	default:
+		return nil, fmt.Errorf("%s is not a valid Command", cmd)
+	}
+	err := restampNudge(ctx, le.alloc, uid, flavour, &nu)
+	if err != nil {
+		return nil, err
+	}
+	*nudge = nu
+
+	return le.newEvent(name, evCtx, map[string]interface{}{
+		"nudgeID":        nu.ID,
+		"command":        cmd.String(),
+		"sequenceNumber": nu.SequenceNumber,
+		"status":         nu.Status.String(),
+		"visibility":     nu.Visibility.String(),
+	}), nil
+}
+
+func (le *LifecycleEngine) newEvent(name string, ctx Context, data map[string]interface{}) *Event {
+	ctx.Timestamp = le.now()
+	return newEvent(name, ctx, data)
+}
+
+// newEvent creates an event with a new ID and the supplied payload data
+func newEvent(name string, ctx Context, data map[string]interface{}) *Event {
+	return &Event{
+		ID:      ksuid.New().String(),
+		Name:    name,
+		Context: ctx,
+		Payload: Payload{
+			Data: data,
+		},
+	}
+}
+
+func illegalTransition(element string, id string, cmd Command, from interface{}) error {
+	return fmt.Errorf(
+		"%s %s: can't %s when %s: %w",
+		element, id, cmd, from, ErrIllegalTransition,
+	)
+}
+
+// Resolve marks the item as done
+func (it *Item) Resolve(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToItem(ctx, it, CommandResolve, uid, flavour, evCtx)
+}
+
+// Unresolve marks a done item as pending again
+func (it *Item) Unresolve(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToItem(ctx, it, CommandUnresolve, uid, flavour, evCtx)
+}
+
+// Show makes a hidden item visible
+func (it *Item) Show(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToItem(ctx, it, CommandShow, uid, flavour, evCtx)
+}
+
+// Hide hides a visible item
+func (it *Item) Hide(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToItem(ctx, it, CommandHide, uid, flavour, evCtx)
+}
+
+// Pin makes the item persistent
+func (it *Item) Pin(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToItem(ctx, it, CommandPin, uid, flavour, evCtx)
+}
+
+// Unpin makes a pinned item non-persistent
+func (it *Item) Unpin(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToItem(ctx, it, CommandUnpin, uid, flavour, evCtx)
+}
+
+// Resolve marks the nudge as done
+func (nu *Nudge) Resolve(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToNudge(ctx, nu, CommandResolve, uid, flavour, evCtx)
+}
+
+// Unresolve marks a done nudge as pending again
+func (nu *Nudge) Unresolve(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToNudge(ctx, nu, CommandUnresolve, uid, flavour, evCtx)
+}
+
+// Show makes a hidden nudge visible
+func (nu *Nudge) Show(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToNudge(ctx, nu, CommandShow, uid, flavour, evCtx)
+}
+
+// Hide hides a visible nudge
+func (nu *Nudge) Hide(
+	ctx context.Context, uid string, flavour Flavour, evCtx Context) (*Event, error) {
+	return defaultLifecycleEngine.ApplyToNudge(ctx, nu, CommandHide, uid, flavour, evCtx)
+}
@@ -0,0 +1,441 @@
+package feedlib_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestContext() feedlib.Context {
+	return feedlib.Context{
+		UserID:         "user-1",
+		Flavour:        feedlib.FlavourConsumer,
+		OrganizationID: "org-1",
+		LocationID:     "loc-1",
+	}
+}
+
+func TestCommand_IsValid(t *testing.T) {
+	for _, cmd := range feedlib.AllCommand {
+		assert.True(t, cmd.IsValid(), "%s should be valid", cmd)
+	}
+	assert.False(t, feedlib.Command("bogus").IsValid())
+}
+
+func TestCommand_UnmarshalGQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       interface{}
+		wantErr bool
+	}{
+		{
+			name:    "valid command",
+			v:       "RESOLVE",
+			wantErr: false,
+		},
+		{
+			name:    "invalid command",
+			v:       "bogus",
+			wantErr: true,
+		},
+		{
+			name:    "not a string",
+			v:       1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := feedlib.Command("")
+			if err := cmd.UnmarshalGQL(tt.v); (err != nil) != tt.wantErr {
+				t.Errorf("Command.UnmarshalGQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommand_MarshalGQL(t *testing.T) {
+	w := &bytes.Buffer{}
+	feedlib.CommandPin.MarshalGQL(w)
+	assert.Equal(t, strconv.Quote("PIN"), w.String())
+}
+
+func TestLifecycleEngine_ApplyToItem(t *testing.T) {
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+
+	done := feedtest.Item("item-1", 1)
+	done.Status = feedlib.StatusDone
+	hidden := feedtest.Item("item-1", 1)
+	hidden.Visibility = feedlib.VisibilityHide
+	unpinned := feedtest.Item("item-1", 1)
+	unpinned.Persistent = false
+
+	tests := []struct {
+		name      string
+		it        feedlib.Item
+		cmd       feedlib.Command
+		wantEvent string
+		wantErr   bool
+		check     func(t *testing.T, it feedlib.Item)
+	}{
+		{
+			name:      "resolve a pending item",
+			it:        feedtest.Item("item-1", 1),
+			cmd:       feedlib.CommandResolve,
+			wantEvent: feedlib.EventNameItemResolved,
+			check: func(t *testing.T, it feedlib.Item) {
+				assert.Equal(t, feedlib.StatusDone, it.Status)
+			},
+		},
+		{
+			name:    "resolve a done item",
+			it:      done,
+			cmd:     feedlib.CommandResolve,
+			wantErr: true,
+		},
+		{
+			name:      "unresolve a done item",
+			it:        done,
+			cmd:       feedlib.CommandUnresolve,
+			wantEvent: feedlib.EventNameItemUnresolved,
+			check: func(t *testing.T, it feedlib.Item) {
+				assert.Equal(t, feedlib.StatusPending, it.Status)
+			},
+		},
+		{
+			name:    "unresolve a pending item",
+			it:      feedtest.Item("item-1", 1),
+			cmd:     feedlib.CommandUnresolve,
+			wantErr: true,
+		},
+		{
+			name:      "show a hidden item",
+			it:        hidden,
+			cmd:       feedlib.CommandShow,
+			wantEvent: feedlib.EventNameItemShown,
+			check: func(t *testing.T, it feedlib.Item) {
+				assert.Equal(t, feedlib.VisibilityShow, it.Visibility)
+			},
+		},
+		{
+			name:    "show a visible item",
+			it:      feedtest.Item("item-1", 1),
+			cmd:     feedlib.CommandShow,
+			wantErr: true,
+		},
+		{
+			name:      "hide a visible item",
+			it:        feedtest.Item("item-1", 1),
+			cmd:       feedlib.CommandHide,
+			wantEvent: feedlib.EventNameItemHidden,
+			check: func(t *testing.T, it feedlib.Item) {
+				assert.Equal(t, feedlib.VisibilityHide, it.Visibility)
+			},
+		},
+		{
+			name:    "hide a hidden item",
+			it:      hidden,
+			cmd:     feedlib.CommandHide,
+			wantErr: true,
+		},
+		{
+			name:      "pin an unpinned item",
+			it:        unpinned,
+			cmd:       feedlib.CommandPin,
+			wantEvent: feedlib.EventNameItemPinned,
+			check: func(t *testing.T, it feedlib.Item) {
+				assert.True(t, it.Persistent)
+			},
+		},
+		{
+			name:    "pin a pinned item",
+			it:      feedtest.Item("item-1", 1),
+			cmd:     feedlib.CommandPin,
+			wantErr: true,
+		},
+		{
+			name:      "unpin a pinned item",
+			it:        feedtest.Item("item-1", 1),
+			cmd:       feedlib.CommandUnpin,
+			wantEvent: feedlib.EventNameItemUnpinned,
+			check: func(t *testing.T, it feedlib.Item) {
+				assert.False(t, it.Persistent)
+			},
+		},
+		{
+			name:    "unpin an unpinned item",
+			it:      unpinned,
+			cmd:     feedlib.CommandUnpin,
+			wantErr: true,
+		},
+		{
+			name:    "unknown command",
+			it:      feedtest.Item("item-1", 1),
+			cmd:     feedlib.Command("bogus"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			le := feedlib.NewLifecycleEngine(
+				feedlib.NewMemorySequenceAllocator(), func() time.Time { return now })
+			it := tt.it
+			before := tt.it
+			ev, err := le.ApplyToItem(context.Background(), &it, tt.cmd, "user-1", feedlib.FlavourConsumer, getTestContext())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LifecycleEngine.ApplyToItem() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				assert.Nil(t, ev)
+				assert.Equal(t, before, it, "an illegal command should not change the item")
+				return
+			}
+			tt.check(t, it)
+			assert.Equal(t, before.SequenceNumber+1, it.SequenceNumber)
+
+			assert.Equal(t, tt.wantEvent, ev.Name)
+			assert.Equal(t, now, ev.Context.Timestamp)
+			assert.Equal(t, "user-1", ev.Context.UserID)
+			assert.Equal(t, "item-1", ev.Payload.Data["itemID"])
+			assert.Equal(t, tt.cmd.String(), ev.Payload.Data["command"])
+			assert.Equal(t, it.SequenceNumber, ev.Payload.Data["sequenceNumber"])
+
+			_, err = ev.ValidateAndMarshal()
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestLifecycleEngine_ApplyToItem_IllegalTransition(t *testing.T) {
+	it := feedtest.Item("item-1", 1)
+	_, err := it.Pin(context.Background(), "user-1", feedlib.FlavourConsumer, getTestContext())
+	assert.True(t, errors.Is(err, feedlib.ErrIllegalTransition))
+}
+
+func TestLifecycleEngine_ApplyToNudge(t *testing.T) {
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+
+	done := feedtest.Nudge("nudge-1", 1)
+	done.Status = feedlib.StatusDone
+	hidden := feedtest.Nudge("nudge-1", 1)
+	hidden.Visibility = feedlib.VisibilityHide
+
+	tests := []struct {
+		name      string
+		nu        feedlib.Nudge
+		cmd       feedlib.Command
+		wantEvent string
+		wantErr   bool
+	}{
+		{
+			name:      "resolve a pending nudge",
+			nu:        feedtest.Nudge("nudge-1", 1),
+			cmd:       feedlib.CommandResolve,
+			wantEvent: feedlib.EventNameNudgeResolved,
+		},
+		{
+			name:    "resolve a done nudge",
+			nu:      done,
+			cmd:     feedlib.CommandResolve,
+			wantErr: true,
+		},
+		{
+			name:      "unresolve a done nudge",
+			nu:        done,
+			cmd:       feedlib.CommandUnresolve,
+			wantEvent: feedlib.EventNameNudgeUnresolved,
+		},
+		{
+			name:    "unresolve a pending nudge",
+			nu:      feedtest.Nudge("nudge-1", 1),
+			cmd:     feedlib.CommandUnresolve,
+			wantErr: true,
+		},
+		{
+			name:      "show a hidden nudge",
+			nu:        hidden,
+			cmd:       feedlib.CommandShow,
+			wantEvent: feedlib.EventNameNudgeShown,
+		},
+		{
+			name:    "show a visible nudge",
+			nu:      feedtest.Nudge("nudge-1", 1),
+			cmd:     feedlib.CommandShow,
+			wantErr: true,
+		},
+		{
+			name:      "hide a visible nudge",
+			nu:        feedtest.Nudge("nudge-1", 1),
+			cmd:       feedlib.CommandHide,
+			wantEvent: feedlib.EventNameNudgeHidden,
+		},
+		{
+			name:    "hide a hidden nudge",
+			nu:      hidden,
+			cmd:     feedlib.CommandHide,
+			wantErr: true,
+		},
+		{
+			name:    "nudges can't be pinned",
+			nu:      feedtest.Nudge("nudge-1", 1),
+			cmd:     feedlib.CommandPin,
+			wantErr: true,
+		},
+		{
+			name:    "nudges can't be unpinned",
+			nu:      feedtest.Nudge("nudge-1", 1),
+			cmd:     feedlib.CommandUnpin,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			le := feedlib.NewLifecycleEngine(
+				feedlib.NewMemorySequenceAllocator(), func() time.Time { return now })
+			nu := tt.nu
+			before := tt.nu
+			ev, err := le.ApplyToNudge(context.Background(), &nu, tt.cmd, "user-1", feedlib.FlavourConsumer, getTestContext())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LifecycleEngine.ApplyToNudge() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, feedlib.ErrIllegalTransition))
+				assert.Equal(t, before, nu, "an illegal command should not change the nudge")
+				return
+			}
+			assert.Equal(t, before.SequenceNumber+1, nu.SequenceNumber)
+			assert.Equal(t, tt.wantEvent, ev.Name)
+			assert.Equal(t, now, ev.Context.Timestamp)
+			assert.Equal(t, "nudge-1", ev.Payload.Data["nudgeID"])
+
+			_, err = ev.ValidateAndMarshal()
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestItem_LifecycleMethods(t *testing.T) {
+	it := feedtest.Item("item-1", 1)
+	evCtx := getTestContext()
+
+	steps := []func(context.Context, string, feedlib.Flavour, feedlib.Context) (*feedlib.Event, error){
+		it.Resolve,
+		it.Unresolve,
+		it.Hide,
+		it.Show,
+		it.Unpin,
+		it.Pin,
+	}
+	for _, step := range steps {
+		before := it.SequenceNumber
+		ev, err := step(context.Background(), "user-1", feedlib.FlavourConsumer, evCtx)
+		assert.Nil(t, err)
+		assert.NotNil(t, ev)
+		assert.False(t, ev.Context.Timestamp.IsZero())
+		assert.Greater(t, it.SequenceNumber, before)
+	}
+}
+
+func TestNudge_LifecycleMethods(t *testing.T) {
+	nu := feedtest.Nudge("nudge-1", 1)
+	evCtx := getTestContext()
+
+	steps := []func(context.Context, string, feedlib.Flavour, feedlib.Context) (*feedlib.Event, error){
+		nu.Resolve,
+		nu.Unresolve,
+		nu.Hide,
+		nu.Show,
+	}
+	for _, step := range steps {
+		before := nu.SequenceNumber
+		ev, err := step(context.Background(), "user-1", feedlib.FlavourConsumer, evCtx)
+		assert.Nil(t, err)
+		assert.NotNil(t, ev)
+		assert.Greater(t, nu.SequenceNumber, before)
+	}
+}
+
+func TestLifecycleEngine_Sequence(t *testing.T) {
+	ctx := context.Background()
+	alloc := feedlib.NewMemorySequenceAllocator()
+	le := feedlib.NewLifecycleEngine(alloc, nil)
+
+	fe := feedlib.Feed{
+		UID:     "user-1",
+		Flavour: feedlib.FlavourConsumer,
+		Nudges:  []feedlib.Nudge{feedtest.Nudge("nudge-1", 4)},
+		Items: []feedlib.Item{
+			feedtest.Item("item-1", 1),
+			feedtest.Item("item-2", 2),
+			feedtest.Item("item-3", 3),
+		},
+	}
+	fe.Items[0].Conversations = nil
+	fe.Items[1].Conversations = nil
+	fe.Items[2].Conversations = nil
+	assert.Nil(t, feedlib.ObserveFeed(ctx, alloc, &fe))
+
+	_, err := le.ApplyToItem(ctx, &fe.Items[0], feedlib.CommandResolve, "user-1", feedlib.FlavourConsumer, getTestContext())
+	assert.Nil(t, err)
+	assert.Equal(t, 5, fe.Items[0].SequenceNumber, "the next number in the feed should be used")
+	_, err = le.ApplyToNudge(ctx, &fe.Nudges[0], feedlib.CommandHide, "user-1", feedlib.FlavourConsumer, getTestContext())
+	assert.Nil(t, err)
+	assert.Equal(t, 6, fe.Nudges[0].SequenceNumber)
+
+	// a repository lists the changed item last
+	fe.Items = append(fe.Items[1:], fe.Items[0])
+	assert.Nil(t, fe.VerifySequence())
+
+	// the element is left untouched if a number can't be allocated
+	it := feedtest.Item("item-4", 7)
+	before := it
+	_, err = le.ApplyToItem(ctx, &it, feedlib.CommandResolve, "", "", feedlib.Context{})
+	assert.NotNil(t, err)
+	assert.Equal(t, before, it)
+
+	// and when the caller gives up on the allocation
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = le.ApplyToItem(cancelled, &it, feedlib.CommandResolve, "user-1", feedlib.FlavourConsumer, getTestContext())
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	assert.Equal(t, before, it)
+	nu := feedtest.Nudge("nudge-2", 8)
+	_, err = le.ApplyToNudge(cancelled, &nu, feedlib.CommandHide, "user-1", feedlib.FlavourConsumer, getTestContext())
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	assert.Equal(t, 8, nu.SequenceNumber)
+}
+
+func TestLifecycleEngine_FeedOwner(t *testing.T) {
+	ctx := context.Background()
+	alloc := feedlib.NewMemorySequenceAllocator()
+	le := feedlib.NewLifecycleEngine(alloc, nil)
+	assert.Nil(t, alloc.Observe(ctx, "user-1", feedlib.FlavourConsumer, 10))
+
+	// an admin changes an element in someone else's feed
+	admin := getTestContext()
+	admin.UserID = "admin-1"
+
+	it := feedtest.Item("item-1", 1)
+	ev, err := le.ApplyToItem(ctx, &it, feedlib.CommandResolve, "user-1", feedlib.FlavourConsumer, admin)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, it.SequenceNumber, "the number should come from the owner's feed")
+	assert.Equal(t, "admin-1", ev.Context.UserID, "the event should name the actor")
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	_, err = le.ApplyToNudge(ctx, &nu, feedlib.CommandHide, "user-1", feedlib.FlavourConsumer, admin)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, nu.SequenceNumber)
+
+	next, err := alloc.Next(ctx, "admin-1", feedlib.FlavourConsumer)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, next, "the actor's own feed should not be touched")
+}
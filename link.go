@@ -0,0 +1,204 @@
+package feedlib
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// youtubeHosts are the hosts that serve YouTube videos. Subdomains of
+// youtube.com e.g www and m are also accepted.
+var youtubeHosts = map[string]bool{
+	"youtube.com":          true,
+	"youtu.be":             true,
+	"youtube-nocookie.com": true,
+}
+
+// linkTypeExtensions maps link types that are identified by the extension
+// of their URL's path to the extensions that they accept
+var linkTypeExtensions = map[LinkType][]string{
+	LinkTypePngImage:    {".png"},
+	LinkTypePdfDocument: {".pdf"},
+	LinkTypeSvgImage:    {".svg"},
+	LinkTypeMp4:         {".mp4"},
+	LinkTypeAudio:       {".mp3", ".aac"},
+	LinkTypeJpegImage:   {".jpg", ".jpeg"},
+	LinkTypeWebpImage:   {".webp"},
+}
+
+// webPageExtensions are the path extensions that a web page link may have,
+// in addition to having no extension at all
+var webPageExtensions = []string{".html", ".htm"}
+
+// deepLinkSchemePattern matches the custom URL schemes that apps register
+// e.g bewell. See RFC 3986 section 3.1.
+var deepLinkSchemePattern = regexp.MustCompile(`^[a-z][a-z0-9+.\-]*$`)
+
+// reservedSchemes are schemes that can't be used for deep links, either
+// because they are not app routes e.g they are handled by the phone's mail,
+// dialer or messaging apps, or because they are unsafe to open
+var reservedSchemes = map[string]bool{
+	"http":       true,
+	"https":      true,
+	"ftp":        true,
+	"file":       true,
+	"data":       true,
+	"javascript": true,
+	"vbscript":   true,
+	"blob":       true,
+	"about":      true,
+	"mailto":     true,
+	"tel":        true,
+	"sms":        true,
+}
+
+// parseLinkURL parses an absolute http(s) URL
+func parseLinkURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid URL: %w", rawURL, err)
+	}
+	if !isWebScheme(u) {
+		return nil, fmt.Errorf("%s is not a valid URL: the scheme must be http or https", rawURL)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%s is not a valid URL: a host is required", rawURL)
+	}
+	return u, nil
+}
+
+// parseDeepLink parses an in-app deep link e.g bewell://profile/pin. Deep
+// links use the app's own scheme and must name a route.
+func parseDeepLink(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid deep link: %w", rawURL, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if !deepLinkSchemePattern.MatchString(scheme) || reservedSchemes[scheme] {
+		return nil, fmt.Errorf(
+			"%s is not a valid deep link: it must use the app's own scheme", rawURL)
+	}
+	if u.Host == "" && strings.Trim(u.Path, "/") == "" && u.Opaque == "" {
+		return nil, fmt.Errorf("%s is not a valid deep link: a route is required", rawURL)
+	}
+	return u, nil
+}
+
+// isWebScheme returns true for http and https URLs
+func isWebScheme(u *url.URL) bool {
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "http" || scheme == "https"
+}
+
+// isYoutubeHost returns true if the URL is served by YouTube. The query
+// string is not considered, so e.g https://example.com/?v=youtube.com is not
+// a YouTube URL.
+func isYoutubeHost(u *url.URL) bool {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if youtubeHosts[host] {
+		return true
+	}
+	return strings.HasSuffix(host, ".youtube.com")
+}
+
+// pathExtension returns the lower case extension of the URL's path e.g .png
+func pathExtension(u *url.URL) string {
+	return strings.ToLower(path.Ext(u.Path))
+}
+
+// hasExtension returns true if the extension is one of the candidates
+func hasExtension(ext string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if ext == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// isWebPage returns true if the URL looks like a page rather than a media
+// file or document
+func isWebPage(u *url.URL) bool {
+	if isYoutubeHost(u) {
+		return false
+	}
+	ext := pathExtension(u)
+	return ext == "" || hasExtension(ext, webPageExtensions)
+}
+
+// InferLinkType works out the type of a link from its URL:
+//
+//   - YouTube URLs are YOUTUBE_VIDEO links
+//   - the extension of the URL's path identifies PNG, PDF, SVG, MP4, audio,
+//     JPEG and WebP links
+//   - http(s) URLs with no extension or an HTML extension are WEB_PAGE links
+//   - URLs with an app's own scheme e.g bewell://profile are DEEP_LINK links
+//
+// Query strings and fragments are ignored. Any other http(s) URL is a
+// DEFAULT link.
+func InferLinkType(rawURL string) (LinkType, error) {
+	u, err := url.Parse(rawURL)
+	if err == nil && u.Scheme != "" && !isWebScheme(u) {
+		if _, err := parseDeepLink(rawURL); err != nil {
+			return "", err
+		}
+		return LinkTypeDeepLink, nil
+	}
+
+	u, err = parseLinkURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if isYoutubeHost(u) {
+		return LinkTypeYoutubeVideo, nil
+	}
+	ext := pathExtension(u)
+	for _, lt := range AllLinkType {
+		if hasExtension(ext, linkTypeExtensions[lt]) {
+			return lt, nil
+		}
+	}
+	if isWebPage(u) {
+		return LinkTypeWebPage, nil
+	}
+	return LinkTypeDefault, nil
+}
+
+// checkLinkURL checks that a URL is suitable for a link of the given type.
+// The returned error describes the problem.
+func checkLinkURL(rawURL string, linkType LinkType) error {
+	if linkType == LinkTypeDeepLink {
+		_, err := parseDeepLink(rawURL)
+		return err
+	}
+
+	u, err := parseLinkURL(rawURL)
+	if err != nil {
+		return err
+	}
+	switch linkType {
+	case LinkTypeYoutubeVideo:
+		if !isYoutubeHost(u) {
+			return fmt.Errorf("%s is not a YouTube URL", rawURL)
+		}
+	case LinkTypeWebPage:
+		if !isWebPage(u) {
+			return fmt.Errorf("%s is not a web page URL", rawURL)
+		}
+	case LinkTypeDefault:
+		// any http(s) URL will do
+	default:
+		want, ok := linkTypeExtensions[linkType]
+		if !ok {
+			return fmt.Errorf("%s is not a valid LinkType", linkType)
+		}
+		if !hasExtension(pathExtension(u), want) {
+			return fmt.Errorf(
+				"the path of %s does not end with %s", rawURL, strings.Join(want, " or "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package feedlib_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferLinkType(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    feedlib.LinkType
+		wantErr bool
+	}{
+		{url: feedlib.SampleVideoURL, want: feedlib.LinkTypeYoutubeVideo},
+		{url: "https://youtu.be/bPiofmZGb8o", want: feedlib.LinkTypeYoutubeVideo},
+		{url: "https://m.youtube.com/watch?v=bPiofmZGb8o", want: feedlib.LinkTypeYoutubeVideo},
+		{url: "https://youtube.com/embed/bPiofmZGb8o", want: feedlib.LinkTypeYoutubeVideo},
+		{url: "https://evil.example/?x=youtube.com", want: feedlib.LinkTypeWebPage},
+		{url: "https://youtube.com.evil.example/watch", want: feedlib.LinkTypeWebPage},
+		{url: feedlib.LogoURL, want: feedlib.LinkTypePngImage},
+		{url: "https://example.com/IMAGE.PNG?size=large", want: feedlib.LinkTypePngImage},
+		{url: "https://example.com/docs/leaflet.pdf#page=2", want: feedlib.LinkTypePdfDocument},
+		{url: "https://example.com/a.svg", want: feedlib.LinkTypeSvgImage},
+		{url: "http://example.com/clip.mp4", want: feedlib.LinkTypeMp4},
+		{url: "https://example.com/page?file=a.png", want: feedlib.LinkTypeWebPage},
+		{url: "https://example.com/", want: feedlib.LinkTypeWebPage},
+		{url: "https://example.com/articles/diabetes.html", want: feedlib.LinkTypeWebPage},
+		{url: "https://example.com/lessons/1.mp3", want: feedlib.LinkTypeAudio},
+		{url: "https://example.com/lessons/1.aac", want: feedlib.LinkTypeAudio},
+		{url: "https://example.com/photo.JPG", want: feedlib.LinkTypeJpegImage},
+		{url: "https://example.com/photo.jpeg", want: feedlib.LinkTypeJpegImage},
+		{url: "https://example.com/photo.webp", want: feedlib.LinkTypeWebpImage},
+		{url: "https://example.com/report.docx", want: feedlib.LinkTypeDefault},
+		{url: "bewell://profile/pin", want: feedlib.LinkTypeDeepLink},
+		{url: "bewell:///library", want: feedlib.LinkTypeDeepLink},
+		{url: "bewell://", wantErr: true},
+		{url: "javascript:alert(1)", wantErr: true},
+		{url: "mailto:help@example.com", wantErr: true},
+		{url: "MAILTO:help@example.com", wantErr: true},
+		{url: "tel:+254700000000", wantErr: true},
+		{url: "sms:+254700000000?body=hello", wantErr: true},
+		{url: "www.example.com/a.png", wantErr: true},
+		{url: "ftp://example.com/a.png", wantErr: true},
+		{url: "https:///a.png", wantErr: true},
+		{url: "not a valid URL", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := feedlib.InferLinkType(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InferLinkType() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLink_ValidateLinkType(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		linkType feedlib.LinkType
+		wantErr  bool
+	}{
+		{
+			name:     "PDF document",
+			url:      "https://example.com/leaflet.pdf",
+			linkType: feedlib.LinkTypePdfDocument,
+		},
+		{
+			name:     "PNG URL for a PDF document",
+			url:      feedlib.LogoURL,
+			linkType: feedlib.LinkTypePdfDocument,
+			wantErr:  true,
+		},
+		{
+			name:     "YouTube video",
+			url:      "https://youtu.be/bPiofmZGb8o",
+			linkType: feedlib.LinkTypeYoutubeVideo,
+		},
+		{
+			name:     "YouTube in the query string",
+			url:      "https://evil.example/?x=youtube.com",
+			linkType: feedlib.LinkTypeYoutubeVideo,
+			wantErr:  true,
+		},
+		{
+			name:     "PNG extension in the query string",
+			url:      "https://example.com/image?format=.png",
+			linkType: feedlib.LinkTypePngImage,
+			wantErr:  true,
+		},
+		{
+			name:     "SVG image",
+			url:      "https://example.com/a.svg?v=2",
+			linkType: feedlib.LinkTypeSvgImage,
+		},
+		{
+			name:     "PNG URL for an SVG image",
+			url:      feedlib.LogoURL,
+			linkType: feedlib.LinkTypeSvgImage,
+			wantErr:  true,
+		},
+		{
+			name:     "MP4 video",
+			url:      "https://example.com/clip.MP4",
+			linkType: feedlib.LinkTypeMp4,
+		},
+		{
+			name:     "default link",
+			url:      "https://example.com/anything",
+			linkType: feedlib.LinkTypeDefault,
+		},
+		{
+			name:     "audio lesson",
+			url:      "https://example.com/lessons/1.mp3?token=abc",
+			linkType: feedlib.LinkTypeAudio,
+		},
+		{
+			name:     "video for an audio link",
+			url:      "https://example.com/lessons/1.mp4",
+			linkType: feedlib.LinkTypeAudio,
+			wantErr:  true,
+		},
+		{
+			name:     "JPEG image",
+			url:      "https://example.com/photo.jpg",
+			linkType: feedlib.LinkTypeJpegImage,
+		},
+		{
+			name:     "PNG URL for a JPEG image",
+			url:      feedlib.LogoURL,
+			linkType: feedlib.LinkTypeJpegImage,
+			wantErr:  true,
+		},
+		{
+			name:     "WebP image",
+			url:      "https://example.com/photo.webp",
+			linkType: feedlib.LinkTypeWebpImage,
+		},
+		{
+			name:     "web page",
+			url:      "https://example.com/articles/diabetes",
+			linkType: feedlib.LinkTypeWebPage,
+		},
+		{
+			name:     "PDF URL for a web page",
+			url:      "https://example.com/leaflet.pdf",
+			linkType: feedlib.LinkTypeWebPage,
+			wantErr:  true,
+		},
+		{
+			name:     "YouTube URL for a web page",
+			url:      feedlib.SampleVideoURL,
+			linkType: feedlib.LinkTypeWebPage,
+			wantErr:  true,
+		},
+		{
+			name:     "deep link",
+			url:      "bewell://profile/pin?step=2",
+			linkType: feedlib.LinkTypeDeepLink,
+		},
+		{
+			name:     "web URL for a deep link",
+			url:      "https://example.com/profile",
+			linkType: feedlib.LinkTypeDeepLink,
+			wantErr:  true,
+		},
+		{
+			name:     "javascript deep link",
+			url:      "javascript:alert(1)",
+			linkType: feedlib.LinkTypeDeepLink,
+			wantErr:  true,
+		},
+		{
+			name:     "mailto deep link",
+			url:      "mailto:help@example.com",
+			linkType: feedlib.LinkTypeDeepLink,
+			wantErr:  true,
+		},
+		{
+			name:     "tel deep link",
+			url:      "tel:+254700000000",
+			linkType: feedlib.LinkTypeDeepLink,
+			wantErr:  true,
+		},
+		{
+			name:     "sms deep link",
+			url:      "sms:+254700000000",
+			linkType: feedlib.LinkTypeDeepLink,
+			wantErr:  true,
+		},
+		{
+			name:     "default link without a scheme",
+			url:      "example.com/anything",
+			linkType: feedlib.LinkTypeDefault,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := feedlib.Link{
+				ID:          "link-1",
+				URL:         tt.url,
+				LinkType:    tt.linkType,
+				Title:       "title",
+				Description: "description",
+				Thumbnail:   feedlib.BlankImageURL,
+			}
+			_, err := l.ValidateAndMarshal()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Link.ValidateAndMarshal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				var vErr *feedlib.ValidationError
+				assert.True(t, errors.As(err, &vErr))
+				assert.Equal(t, feedlib.RuleLinkType, vErr.Violations[0].Rule)
+			}
+		})
+	}
+}
+
+func TestGetLinkConstructors(t *testing.T) {
+	tests := []struct {
+		link feedlib.Link
+		want feedlib.LinkType
+	}{
+		{
+			link: feedlib.GetAudioLink(
+				"https://example.com/lesson.mp3", "title", "description", feedlib.BlankImageURL),
+			want: feedlib.LinkTypeAudio,
+		},
+		{
+			link: feedlib.GetJPEGImageLink(
+				"https://example.com/photo.jpg", "title", "description", feedlib.BlankImageURL),
+			want: feedlib.LinkTypeJpegImage,
+		},
+		{
+			link: feedlib.GetWebPImageLink(
+				"https://example.com/photo.webp", "title", "description", feedlib.BlankImageURL),
+			want: feedlib.LinkTypeWebpImage,
+		},
+		{
+			link: feedlib.GetWebPageLink(
+				"https://example.com/articles/diabetes", "title", "description", feedlib.BlankImageURL),
+			want: feedlib.LinkTypeWebPage,
+		},
+		{
+			link: feedlib.GetDeepLink(
+				"bewell://profile/pin", "title", "description", feedlib.BlankImageURL),
+			want: feedlib.LinkTypeDeepLink,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want.String(), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.link.LinkType)
+			assert.NotEmpty(t, tt.link.ID)
+			_, err := tt.link.ValidateAndMarshal()
+			assert.Nil(t, err)
+
+			inferred, err := feedlib.InferLinkType(tt.link.URL)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, inferred)
+		})
+	}
+}
+
+func TestLinkType_AllLinkType(t *testing.T) {
+	for _, lt := range feedlib.AllLinkType {
+		assert.True(t, lt.IsValid(), "%s should be valid", lt)
+
+		var got feedlib.LinkType
+		assert.Nil(t, got.UnmarshalGQL(lt.String()))
+		assert.Equal(t, lt, got)
+	}
+}
@@ -0,0 +1,218 @@
+package feedlib
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// well known locales, as BCP 47 language tags
+const (
+	LocaleEnglish   = "en"
+	LocaleKiswahili = "sw"
+
+	// DefaultLocale is the locale that is used when none of a user's
+	// preferred locales are available. Localised fields must always have
+	// it; the schemas enforce this.
+	DefaultLocale = LocaleEnglish
+)
+
+// LocalisedText holds translations of a piece of text, keyed by language
+// tag e.g en or sw-KE
+type LocalisedText map[string]string
+
+// LocalisedNotificationBody holds translations of a notification body,
+// keyed by language tag e.g en or sw-KE
+type LocalisedNotificationBody map[string]NotificationBody
+
+// ParseAcceptLanguage returns the language tags in an Accept-Language header
+// e.g "sw-KE, sw;q=0.9, en;q=0.5", most preferred first. Tags with a
+// quality of zero, wildcards and malformed entries are left out.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	candidates := []weighted{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		valid := true
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			quality = q
+		}
+		if valid && quality > 0 {
+			candidates = append(candidates, weighted{tag: tag, quality: quality})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	tags := []string{}
+	for _, c := range candidates {
+		tags = append(tags, c.tag)
+	}
+	return tags
+}
+
+// baseLanguage returns the language of a tag without its region etc
+// e.g sw for sw-KE
+func baseLanguage(tag string) string {
+	return strings.SplitN(strings.ToLower(tag), "-", 2)[0]
+}
+
+// MatchLocale picks the available locale that best matches the preferred
+// locales, which should be most preferred first. For each preferred locale
+// in turn it looks for an exact match, then for the locale's base language
+// (sw for sw-KE) and then for any variant of that language (en-GB for en).
+// Tags are compared case-insensitively.
+//
+// When nothing matches, the DefaultLocale is returned if it is available.
+// The second return value is false if no locale could be picked.
+func MatchLocale(preferred []string, available []string) (string, bool) {
+	sorted := append([]string{}, available...)
+	sort.Strings(sorted)
+
+	for _, want := range preferred {
+		for _, got := range sorted {
+			if strings.EqualFold(want, got) {
+				return got, true
+			}
+		}
+		base := baseLanguage(want)
+		for _, got := range sorted {
+			if strings.EqualFold(base, got) {
+				return got, true
+			}
+		}
+		for _, got := range sorted {
+			if baseLanguage(got) == base {
+				return got, true
+			}
+		}
+	}
+	for _, got := range sorted {
+		if strings.EqualFold(got, DefaultLocale) {
+			return got, true
+		}
+	}
+	return "", false
+}
+
+// ResolveLocale picks the available locale that best matches an
+// Accept-Language header. See MatchLocale.
+func ResolveLocale(acceptLanguage string, available []string) (string, bool) {
+	return MatchLocale(ParseAcceptLanguage(acceptLanguage), available)
+}
+
+// Locales returns the locales that there are translations for, in order
+func (lt LocalisedText) Locales() []string {
+	locales := []string{}
+	for locale := range lt {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Resolve returns the translation that best matches an Accept-Language
+// header, or the fallback when there is no suitable translation
+func (lt LocalisedText) Resolve(acceptLanguage string, fallback string) string {
+	if locale, ok := ResolveLocale(acceptLanguage, lt.Locales()); ok {
+		return lt[locale]
+	}
+	return fallback
+}
+
+// Locales returns the locales that there are translations for, in order
+func (lb LocalisedNotificationBody) Locales() []string {
+	locales := []string{}
+	for locale := range lb {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Resolve returns the translation that best matches an Accept-Language
+// header, or the fallback when there is no suitable translation
+func (lb LocalisedNotificationBody) Resolve(
+	acceptLanguage string, fallback NotificationBody) NotificationBody {
+	if locale, ok := ResolveLocale(acceptLanguage, lb.Locales()); ok {
+		return lb[locale]
+	}
+	return fallback
+}
+
+// Localise returns a copy of the action with its name in the locale that
+// best matches an Accept-Language header
+func (ac Action) Localise(acceptLanguage string) Action {
+	ac.Name = ac.LocalisedName.Resolve(acceptLanguage, ac.Name)
+	return ac
+}
+
+// localiseActions localises a list of actions
+func localiseActions(actions []Action, acceptLanguage string) []Action {
+	if actions == nil {
+		return nil
+	}
+	localised := make([]Action, len(actions))
+	for i, ac := range actions {
+		localised[i] = ac.Localise(acceptLanguage)
+	}
+	return localised
+}
+
+// Localise returns a copy of the nudge with its title, notification body and
+// actions in the locale that best matches an Accept-Language header
+func (nu Nudge) Localise(acceptLanguage string) Nudge {
+	nu.Title = nu.LocalisedTitle.Resolve(acceptLanguage, nu.Title)
+	nu.NotificationBody = nu.LocalisedNotificationBody.Resolve(acceptLanguage, nu.NotificationBody)
+	nu.Actions = localiseActions(nu.Actions, acceptLanguage)
+	return nu
+}
+
+// Localise returns a copy of the item with its tagline, text, notification
+// body and actions in the locale that best matches an Accept-Language header
+func (it Item) Localise(acceptLanguage string) Item {
+	it.Tagline = it.LocalisedTagline.Resolve(acceptLanguage, it.Tagline)
+	it.Text = it.LocalisedText.Resolve(acceptLanguage, it.Text)
+	it.NotificationBody = it.LocalisedNotificationBody.Resolve(acceptLanguage, it.NotificationBody)
+	it.Actions = localiseActions(it.Actions, acceptLanguage)
+	return it
+}
+
+// Localise returns a copy of the feed with its actions, nudges and items in
+// the locale that best matches an Accept-Language header
+func (fe Feed) Localise(acceptLanguage string) Feed {
+	fe.Actions = localiseActions(fe.Actions, acceptLanguage)
+	if fe.Nudges != nil {
+		nudges := make([]Nudge, len(fe.Nudges))
+		for i, nu := range fe.Nudges {
+			nudges[i] = nu.Localise(acceptLanguage)
+		}
+		fe.Nudges = nudges
+	}
+	if fe.Items != nil {
+		items := make([]Item, len(fe.Items))
+		for i, it := range fe.Items {
+			items[i] = it.Localise(acceptLanguage)
+		}
+		fe.Items = items
+	}
+	return fe
+}
@@ -0,0 +1,172 @@
+package feedlib_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{header: "", want: []string{}},
+		{header: "sw", want: []string{"sw"}},
+		{header: "en;q=0.5, sw-KE, sw;q=0.9", want: []string{"sw-ke", "sw", "en"}},
+		{header: "fr;q=0, *, en;q=0.1", want: []string{"en"}},
+		{header: "en;q=bogus, sw;q=2, de", want: []string{"de"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, feedlib.ParseAcceptLanguage(tt.header))
+		})
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	tests := []struct {
+		name      string
+		preferred []string
+		available []string
+		want      string
+		wantOK    bool
+	}{
+		{
+			name:      "exact match",
+			preferred: []string{"sw-ke"},
+			available: []string{"en", "sw", "sw-KE"},
+			want:      "sw-KE",
+			wantOK:    true,
+		},
+		{
+			name:      "base language",
+			preferred: []string{"sw-TZ"},
+			available: []string{"en", "sw"},
+			want:      "sw",
+			wantOK:    true,
+		},
+		{
+			name:      "regional variant",
+			preferred: []string{"en"},
+			available: []string{"en-GB", "sw"},
+			want:      "en-GB",
+			wantOK:    true,
+		},
+		{
+			name:      "first preference wins",
+			preferred: []string{"fr", "sw", "en"},
+			available: []string{"en", "sw"},
+			want:      "sw",
+			wantOK:    true,
+		},
+		{
+			name:      "fallback to the default locale",
+			preferred: []string{"fr"},
+			available: []string{"sw", "en"},
+			want:      feedlib.DefaultLocale,
+			wantOK:    true,
+		},
+		{
+			name:      "nothing available",
+			preferred: []string{"fr"},
+			available: []string{"sw"},
+			wantOK:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := feedlib.MatchLocale(tt.preferred, tt.available)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLocalisedText_Resolve(t *testing.T) {
+	lt := feedlib.LocalisedText{
+		feedlib.LocaleEnglish:   "Set your PIN",
+		feedlib.LocaleKiswahili: "Weka PIN yako",
+	}
+	assert.Equal(t, []string{"en", "sw"}, lt.Locales())
+	assert.Equal(t, "Weka PIN yako", lt.Resolve("sw-KE,en;q=0.8", "fallback"))
+	assert.Equal(t, "Set your PIN", lt.Resolve("fr", "fallback"))
+
+	var empty feedlib.LocalisedText
+	assert.Equal(t, "fallback", empty.Resolve("sw", "fallback"))
+}
+
+func TestItem_Localise(t *testing.T) {
+	it := feedtest.Item("item-1", 1)
+	it.LocalisedTagline = feedlib.LocalisedText{"en": "Hello", "sw": "Habari"}
+	it.LocalisedText = feedlib.LocalisedText{"en": "Welcome", "sw": "Karibu"}
+	it.NotificationBody = feedlib.NotificationBody{PublishMessage: "New item"}
+	it.LocalisedNotificationBody = feedlib.LocalisedNotificationBody{
+		"en": {PublishMessage: "New item"},
+		"sw": {PublishMessage: "Kipengele kipya"},
+	}
+	it.Actions[0].LocalisedName = feedlib.LocalisedText{"en": "Open", "sw": "Fungua"}
+	name := it.Actions[0].Name
+
+	sw := it.Localise("sw")
+	assert.Equal(t, "Habari", sw.Tagline)
+	assert.Equal(t, "Karibu", sw.Text)
+	assert.Equal(t, "Kipengele kipya", sw.NotificationBody.PublishMessage)
+	assert.Equal(t, "Fungua", sw.Actions[0].Name)
+	assert.Equal(t, name, it.Actions[0].Name, "the original should not change")
+
+	fr := it.Localise("fr")
+	assert.Equal(t, "Hello", fr.Tagline)
+
+	plain := feedtest.Item("item-2", 1)
+	assert.Equal(t, plain.Tagline, plain.Localise("sw").Tagline,
+		"items without translations keep their text")
+}
+
+func TestFeed_Localise(t *testing.T) {
+	fe := getTestFeed()
+	fe.Nudges[0].LocalisedTitle = feedlib.LocalisedText{"en": "Update", "sw": "Sasisha"}
+	localised := fe.Localise("sw")
+	assert.Equal(t, "Sasisha", localised.Nudges[0].Title)
+	assert.NotEqual(t, "Sasisha", fe.Nudges[0].Title)
+}
+
+func TestLocalisedSchemas(t *testing.T) {
+	tests := []struct {
+		name       string
+		translated feedlib.LocalisedText
+		wantErr    bool
+	}{
+		{name: "with the default locale", translated: feedlib.LocalisedText{"en": "a", "sw-KE": "b"}},
+		{name: "without the default locale", translated: feedlib.LocalisedText{"sw": "b"}, wantErr: true},
+		{name: "invalid language tag", translated: feedlib.LocalisedText{"en": "a", "Swahili": "b"}, wantErr: true},
+		{name: "empty translation", translated: feedlib.LocalisedText{"en": ""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nu := feedtest.Nudge("nudge-1", 1)
+			nu.LocalisedTitle = tt.translated
+			b, err := json.Marshal(nu)
+			assert.Nil(t, err)
+
+			err = (&feedlib.Nudge{}).ValidateAndUnmarshal(b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Nudge.ValidateAndUnmarshal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				var vErr *feedlib.ValidationError
+				assert.True(t, errors.As(err, &vErr))
+			}
+		})
+	}
+
+	it := feedtest.Item("item-1", 1)
+	it.LocalisedNotificationBody = feedlib.LocalisedNotificationBody{"sw": {}}
+	_, err := it.ValidateAndMarshal()
+	assert.NotNil(t, err, "localised notification bodies need the default locale")
+}
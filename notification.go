@@ -0,0 +1,363 @@
+package feedlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// NotificationType identifies the event that a notification is sent for,
+// and hence which NotificationBody message is used
+type NotificationType string
+
+// known notification types
+const (
+	NotificationTypePublish   NotificationType = "PUBLISH"
+	NotificationTypeDelete    NotificationType = "DELETE"
+	NotificationTypeResolve   NotificationType = "RESOLVE"
+	NotificationTypeUnresolve NotificationType = "UNRESOLVE"
+	NotificationTypeShow      NotificationType = "SHOW"
+	NotificationTypeHide      NotificationType = "HIDE"
+)
+
+// AllNotificationType is the set of all known notification types
+var AllNotificationType = []NotificationType{
+	NotificationTypePublish,
+	NotificationTypeDelete,
+	NotificationTypeResolve,
+	NotificationTypeUnresolve,
+	NotificationTypeShow,
+	NotificationTypeHide,
+}
+
+// IsValid returns true only for valid notification types
+func (e NotificationType) IsValid() bool {
+	switch e {
+	case NotificationTypePublish, NotificationTypeDelete, NotificationTypeResolve,
+		NotificationTypeUnresolve, NotificationTypeShow, NotificationTypeHide:
+		return true
+	}
+	return false
+}
+
+func (e NotificationType) String() string {
+	return string(e)
+}
+
+// UnmarshalGQL reads and validates a notification type from the supplied input
+func (e *NotificationType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = NotificationType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid NotificationType", str)
+	}
+	return nil
+}
+
+// MarshalGQL writes the notification type to the supplied writer
+func (e NotificationType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// Message returns the message that should be sent for a notification type
+func (nb NotificationBody) Message(t NotificationType) string {
+	switch t {
+	case NotificationTypePublish:
+		return nb.PublishMessage
+	case NotificationTypeDelete:
+		return nb.DeleteMessage
+	case NotificationTypeResolve:
+		return nb.ResolveMessage
+	case NotificationTypeUnresolve:
+		return nb.UnresolveMessage
+	case NotificationTypeShow:
+		return nb.ShowMessage
+	case NotificationTypeHide:
+		return nb.HideMessage
+	}
+	return ""
+}
+
+// Notification is a single message to a single user over a single channel
+type Notification struct {
+	// A unique identifier for each notification
+	ID string `json:"id"`
+
+	// The channel that the notification is sent over
+	Channel Channel `json:"channel"`
+
+	// The UID of the user that the notification is sent to
+	UserID string `json:"userID"`
+
+	// Why the notification is being sent
+	Type NotificationType `json:"type"`
+
+	// The ID of the item or nudge that the notification is about
+	ElementID string `json:"elementID"`
+
+	// A short title e.g the nudge's title or the item's tagline
+	Title string `json:"title"`
+
+	// The message from the element's NotificationBody, rendered for the user
+	Message string `json:"message"`
+
+	// When the notification was created
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Notifier sends notifications over one channel e.g SMS
+type Notifier interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// DispatchFailure records a notification that could not be sent
+type DispatchFailure struct {
+	Notification Notification
+	Err          error
+}
+
+// DispatchError is returned when some of the notifications in a dispatch
+// could not be sent. The rest of the notifications are still sent.
+type DispatchError struct {
+	Failures []DispatchFailure
+}
+
+func (e *DispatchError) Error() string {
+	msgs := []string{}
+	for _, f := range e.Failures {
+		msgs = append(msgs, fmt.Sprintf(
+			"%s to %s: %s", f.Notification.Channel, f.Notification.UserID, f.Err))
+	}
+	return fmt.Sprintf(
+		"%d notification(s) could not be sent: %s",
+		len(e.Failures),
+		strings.Join(msgs, "; "),
+	)
+}
+
+// Dispatcher fans feed items and nudges out to their users over their
+// notification channels, using the Notifier registered for each channel
+type Dispatcher struct {
+	notifiers     map[Channel]Notifier
+	recipientData RecipientDataFunc
+	groups        GroupResolver
+	now           func() time.Time
+}
+
+// RecipientDataFunc looks up the template data for a notification's
+// recipient e.g their name and organisation
+type RecipientDataFunc func(ctx context.Context, uid string) (map[string]string, error)
+
+// NewDispatcher initializes a dispatcher with a notifier per channel.
+// Elements that should go out over a channel without a notifier fail
+// to dispatch.
+func NewDispatcher(notifiers map[Channel]Notifier) *Dispatcher {
+	registered := map[Channel]Notifier{}
+	for ch, n := range notifiers {
+		registered[ch] = n
+	}
+	return &Dispatcher{
+		notifiers: registered,
+		now:       time.Now,
+	}
+}
+
+// SetRecipientData sets the function that is used to look up the template
+// data of each recipient, so that messages can be personalised
+func (d *Dispatcher) SetRecipientData(fn RecipientDataFunc) {
+	d.recipientData = fn
+}
+
+// SetGroupResolver sets the resolver that is used to expand an element's
+// excluded groups, so that their members are not notified
+func (d *Dispatcher) SetGroupResolver(r GroupResolver) {
+	d.groups = r
+}
+
+// DispatchItem notifies every user of the item over each of the item's
+// notification channels, using the item's message for the notification type.
+// The message is rendered as a template for each recipient and then for each
+// channel as per the item's text type, so that e.g SMS gets plain text.
+//
+// Nothing is sent when the item has no message for the notification type.
+// Only the item's Users are notified, less its ExcludedUsers and the members
+// of its ExcludedGroups; groups need to be expanded into users by the caller
+// e.g with ResolveRecipients.
+func (d *Dispatcher) DispatchItem(
+	ctx context.Context, it Item, t NotificationType) ([]Notification, error) {
+	return d.dispatch(
+		ctx, t, it.ID, it.Tagline, it.NotificationBody.Message(t), it.TextType,
+		it.Audience(), it.NotificationChannels,
+		func(data map[string]string) (string, error) {
+			return RenderItemNotification(it, t, data)
+		},
+	)
+}
+
+// DispatchNudge notifies every user of the nudge over each of the nudge's
+// notification channels, using the nudge's message for the notification type.
+// The message is rendered as a template for each recipient.
+//
+// Nothing is sent when the nudge has no message for the notification type.
+// Only the nudge's Users are notified, less its ExcludedUsers and the members
+// of its ExcludedGroups; groups need to be expanded into users by the caller
+// e.g with ResolveRecipients.
+func (d *Dispatcher) DispatchNudge(
+	ctx context.Context, nu Nudge, t NotificationType) ([]Notification, error) {
+	return d.dispatch(
+		ctx, t, nu.ID, nu.Title, nu.NotificationBody.Message(t), TextTypePlain,
+		nu.Audience(), nu.NotificationChannels,
+		func(data map[string]string) (string, error) {
+			return RenderNudgeNotification(nu, t, data)
+		},
+	)
+}
+
+// dispatch sends a notification for each recipient and channel pair and
+// returns the notifications that were sent. The recipients are the
+// audience's users less its exclusions; its groups are not expanded. Messages
+// are rendered for each channel (see RenderForChannel).
+func (d *Dispatcher) dispatch(
+	ctx context.Context,
+	t NotificationType,
+	elementID string,
+	title string,
+	message string,
+	textType TextType,
+	audience Audience,
+	channels []Channel,
+	render func(data map[string]string) (string, error),
+) ([]Notification, error) {
+	if !t.IsValid() {
+		return nil, fmt.Errorf("%s is not a valid NotificationType", t)
+	}
+	sent := []Notification{}
+	if message == "" {
+		return sent, nil
+	}
+	audience.Groups = nil
+	users, err := ResolveRecipients(ctx, d.groups, audience)
+	if err != nil {
+		return nil, fmt.Errorf("can't work out the recipients of %s: %w", elementID, err)
+	}
+
+	failures := []DispatchFailure{}
+	for _, uid := range users {
+		rendered, err := d.renderFor(ctx, uid, render)
+		for _, ch := range channels {
+			n := Notification{
+				ID:        ksuid.New().String(),
+				Channel:   ch,
+				UserID:    uid,
+				Type:      t,
+				ElementID: elementID,
+				Title:     title,
+				Message:   rendered,
+				Timestamp: d.now(),
+			}
+			if err != nil {
+				failures = append(failures, DispatchFailure{Notification: n, Err: err})
+				continue
+			}
+			message, renderErr := RenderForChannel(rendered, textType, ch)
+			if renderErr != nil {
+				failures = append(failures, DispatchFailure{Notification: n, Err: renderErr})
+				continue
+			}
+			n.Message = message
+			if err := ctx.Err(); err != nil {
+				failures = append(failures, DispatchFailure{Notification: n, Err: err})
+				continue
+			}
+			notifier, ok := d.notifiers[ch]
+			if !ok {
+				failures = append(failures, DispatchFailure{
+					Notification: n,
+					Err:          fmt.Errorf("no notifier is registered for %s", ch),
+				})
+				continue
+			}
+			if err := notifier.Send(ctx, n); err != nil {
+				failures = append(failures, DispatchFailure{Notification: n, Err: err})
+				continue
+			}
+			sent = append(sent, n)
+		}
+	}
+	if len(failures) > 0 {
+		return sent, &DispatchError{Failures: failures}
+	}
+	return sent, nil
+}
+
+// renderFor renders a message for a single recipient
+func (d *Dispatcher) renderFor(
+	ctx context.Context,
+	uid string,
+	render func(data map[string]string) (string, error),
+) (string, error) {
+	data := map[string]string{}
+	if d.recipientData != nil {
+		recipientData, err := d.recipientData(ctx, uid)
+		if err != nil {
+			return "", fmt.Errorf("can't look up template data for %s: %w", uid, err)
+		}
+		data = recipientData
+	}
+	return render(data)
+}
+
+// MemoryNotifier is a fake Notifier that records the notifications it is
+// asked to send instead of sending them. It is safe for concurrent use.
+type MemoryNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+	err  error
+}
+
+// NewMemoryNotifier initializes a fake notifier. When err is not nil, every
+// send fails with it.
+func NewMemoryNotifier(err error) *MemoryNotifier {
+	return &MemoryNotifier{
+		sent: []Notification{},
+		err:  err,
+	}
+}
+
+// Send records the notification, or fails if the notifier was set up to
+func (n *MemoryNotifier) Send(ctx context.Context, notification Notification) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+// Sent returns the notifications that have been recorded so far
+func (n *MemoryNotifier) Sent() []Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Notification{}, n.sent...)
+}
+
+// Reset forgets all the notifications that have been recorded
+func (n *MemoryNotifier) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.sent = []Notification{}
+}
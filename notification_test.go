@@ -0,0 +1,237 @@
+package feedlib_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestNotificationBody() feedlib.NotificationBody {
+	return feedlib.NotificationBody{
+		PublishMessage:   "publish message",
+		DeleteMessage:    "delete message",
+		ResolveMessage:   "resolve message",
+		UnresolveMessage: "unresolve message",
+		ShowMessage:      "show message",
+		HideMessage:      "hide message",
+	}
+}
+
+func TestNotificationType_IsValid(t *testing.T) {
+	for _, nt := range feedlib.AllNotificationType {
+		assert.True(t, nt.IsValid(), "%s should be valid", nt)
+	}
+	assert.False(t, feedlib.NotificationType("bogus").IsValid())
+}
+
+func TestNotificationType_UnmarshalGQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       interface{}
+		wantErr bool
+	}{
+		{
+			name:    "valid notification type",
+			v:       "PUBLISH",
+			wantErr: false,
+		},
+		{
+			name:    "invalid notification type",
+			v:       "bogus",
+			wantErr: true,
+		},
+		{
+			name:    "not a string",
+			v:       1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nt := feedlib.NotificationType("")
+			if err := nt.UnmarshalGQL(tt.v); (err != nil) != tt.wantErr {
+				t.Errorf("NotificationType.UnmarshalGQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotificationType_MarshalGQL(t *testing.T) {
+	w := &bytes.Buffer{}
+	feedlib.NotificationTypeHide.MarshalGQL(w)
+	assert.Equal(t, strconv.Quote("HIDE"), w.String())
+}
+
+func TestNotificationBody_Message(t *testing.T) {
+	nb := getTestNotificationBody()
+	tests := []struct {
+		nt   feedlib.NotificationType
+		want string
+	}{
+		{nt: feedlib.NotificationTypePublish, want: "publish message"},
+		{nt: feedlib.NotificationTypeDelete, want: "delete message"},
+		{nt: feedlib.NotificationTypeResolve, want: "resolve message"},
+		{nt: feedlib.NotificationTypeUnresolve, want: "unresolve message"},
+		{nt: feedlib.NotificationTypeShow, want: "show message"},
+		{nt: feedlib.NotificationTypeHide, want: "hide message"},
+		{nt: feedlib.NotificationType("bogus"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nt.String(), func(t *testing.T) {
+			assert.Equal(t, tt.want, nb.Message(tt.nt))
+		})
+	}
+}
+
+func TestDispatcher_DispatchItem(t *testing.T) {
+	sms := feedlib.NewMemoryNotifier(nil)
+	fcm := feedlib.NewMemoryNotifier(nil)
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{
+		feedlib.ChannelSms: sms,
+		feedlib.ChannelFcm: fcm,
+	})
+
+	it := feedtest.Item("item-1", 1)
+	it.Users = []string{"user-1", "user-2"}
+	it.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms, feedlib.ChannelFcm}
+	it.NotificationBody = getTestNotificationBody()
+
+	sent, err := d.DispatchItem(context.Background(), it, feedlib.NotificationTypeResolve)
+	assert.Nil(t, err)
+	assert.Len(t, sent, 4)
+
+	for _, notifier := range []*feedlib.MemoryNotifier{sms, fcm} {
+		got := notifier.Sent()
+		assert.Len(t, got, 2)
+		assert.Equal(t, "user-1", got[0].UserID)
+		assert.Equal(t, "user-2", got[1].UserID)
+		for _, n := range got {
+			assert.Equal(t, "item-1", n.ElementID)
+			assert.Equal(t, "resolve message", n.Message)
+			assert.Equal(t, it.Tagline, n.Title)
+			assert.Equal(t, feedlib.NotificationTypeResolve, n.Type)
+			assert.NotEmpty(t, n.ID)
+			assert.False(t, n.Timestamp.IsZero())
+		}
+	}
+	assert.Equal(t, feedlib.ChannelSms, sms.Sent()[0].Channel)
+	assert.Equal(t, feedlib.ChannelFcm, fcm.Sent()[0].Channel)
+
+	sms.Reset()
+	assert.Empty(t, sms.Sent())
+}
+
+func TestDispatcher_DispatchNudge(t *testing.T) {
+	email := feedlib.NewMemoryNotifier(nil)
+	failing := feedlib.NewMemoryNotifier(errors.New("provider is down"))
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{
+		feedlib.ChannelEmail:    email,
+		feedlib.ChannelWhatsapp: failing,
+	})
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.Users = []string{"user-1"}
+	nu.NotificationChannels = []feedlib.Channel{
+		feedlib.ChannelEmail,
+		feedlib.ChannelWhatsapp,
+		feedlib.ChannelSms,
+	}
+	nu.NotificationBody = getTestNotificationBody()
+
+	sent, err := d.DispatchNudge(context.Background(), nu, feedlib.NotificationTypePublish)
+	assert.Len(t, sent, 1, "working channels should still be notified")
+	assert.Equal(t, "<p>publish message</p>", email.Sent()[0].Message)
+	assert.Equal(t, nu.Title, email.Sent()[0].Title)
+
+	var dErr *feedlib.DispatchError
+	if !errors.As(err, &dErr) {
+		t.Errorf("expected a *feedlib.DispatchError, got %T", err)
+		return
+	}
+	assert.Len(t, dErr.Failures, 2)
+	assert.Equal(t, feedlib.ChannelWhatsapp, dErr.Failures[0].Notification.Channel)
+	assert.Equal(t, feedlib.ChannelSms, dErr.Failures[1].Notification.Channel)
+	assert.Contains(t, dErr.Error(), "provider is down")
+	assert.Contains(t, dErr.Error(), "no notifier is registered for SMS")
+}
+
+func TestDispatcher_NothingToSend(t *testing.T) {
+	sms := feedlib.NewMemoryNotifier(nil)
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{
+		feedlib.ChannelSms: sms,
+	})
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+
+	sent, err := d.DispatchNudge(context.Background(), nu, feedlib.NotificationTypeHide)
+	assert.Nil(t, err)
+	assert.Empty(t, sent, "there is no hide message to send")
+	assert.Empty(t, sms.Sent())
+
+	_, err = d.DispatchNudge(context.Background(), nu, feedlib.NotificationType("bogus"))
+	assert.NotNil(t, err)
+}
+
+func TestDispatcher_Exclusions(t *testing.T) {
+	sms := feedlib.NewMemoryNotifier(nil)
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{
+		feedlib.ChannelSms: sms,
+	})
+
+	it := feedtest.Item("item-1", 1)
+	it.Users = []string{"user-1", "user-2", "user-3"}
+	it.ExcludedUsers = []string{"user-2"}
+	it.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+	it.NotificationBody = getTestNotificationBody()
+
+	sent, err := d.DispatchItem(context.Background(), it, feedlib.NotificationTypePublish)
+	assert.Nil(t, err)
+	assert.Len(t, sent, 2)
+	assert.Equal(t, "user-1", sent[0].UserID)
+	assert.Equal(t, "user-3", sent[1].UserID)
+
+	// excluded groups can't be honoured without a resolver
+	sms.Reset()
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.Users = []string{"user-1", "user-2", "user-3"}
+	nu.ExcludedGroups = []string{"opted-out"}
+	nu.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+	nu.NotificationBody = getTestNotificationBody()
+	_, err = d.DispatchNudge(context.Background(), nu, feedlib.NotificationTypePublish)
+	assert.NotNil(t, err)
+	assert.Empty(t, sms.Sent())
+
+	r := feedlib.NewMemoryGroupResolver()
+	assert.Nil(t, r.PutGroup("opted-out", feedlib.GroupMembers{Users: []string{"user-3"}}))
+	d.SetGroupResolver(r)
+	sent, err = d.DispatchNudge(context.Background(), nu, feedlib.NotificationTypePublish)
+	assert.Nil(t, err)
+	assert.Len(t, sent, 2)
+	assert.Equal(t, "user-1", sent[0].UserID)
+	assert.Equal(t, "user-2", sent[1].UserID)
+}
+
+func TestDispatcher_CancelledContext(t *testing.T) {
+	sms := feedlib.NewMemoryNotifier(nil)
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{
+		feedlib.ChannelSms: sms,
+	})
+
+	it := feedtest.Item("item-1", 1)
+	it.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+	it.NotificationBody = getTestNotificationBody()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sent, err := d.DispatchItem(ctx, it, feedlib.NotificationTypePublish)
+	assert.NotNil(t, err)
+	assert.Empty(t, sent)
+	assert.Empty(t, sms.Sent())
+}
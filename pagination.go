@@ -0,0 +1,352 @@
+package feedlib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// page sizes
+const (
+	// DefaultPageSize is the number of elements in a page when neither
+	// first nor last is requested
+	DefaultPageSize = 20
+
+	// MaxPageSize is the largest page that can be requested
+	MaxPageSize = 100
+)
+
+// MinCursorKeyLength is the minimum length, in bytes, of the key that
+// cursors are signed with
+const MinCursorKeyLength = 16
+
+// ErrInvalidCursor is returned when a cursor can't be decoded, has been
+// tampered with or belongs to a different kind of element
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// kinds of paginated elements. A cursor can only be used with the kind of
+// element that it was issued for.
+const (
+	cursorKindItem    = "item"
+	cursorKindNudge   = "nudge"
+	cursorKindMessage = "message"
+)
+
+// PageRequest asks for a page of elements as per the Relay connection spec:
+// first/after pages forward and last/before pages backward. When neither
+// first nor last is set, the first DefaultPageSize elements are returned.
+type PageRequest struct {
+	// Return the first n elements (after the After cursor, if any)
+	First *int `json:"first,omitempty"`
+
+	// Only return elements after this cursor
+	After string `json:"after,omitempty"`
+
+	// Return the last n elements (before the Before cursor, if any)
+	Last *int `json:"last,omitempty"`
+
+	// Only return elements before this cursor
+	Before string `json:"before,omitempty"`
+}
+
+// Validate checks that the page sizes are in range and that first and last
+// are not both set
+func (r PageRequest) Validate() error {
+	if r.First != nil && r.Last != nil {
+		return fmt.Errorf("first and last can't be used together")
+	}
+	for name, size := range map[string]*int{"first": r.First, "last": r.Last} {
+		if size != nil && (*size < 0 || *size > MaxPageSize) {
+			return fmt.Errorf("%s must be between 0 and %d, got %d", name, MaxPageSize, *size)
+		}
+	}
+	return nil
+}
+
+// PageInfo describes a page of elements as per the Relay connection spec
+type PageInfo struct {
+	HasNextPage     bool   `json:"hasNextPage"`
+	HasPreviousPage bool   `json:"hasPreviousPage"`
+	StartCursor     string `json:"startCursor,omitempty"`
+	EndCursor       string `json:"endCursor,omitempty"`
+}
+
+// ItemEdge is an item and its cursor
+type ItemEdge struct {
+	Cursor string `json:"cursor"`
+	Node   Item   `json:"node"`
+}
+
+// ItemConnection is a page of items
+type ItemConnection struct {
+	Edges      []ItemEdge `json:"edges"`
+	PageInfo   PageInfo   `json:"pageInfo"`
+	TotalCount int        `json:"totalCount"`
+}
+
+// NudgeEdge is a nudge and its cursor
+type NudgeEdge struct {
+	Cursor string `json:"cursor"`
+	Node   Nudge  `json:"node"`
+}
+
+// NudgeConnection is a page of nudges
+type NudgeConnection struct {
+	Edges      []NudgeEdge `json:"edges"`
+	PageInfo   PageInfo    `json:"pageInfo"`
+	TotalCount int         `json:"totalCount"`
+}
+
+// MessageEdge is a message and its cursor
+type MessageEdge struct {
+	Cursor string  `json:"cursor"`
+	Node   Message `json:"node"`
+}
+
+// MessageConnection is a page of messages
+type MessageConnection struct {
+	Edges      []MessageEdge `json:"edges"`
+	PageInfo   PageInfo      `json:"pageInfo"`
+	TotalCount int           `json:"totalCount"`
+}
+
+// cursorKey is the position of an element in a paginated list. Elements are
+// ordered by sequence number, then by timestamp and then by ID.
+type cursorKey struct {
+	Kind           string `json:"k"`
+	SequenceNumber int    `json:"s"`
+	Timestamp      int64  `json:"t,omitempty"`
+	ID             string `json:"i"`
+}
+
+func newCursorKey(kind string, sequenceNumber int, timestamp time.Time, id string) cursorKey {
+	key := cursorKey{Kind: kind, SequenceNumber: sequenceNumber, ID: id}
+	if !timestamp.IsZero() {
+		key.Timestamp = timestamp.UnixNano()
+	}
+	return key
+}
+
+func (k cursorKey) less(other cursorKey) bool {
+	if k.SequenceNumber != other.SequenceNumber {
+		return k.SequenceNumber < other.SequenceNumber
+	}
+	if k.Timestamp != other.Timestamp {
+		return k.Timestamp < other.Timestamp
+	}
+	return k.ID < other.ID
+}
+
+// Paginator pages through feed elements using opaque cursors.
+//
+// A cursor records the position of an element rather than an offset, so
+// pages stay stable when elements are added or removed between requests.
+// Cursors are signed with the paginator's key so that clients can't forge
+// or alter them.
+type Paginator struct {
+	key []byte
+}
+
+// NewPaginator initializes a paginator that signs cursors with the supplied
+// secret key. Every instance of a service must use the same key.
+func NewPaginator(key []byte) (*Paginator, error) {
+	if len(key) < MinCursorKeyLength {
+		return nil, fmt.Errorf("a cursor key of at least %d bytes is required", MinCursorKeyLength)
+	}
+	return &Paginator{key: append([]byte{}, key...)}, nil
+}
+
+func (p *Paginator) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	_, _ = mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// encodeCursor turns a key into an opaque, signed cursor
+func (p *Paginator) encodeCursor(key cursorKey) string {
+	payload, err := json.Marshal(key)
+	if err != nil {
+		// a cursorKey only has fields that can be marshalled
+		panic(fmt.Sprintf("can't marshal cursor: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(p.sign(payload))
+}
+
+// decodeCursor checks a cursor's signature and kind and returns its key
+func (p *Paginator) decodeCursor(cursor string, kind string) (cursorKey, error) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return cursorKey{}, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return cursorKey{}, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return cursorKey{}, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	if !hmac.Equal(signature, p.sign(payload)) {
+		return cursorKey{}, fmt.Errorf("%w: bad signature", ErrInvalidCursor)
+	}
+	key := cursorKey{}
+	if err := json.Unmarshal(payload, &key); err != nil {
+		return cursorKey{}, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	if key.Kind != kind {
+		return cursorKey{}, fmt.Errorf("%w: not a %s cursor", ErrInvalidCursor, kind)
+	}
+	return key, nil
+}
+
+// page works out which of the sorted keys are in the requested page. It
+// returns the index of the first key in the page and the index after the
+// last one.
+func (p *Paginator) page(kind string, keys []cursorKey, req PageRequest) (int, int, error) {
+	if err := req.Validate(); err != nil {
+		return 0, 0, err
+	}
+
+	start, end := 0, len(keys)
+	if req.After != "" {
+		after, err := p.decodeCursor(req.After, kind)
+		if err != nil {
+			return 0, 0, err
+		}
+		start = sort.Search(len(keys), func(i int) bool { return after.less(keys[i]) })
+	}
+	if req.Before != "" {
+		before, err := p.decodeCursor(req.Before, kind)
+		if err != nil {
+			return 0, 0, err
+		}
+		end = sort.Search(len(keys), func(i int) bool { return !keys[i].less(before) })
+	}
+	if end < start {
+		end = start
+	}
+
+	switch {
+	case req.Last != nil:
+		if end-start > *req.Last {
+			start = end - *req.Last
+		}
+	default:
+		first := DefaultPageSize
+		if req.First != nil {
+			first = *req.First
+		}
+		if end-start > first {
+			end = start + first
+		}
+	}
+	return start, end, nil
+}
+
+// pageInfo describes the page between start and end
+func (p *Paginator) pageInfo(keys []cursorKey, start int, end int) PageInfo {
+	info := PageInfo{
+		HasPreviousPage: start > 0,
+		HasNextPage:     end < len(keys),
+	}
+	if end > start {
+		info.StartCursor = p.encodeCursor(keys[start])
+		info.EndCursor = p.encodeCursor(keys[end-1])
+	}
+	return info
+}
+
+// PaginateItems returns a page of items ordered by sequence number. The
+// supplied slice is not modified.
+func (p *Paginator) PaginateItems(items []Item, req PageRequest) (*ItemConnection, error) {
+	sorted := append([]Item{}, items...)
+	keys := make([]cursorKey, len(sorted))
+	for i, it := range sorted {
+		keys[i] = newCursorKey(cursorKindItem, it.SequenceNumber, it.Timestamp, it.ID)
+	}
+	sort.Sort(byCursorKey{keys: keys, swap: func(i, j int) { sorted[i], sorted[j] = sorted[j], sorted[i] }})
+
+	start, end, err := p.page(cursorKindItem, keys, req)
+	if err != nil {
+		return nil, err
+	}
+	edges := []ItemEdge{}
+	for i := start; i < end; i++ {
+		edges = append(edges, ItemEdge{Cursor: p.encodeCursor(keys[i]), Node: sorted[i]})
+	}
+	return &ItemConnection{
+		Edges:      edges,
+		PageInfo:   p.pageInfo(keys, start, end),
+		TotalCount: len(sorted),
+	}, nil
+}
+
+// PaginateNudges returns a page of nudges ordered by sequence number. The
+// supplied slice is not modified.
+func (p *Paginator) PaginateNudges(nudges []Nudge, req PageRequest) (*NudgeConnection, error) {
+	sorted := append([]Nudge{}, nudges...)
+	keys := make([]cursorKey, len(sorted))
+	for i, nu := range sorted {
+		keys[i] = newCursorKey(cursorKindNudge, nu.SequenceNumber, time.Time{}, nu.ID)
+	}
+	sort.Sort(byCursorKey{keys: keys, swap: func(i, j int) { sorted[i], sorted[j] = sorted[j], sorted[i] }})
+
+	start, end, err := p.page(cursorKindNudge, keys, req)
+	if err != nil {
+		return nil, err
+	}
+	edges := []NudgeEdge{}
+	for i := start; i < end; i++ {
+		edges = append(edges, NudgeEdge{Cursor: p.encodeCursor(keys[i]), Node: sorted[i]})
+	}
+	return &NudgeConnection{
+		Edges:      edges,
+		PageInfo:   p.pageInfo(keys, start, end),
+		TotalCount: len(sorted),
+	}, nil
+}
+
+// PaginateMessages returns a page of messages ordered by sequence number.
+// The supplied slice is not modified.
+func (p *Paginator) PaginateMessages(msgs []Message, req PageRequest) (*MessageConnection, error) {
+	sorted := append([]Message{}, msgs...)
+	keys := make([]cursorKey, len(sorted))
+	for i, msg := range sorted {
+		keys[i] = newCursorKey(cursorKindMessage, msg.SequenceNumber, msg.Timestamp, msg.ID)
+	}
+	sort.Sort(byCursorKey{keys: keys, swap: func(i, j int) { sorted[i], sorted[j] = sorted[j], sorted[i] }})
+
+	start, end, err := p.page(cursorKindMessage, keys, req)
+	if err != nil {
+		return nil, err
+	}
+	edges := []MessageEdge{}
+	for i := start; i < end; i++ {
+		edges = append(edges, MessageEdge{Cursor: p.encodeCursor(keys[i]), Node: sorted[i]})
+	}
+	return &MessageConnection{
+		Edges:      edges,
+		PageInfo:   p.pageInfo(keys, start, end),
+		TotalCount: len(sorted),
+	}, nil
+}
+
+// byCursorKey sorts keys together with the elements that they belong to
+type byCursorKey struct {
+	keys []cursorKey
+	swap func(i, j int)
+}
+
+func (s byCursorKey) Len() int           { return len(s.keys) }
+func (s byCursorKey) Less(i, j int) bool { return s.keys[i].less(s.keys[j]) }
+func (s byCursorKey) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.swap(i, j)
+}
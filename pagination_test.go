@@ -0,0 +1,220 @@
+package feedlib_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCursorKey = []byte("a secret key used to sign cursors")
+
+func getTestPaginator(t *testing.T) *feedlib.Paginator {
+	p, err := feedlib.NewPaginator(testCursorKey)
+	assert.Nil(t, err)
+	return p
+}
+
+func getTestItems(n int) []feedlib.Item {
+	items := []feedlib.Item{}
+	// added in reverse to check that pages are ordered by sequence number
+	for i := n; i > 0; i-- {
+		items = append(items, feedtest.Item(fmt.Sprintf("item-%d", i), i))
+	}
+	return items
+}
+
+func itemIDs(conn *feedlib.ItemConnection) []string {
+	ids := []string{}
+	for _, e := range conn.Edges {
+		ids = append(ids, e.Node.ID)
+	}
+	return ids
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestNewPaginator(t *testing.T) {
+	_, err := feedlib.NewPaginator([]byte("short"))
+	assert.NotNil(t, err)
+
+	p, err := feedlib.NewPaginator(testCursorKey)
+	assert.Nil(t, err)
+	assert.NotNil(t, p)
+}
+
+func TestPaginator_PaginateItems(t *testing.T) {
+	p := getTestPaginator(t)
+	items := getTestItems(5)
+
+	first, err := p.PaginateItems(items, feedlib.PageRequest{First: intPtr(2)})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"item-1", "item-2"}, itemIDs(first))
+	assert.Equal(t, 5, first.TotalCount)
+	assert.True(t, first.PageInfo.HasNextPage)
+	assert.False(t, first.PageInfo.HasPreviousPage)
+	assert.Equal(t, first.Edges[0].Cursor, first.PageInfo.StartCursor)
+	assert.Equal(t, first.Edges[1].Cursor, first.PageInfo.EndCursor)
+
+	second, err := p.PaginateItems(items, feedlib.PageRequest{
+		First: intPtr(2),
+		After: first.PageInfo.EndCursor,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"item-3", "item-4"}, itemIDs(second))
+	assert.True(t, second.PageInfo.HasNextPage)
+	assert.True(t, second.PageInfo.HasPreviousPage)
+
+	third, err := p.PaginateItems(items, feedlib.PageRequest{
+		First: intPtr(2),
+		After: second.PageInfo.EndCursor,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"item-5"}, itemIDs(third))
+	assert.False(t, third.PageInfo.HasNextPage)
+
+	last, err := p.PaginateItems(items, feedlib.PageRequest{
+		Last:   intPtr(2),
+		Before: third.PageInfo.StartCursor,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"item-3", "item-4"}, itemIDs(last))
+	assert.True(t, last.PageInfo.HasPreviousPage)
+	assert.True(t, last.PageInfo.HasNextPage)
+
+	between, err := p.PaginateItems(items, feedlib.PageRequest{
+		After:  first.Edges[0].Cursor,
+		Before: third.PageInfo.StartCursor,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"item-2", "item-3", "item-4"}, itemIDs(between))
+
+	empty, err := p.PaginateItems(nil, feedlib.PageRequest{})
+	assert.Nil(t, err)
+	assert.Empty(t, empty.Edges)
+	assert.Equal(t, feedlib.PageInfo{}, empty.PageInfo)
+
+	assert.Equal(t, "item-5", items[0].ID, "the supplied items should not be reordered")
+}
+
+func TestPaginator_DefaultPageSize(t *testing.T) {
+	p := getTestPaginator(t)
+	conn, err := p.PaginateItems(getTestItems(feedlib.DefaultPageSize+5), feedlib.PageRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, conn.Edges, feedlib.DefaultPageSize)
+	assert.True(t, conn.PageInfo.HasNextPage)
+}
+
+func TestPaginator_ConcurrentInserts(t *testing.T) {
+	p := getTestPaginator(t)
+	items := getTestItems(4)
+
+	first, err := p.PaginateItems(items, feedlib.PageRequest{First: intPtr(2)})
+	assert.Nil(t, err)
+
+	// an element is removed from the first page and two are added: one
+	// at the end of the feed and one that sorts before the cursor
+	items = append(items[:3], feedtest.Item("item-9", 9), feedtest.Item("item-0", 0))
+
+	second, err := p.PaginateItems(items, feedlib.PageRequest{
+		First: intPtr(3),
+		After: first.PageInfo.EndCursor,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"item-3", "item-4", "item-9"}, itemIDs(second))
+}
+
+func TestPaginator_InvalidRequests(t *testing.T) {
+	p := getTestPaginator(t)
+	items := getTestItems(3)
+	conn, err := p.PaginateItems(items, feedlib.PageRequest{First: intPtr(1)})
+	assert.Nil(t, err)
+	cursor := conn.PageInfo.EndCursor
+
+	parts := strings.Split(cursor, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	assert.Nil(t, err)
+	tampered := base64.RawURLEncoding.EncodeToString(
+		[]byte(strings.Replace(string(payload), `"s":1`, `"s":2`, 1))) + "." + parts[1]
+
+	other, err := feedlib.NewPaginator([]byte("a different secret key for cursors"))
+	assert.Nil(t, err)
+	foreign, err := other.PaginateItems(items, feedlib.PageRequest{First: intPtr(1)})
+	assert.Nil(t, err)
+
+	nudges, err := p.PaginateNudges(
+		[]feedlib.Nudge{feedtest.Nudge("nudge-1", 1)}, feedlib.PageRequest{})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name          string
+		req           feedlib.PageRequest
+		wantErrCursor bool
+	}{
+		{name: "first and last", req: feedlib.PageRequest{First: intPtr(1), Last: intPtr(1)}},
+		{name: "negative first", req: feedlib.PageRequest{First: intPtr(-1)}},
+		{name: "page too big", req: feedlib.PageRequest{Last: intPtr(feedlib.MaxPageSize + 1)}},
+		{name: "garbage cursor", req: feedlib.PageRequest{After: "garbage"}, wantErrCursor: true},
+		{name: "tampered cursor", req: feedlib.PageRequest{After: tampered}, wantErrCursor: true},
+		{
+			name:          "cursor signed with another key",
+			req:           feedlib.PageRequest{Before: foreign.PageInfo.EndCursor},
+			wantErrCursor: true,
+		},
+		{
+			name:          "nudge cursor for items",
+			req:           feedlib.PageRequest{After: nudges.PageInfo.EndCursor},
+			wantErrCursor: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.PaginateItems(items, tt.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tt.wantErrCursor, errors.Is(err, feedlib.ErrInvalidCursor))
+		})
+	}
+}
+
+func TestPaginator_PaginateNudges(t *testing.T) {
+	p := getTestPaginator(t)
+	nudges := []feedlib.Nudge{
+		feedtest.Nudge("nudge-3", 3),
+		feedtest.Nudge("nudge-1", 1),
+		feedtest.Nudge("nudge-2", 2),
+	}
+
+	conn, err := p.PaginateNudges(nudges, feedlib.PageRequest{Last: intPtr(2)})
+	assert.Nil(t, err)
+	assert.Len(t, conn.Edges, 2)
+	assert.Equal(t, "nudge-2", conn.Edges[0].Node.ID)
+	assert.Equal(t, "nudge-3", conn.Edges[1].Node.ID)
+	assert.True(t, conn.PageInfo.HasPreviousPage)
+	assert.False(t, conn.PageInfo.HasNextPage)
+}
+
+func TestPaginator_PaginateMessages(t *testing.T) {
+	p := getTestPaginator(t)
+	it := feedtest.Item("item-1", 1)
+	reply := it.Conversations[0]
+	reply.ID = "msg-2"
+	reply.SequenceNumber = 2
+	msgs := []feedlib.Message{reply, it.Conversations[0]}
+
+	conn, err := p.PaginateMessages(msgs, feedlib.PageRequest{First: intPtr(1)})
+	assert.Nil(t, err)
+	assert.Equal(t, "msg-1", conn.Edges[0].Node.ID)
+	assert.True(t, conn.PageInfo.HasNextPage)
+
+	conn, err = p.PaginateMessages(msgs, feedlib.PageRequest{After: conn.PageInfo.EndCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, "msg-2", conn.Edges[0].Node.ID)
+	assert.False(t, conn.PageInfo.HasNextPage)
+}
@@ -0,0 +1,542 @@
+package feedlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPatch is returned when a patch is malformed or can't be applied
+// to an element e.g because it removes a field that does not exist
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// JSON Patch (RFC 6902) operations
+const (
+	PatchOpAdd     = "add"
+	PatchOpRemove  = "remove"
+	PatchOpReplace = "replace"
+	PatchOpMove    = "move"
+	PatchOpCopy    = "copy"
+	PatchOpTest    = "test"
+)
+
+// PatchOperation is a single JSON Patch (RFC 6902) operation
+type PatchOperation struct {
+	// One of add, remove, replace, move, copy or test
+	Op string `json:"op"`
+
+	// A JSON pointer (RFC 6901) to the target of the operation
+	Path string `json:"path"`
+
+	// A JSON pointer to the source of a move or copy
+	From string `json:"from,omitempty"`
+
+	// The value to add, replace or test. It is required by those
+	// operations even when it is null.
+	Value interface{} `json:"value,omitempty"`
+}
+
+// MarshalJSON always includes the value of operations that need one, even
+// when it is null
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	type operation PatchOperation
+	switch op.Op {
+	case PatchOpAdd, PatchOpReplace, PatchOpTest:
+		return json.Marshal(struct {
+			Op    string      `json:"op"`
+			Path  string      `json:"path"`
+			Value interface{} `json:"value"`
+		}{op.Op, op.Path, op.Value})
+	}
+	return json.Marshal(operation(op))
+}
+
+// CreateMergePatch returns the JSON Merge Patch (RFC 7396) that turns the
+// original element into the modified one. The elements should be of the same
+// type. Lists are replaced as a whole, as RFC 7396 requires.
+func CreateMergePatch(original Element, modified Element) ([]byte, error) {
+	from, err := toJSONValue(original)
+	if err != nil {
+		return nil, err
+	}
+	to, err := toJSONValue(modified)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeDiff(from, to))
+}
+
+// mergeDiff returns the merge patch from one JSON value to another
+func mergeDiff(from interface{}, to interface{}) interface{} {
+	fromObj, fromIsObj := from.(map[string]interface{})
+	toObj, toIsObj := to.(map[string]interface{})
+	if !fromIsObj || !toIsObj {
+		return to
+	}
+	patch := map[string]interface{}{}
+	for k, v := range toObj {
+		old, ok := fromObj[k]
+		if !ok {
+			patch[k] = v
+			continue
+		}
+		if jsonEqual(old, v) {
+			continue
+		}
+		patch[k] = mergeDiff(old, v)
+	}
+	for k := range fromObj {
+		if _, ok := toObj[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
+
+// ApplyMergePatch applies a JSON Merge Patch (RFC 7396) to an element, which
+// should be a pointer. The patched element is validated against its schema
+// and the element is only updated when it is valid.
+func ApplyMergePatch(el Element, patch []byte) error {
+	doc, err := toJSONValue(el)
+	if err != nil {
+		return err
+	}
+	p, err := decodeJSONValue(patch)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+	return replaceElement(el, mergePatch(doc, p))
+}
+
+// mergePatch applies a merge patch to a JSON value as per RFC 7396
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergePatch(targetObj[k], v)
+	}
+	return targetObj
+}
+
+// CreateJSONPatch returns the JSON Patch (RFC 6902) operations that turn the
+// original element into the modified one. The elements should be of the
+// same type. Lists of different lengths are replaced as a whole.
+func CreateJSONPatch(original Element, modified Element) ([]byte, error) {
+	from, err := toJSONValue(original)
+	if err != nil {
+		return nil, err
+	}
+	to, err := toJSONValue(modified)
+	if err != nil {
+		return nil, err
+	}
+	ops := []PatchOperation{}
+	jsonPatchDiff("", from, to, &ops)
+	return json.Marshal(ops)
+}
+
+// jsonPatchDiff appends the operations that turn one JSON value into another
+func jsonPatchDiff(path string, from interface{}, to interface{}, ops *[]PatchOperation) {
+	if jsonEqual(from, to) {
+		return
+	}
+	switch fromValue := from.(type) {
+	case map[string]interface{}:
+		toValue, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, k := range sortedKeys(fromValue) {
+			if _, ok := toValue[k]; !ok {
+				*ops = append(*ops, PatchOperation{Op: PatchOpRemove, Path: path + "/" + escapeJSONPointer(k)})
+			}
+		}
+		for _, k := range sortedKeys(toValue) {
+			childPath := path + "/" + escapeJSONPointer(k)
+			old, ok := fromValue[k]
+			if !ok {
+				*ops = append(*ops, PatchOperation{Op: PatchOpAdd, Path: childPath, Value: toValue[k]})
+				continue
+			}
+			jsonPatchDiff(childPath, old, toValue[k], ops)
+		}
+		return
+	case []interface{}:
+		toValue, ok := to.([]interface{})
+		if !ok || len(toValue) != len(fromValue) {
+			break
+		}
+		for i := range fromValue {
+			jsonPatchDiff(path+"/"+strconv.Itoa(i), fromValue[i], toValue[i], ops)
+		}
+		return
+	}
+	*ops = append(*ops, PatchOperation{Op: PatchOpReplace, Path: path, Value: to})
+}
+
+// ApplyJSONPatch applies JSON Patch (RFC 6902) operations to an element,
+// which should be a pointer. The operations are applied in order and either
+// all of them apply or none do. The patched element is validated against its
+// schema and the element is only updated when it is valid.
+func ApplyJSONPatch(el Element, patch []byte) error {
+	ops := []PatchOperation{}
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.UseNumber()
+	if err := dec.Decode(&ops); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+	// a value of null is decoded as a missing value, so values are checked
+	// against the raw operations
+	raw := []map[string]json.RawMessage{}
+	if err := json.Unmarshal(patch, &raw); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	doc, err := toJSONValue(el)
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		if _, ok := raw[i]["value"]; !ok && needsValue(op.Op) {
+			return fmt.Errorf("%w: operation %d (%s) has no value", ErrInvalidPatch, i, op.Op)
+		}
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			return fmt.Errorf("%w: operation %d (%s %s): %s", ErrInvalidPatch, i, op.Op, op.Path, err)
+		}
+	}
+	return replaceElement(el, doc)
+}
+
+func needsValue(op string) bool {
+	return op == PatchOpAdd || op == PatchOpReplace || op == PatchOpTest
+}
+
+// applyOperation applies a single JSON Patch operation to a document and
+// returns the new document
+func applyOperation(doc interface{}, op PatchOperation) (interface{}, error) {
+	switch op.Op {
+	case PatchOpAdd:
+		return addValue(doc, op.Path, deepCopyJSON(op.Value))
+	case PatchOpRemove:
+		doc, _, err := removeValue(doc, op.Path)
+		return doc, err
+	case PatchOpReplace:
+		doc, _, err := removeValue(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, op.Path, deepCopyJSON(op.Value))
+	case PatchOpMove:
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("can't move %s into itself", op.From)
+		}
+		doc, value, err := removeValue(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, op.Path, value)
+	case PatchOpCopy:
+		value, err := getValue(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, op.Path, deepCopyJSON(value))
+	case PatchOpTest:
+		value, err := getValue(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(value, op.Value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%q is not a valid operation", op.Op)
+}
+
+// parsePointer splits a JSON pointer (RFC 6901) into its unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%q is not a valid JSON pointer", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token. The token "-" refers to the end
+// of the array and is only allowed when adding.
+func arrayIndex(token string, length int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%q is not a valid array index", token)
+	}
+	max := length - 1
+	if adding {
+		max = length
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d is out of range", i)
+	}
+	return i, nil
+}
+
+// getValue returns the value at a JSON pointer
+func getValue(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := doc
+	for _, t := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("%s does not exist", pointer)
+			}
+			current = value
+		case []interface{}:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("%s does not exist", pointer)
+		}
+	}
+	return current, nil
+}
+
+// parentOf returns the container that a JSON pointer refers into and the
+// last token of the pointer
+func parentOf(doc interface{}, pointer string) (interface{}, string, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, "", err
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := getValue(doc, parentPointer)
+	if err != nil {
+		return nil, "", err
+	}
+	return parent, tokens[len(tokens)-1], nil
+}
+
+// addValue adds a value at a JSON pointer and returns the new document
+func addValue(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	if pointer == "" {
+		return value, nil
+	}
+	parent, token, err := parentOf(doc, pointer)
+	if err != nil {
+		return nil, err
+	}
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[token] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		updated := append(node[:i:i], append([]interface{}{value}, node[i:]...)...)
+		return setValue(doc, pointer[:strings.LastIndex(pointer, "/")], updated)
+	}
+	return nil, fmt.Errorf("can't add to %s", pointer)
+}
+
+// removeValue removes the value at a JSON pointer and returns the new
+// document and the removed value
+func removeValue(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	if pointer == "" {
+		return nil, doc, nil
+	}
+	parent, token, err := parentOf(doc, pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s does not exist", pointer)
+		}
+		delete(node, token)
+		return doc, value, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		updated := append(node[:i:i], node[i+1:]...)
+		doc, err = setValue(doc, pointer[:strings.LastIndex(pointer, "/")], updated)
+		return doc, value, err
+	}
+	return nil, nil, fmt.Errorf("%s does not exist", pointer)
+}
+
+// setValue replaces the value at a JSON pointer that is known to exist
+func setValue(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	if pointer == "" {
+		return value, nil
+	}
+	parent, token, err := parentOf(doc, pointer)
+	if err != nil {
+		return nil, err
+	}
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[token] = value
+	case []interface{}:
+		i, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	}
+	return doc, nil
+}
+
+// toJSONValue marshals an element into a generic JSON value
+func toJSONValue(el Element) (interface{}, error) {
+	b, err := json.Marshal(el)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal %T: %w", el, err)
+	}
+	return decodeJSONValue(b)
+}
+
+// decodeJSONValue decodes JSON into a generic value, keeping numbers as
+// they were written
+func decodeJSONValue(b []byte) (interface{}, error) {
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// replaceElement validates a patched document against the element's schema
+// and, if it is valid, replaces the element with it. The document is
+// unmarshalled into a new element so that removed fields are cleared.
+func replaceElement(el Element, doc interface{}) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("can't marshal the patched %T: %w", el, err)
+	}
+	target := reflect.ValueOf(el)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("can't patch %T: a non-nil pointer is required", el)
+	}
+	candidate, ok := reflect.New(target.Elem().Type()).Interface().(Element)
+	if !ok {
+		return fmt.Errorf("can't patch %T", el)
+	}
+	if err := candidate.ValidateAndUnmarshal(b); err != nil {
+		return err
+	}
+	target.Elem().Set(reflect.ValueOf(candidate).Elem())
+	return nil
+}
+
+// jsonEqual compares generic JSON values. Numbers are compared by value.
+func jsonEqual(a interface{}, b interface{}) bool {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			other, ok := bv[k]
+			if !ok || !jsonEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if av == bv {
+			return true
+		}
+		af, aErr := av.Float64()
+		bf, bErr := bv.Float64()
+		return aErr == nil && bErr == nil && af == bf
+	}
+	return a == b
+}
+
+// deepCopyJSON copies a generic JSON value so that patched documents don't
+// share objects or arrays
+func deepCopyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := map[string]interface{}{}
+		for k, child := range v {
+			copied[k] = deepCopyJSON(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, child := range v {
+			copied[i] = deepCopyJSON(child)
+		}
+		return copied
+	}
+	return value
+}
+
+// sortedKeys returns the keys of a JSON object in order, so that generated
+// patches are deterministic
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := []string{}
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,224 @@
+package feedlib_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name              string
+		patch             string
+		check             func(t *testing.T, it feedlib.Item)
+		wantErr           bool
+		wantValidationErr bool
+	}{
+		{
+			name:  "update a field",
+			patch: `{"tagline": "New tagline", "icon": {"title": "New icon title"}}`,
+			check: func(t *testing.T, it feedlib.Item) {
+				assert.Equal(t, "New tagline", it.Tagline)
+				assert.Equal(t, "New icon title", it.Icon.Title)
+				assert.Equal(t, feedlib.LogoURL, it.Icon.URL, "unpatched fields are kept")
+			},
+		},
+		{
+			name:  "remove an optional field",
+			patch: `{"users": null, "conversations": null}`,
+			check: func(t *testing.T, it feedlib.Item) {
+				assert.Nil(t, it.Users)
+				assert.Nil(t, it.Conversations)
+			},
+		},
+		{
+			name:              "remove a required field",
+			patch:             `{"id": null}`,
+			wantErr:           true,
+			wantValidationErr: true,
+		},
+		{
+			name:              "invalid enum value",
+			patch:             `{"status": "BOGUS"}`,
+			wantErr:           true,
+			wantValidationErr: true,
+		},
+		{
+			name:    "not JSON",
+			patch:   `{`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := feedtest.Item("item-1", 1)
+			original := it
+
+			err := feedlib.ApplyMergePatch(&it, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ApplyMergePatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				var vErr *feedlib.ValidationError
+				assert.Equal(t, tt.wantValidationErr, errors.As(err, &vErr))
+				assert.Equal(t, original, it, "the item should not change")
+				return
+			}
+			tt.check(t, it)
+		})
+	}
+}
+
+func TestCreateMergePatch(t *testing.T) {
+	original := feedtest.Item("item-1", 1)
+	modified := original
+	modified.Tagline = "New tagline"
+	modified.Users = nil
+	modified.Icon.Title = "New icon title"
+
+	patch, err := feedlib.CreateMergePatch(&original, &modified)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"tagline": "New tagline", "users": null, "icon": {"title": "New icon title"}}`,
+		string(patch))
+
+	patched := original
+	assert.Nil(t, feedlib.ApplyMergePatch(&patched, patch))
+	assert.Equal(t, modified.Tagline, patched.Tagline)
+	assert.Equal(t, modified.Icon, patched.Icon)
+	assert.Nil(t, patched.Users)
+
+	same, err := feedlib.CreateMergePatch(&original, &original)
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(same))
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name              string
+		patch             string
+		check             func(t *testing.T, nu feedlib.Nudge)
+		wantErr           bool
+		wantValidationErr bool
+	}{
+		{
+			name: "replace, add and remove",
+			patch: `[
+				{"op": "test", "path": "/sequenceNumber", "value": 1},
+				{"op": "replace", "path": "/title", "value": "New title"},
+				{"op": "add", "path": "/users/-", "value": "user-2"},
+				{"op": "add", "path": "/groups/0", "value": "group-0"},
+				{"op": "remove", "path": "/links/0"}
+			]`,
+			check: func(t *testing.T, nu feedlib.Nudge) {
+				assert.Equal(t, "New title", nu.Title)
+				assert.Equal(t, []string{"user-1", "user-2"}, nu.Users)
+				assert.Equal(t, []string{"group-0", "group-1"}, nu.Groups)
+				assert.Empty(t, nu.Links)
+			},
+		},
+		{
+			name: "move away a required field",
+			patch: `[
+				{"op": "copy", "from": "/users/0", "path": "/groups/-"},
+				{"op": "move", "from": "/title", "path": "/text"}
+			]`,
+			wantErr:           true,
+			wantValidationErr: true,
+		},
+		{
+			name: "copy",
+			patch: `[
+				{"op": "copy", "from": "/title", "path": "/text"}
+			]`,
+			check: func(t *testing.T, nu feedlib.Nudge) {
+				assert.Equal(t, nu.Title, nu.Text)
+			},
+		},
+		{
+			name:    "failed test",
+			patch:   `[{"op": "test", "path": "/title", "value": "something else"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "missing path",
+			patch:   `[{"op": "remove", "path": "/bogus"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "index out of range",
+			patch:   `[{"op": "replace", "path": "/users/5", "value": "user-5"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			patch:   `[{"op": "add", "path": "/title"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown operation",
+			patch:   `[{"op": "frobnicate", "path": "/title"}]`,
+			wantErr: true,
+		},
+		{
+			name:              "invalid result",
+			patch:             `[{"op": "replace", "path": "/visibility", "value": null}]`,
+			wantErr:           true,
+			wantValidationErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nu := feedtest.Nudge("nudge-1", 1)
+			original := nu
+
+			err := feedlib.ApplyJSONPatch(&nu, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ApplyJSONPatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				var vErr *feedlib.ValidationError
+				assert.Equal(t, tt.wantValidationErr, errors.As(err, &vErr))
+				assert.Equal(t, !tt.wantValidationErr, errors.Is(err, feedlib.ErrInvalidPatch))
+				assert.Equal(t, original, nu, "the nudge should not change")
+				return
+			}
+			tt.check(t, nu)
+		})
+	}
+}
+
+func TestCreateJSONPatch(t *testing.T) {
+	original := feedtest.Action("action-1", 1)
+	modified := original
+	modified.Name = "Renamed"
+	modified.Icon.Title = "New icon title"
+
+	patch, err := feedlib.CreateJSONPatch(&original, &modified)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[
+		{"op": "replace", "path": "/icon/title", "value": "New icon title"},
+		{"op": "replace", "path": "/name", "value": "Renamed"}
+	]`, string(patch))
+
+	patched := original
+	assert.Nil(t, feedlib.ApplyJSONPatch(&patched, patch))
+	assert.Equal(t, modified, patched)
+}
+
+func TestPatchOperation_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal([]feedlib.PatchOperation{
+		{Op: feedlib.PatchOpAdd, Path: "/a", Value: nil},
+		{Op: feedlib.PatchOpMove, From: "/a", Path: "/b"},
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[
+		{"op": "add", "path": "/a", "value": null},
+		{"op": "move", "from": "/a", "path": "/b"}
+	]`, string(b))
+}
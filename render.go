@@ -0,0 +1,959 @@
+package feedlib
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Ellipsis is appended to text that is truncated to fit a channel. It is
+// plain ASCII so that it doesn't change the encoding of an SMS.
+const Ellipsis = "..."
+
+// ChannelTextTypes is the text type that each channel can display. Messages
+// sent over a channel that is not listed are sent as they were written.
+var ChannelTextTypes = map[Channel]TextType{
+	ChannelEmail:    TextTypeHTML,
+	ChannelFcm:      TextTypePlain,
+	ChannelSms:      TextTypePlain,
+	ChannelWhatsapp: TextTypePlain,
+}
+
+// ChannelTextLimits is the maximum number of characters that a message sent
+// over each channel may have. Channels that are not listed are not limited.
+var ChannelTextLimits = map[Channel]int{
+	ChannelSms:      160,
+	ChannelWhatsapp: 4096,
+}
+
+// renderPolicy decides which elements are skipped and which link URLs are
+// kept when rich text is converted
+var renderPolicy = NewSanitizePolicy()
+
+// whitespacePattern matches runs of HTML whitespace
+var whitespacePattern = regexp.MustCompile(`[ \t\r\n\f]+`)
+
+// paragraphBreakPattern matches the blank lines between paragraphs
+var paragraphBreakPattern = regexp.MustCompile(`\n\s*\n`)
+
+// blankLinesPattern matches two or more blank lines
+var blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+// markdownTextEscaper escapes HTML text that would otherwise be read as
+// Markdown formatting
+var markdownTextEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`,
+)
+
+// markdownURLEscaper escapes the characters that would end a Markdown link
+// destination early
+var markdownURLEscaper = strings.NewReplacer(` `, `%20`, `(`, `%28`, `)`, `%29`)
+
+// ConvertText converts text from one text type to another. Links are kept:
+// in plain text they are written as "text (URL)". Lists are kept as "- "
+// and "1. " lines. Markup that can't be represented is dropped.
+//
+// Raw HTML in Markdown is removed when converting to plain text and escaped
+// when converting to HTML.
+func ConvertText(s string, from TextType, to TextType) (string, error) {
+	if !from.IsValid() {
+		return "", fmt.Errorf("%s is not a valid TextType", from)
+	}
+	if !to.IsValid() {
+		return "", fmt.Errorf("%s is not a valid TextType", to)
+	}
+	if from == to {
+		return s, nil
+	}
+
+	switch from {
+	case TextTypeHTML:
+		return htmlToText(s, to == TextTypeMarkdown), nil
+	case TextTypeMarkdown:
+		if to == TextTypeHTML {
+			return markdownToHTML(s), nil
+		}
+		return markdownToPlain(s), nil
+	default:
+		if to == TextTypeHTML {
+			return plainToHTML(s), nil
+		}
+		return plainToMarkdown(s), nil
+	}
+}
+
+// TruncateText shortens text to at most limit characters (not bytes),
+// ending it with an Ellipsis. Text is cut at a word boundary when that
+// doesn't lose much of it, and is never cut inside a URL. A limit that is
+// zero or less leaves the text as is.
+func TruncateText(s string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	if limit <= len(Ellipsis) {
+		return string(runes[:limit])
+	}
+
+	end := limit - len(Ellipsis)
+	wordStart := end
+	for wordStart > 0 && !unicode.IsSpace(runes[wordStart-1]) {
+		wordStart--
+	}
+	wordEnd := end
+	for wordEnd < len(runes) && !unicode.IsSpace(runes[wordEnd]) {
+		wordEnd++
+	}
+	if wordStart > 0 && (wordStart >= end*4/5 ||
+		strings.Contains(string(runes[wordStart:wordEnd]), "://")) {
+		end = wordStart
+	}
+	return strings.TrimRightFunc(string(runes[:end]), unicode.IsSpace) + Ellipsis
+}
+
+// RenderForChannel converts text to the text type that the channel can
+// display (see ChannelTextTypes) and truncates it to the channel's limit
+// (see ChannelTextLimits)
+func RenderForChannel(s string, from TextType, ch Channel) (string, error) {
+	if !ch.IsValid() {
+		return "", fmt.Errorf("%s is not a valid Channel", ch)
+	}
+	rendered := s
+	if to, ok := ChannelTextTypes[ch]; ok {
+		converted, err := ConvertText(s, from, to)
+		if err != nil {
+			return "", err
+		}
+		rendered = converted
+	}
+	return TruncateText(rendered, ChannelTextLimits[ch]), nil
+}
+
+// RenderText renders the item's text for a channel, as per its text type
+func (it Item) RenderText(ch Channel) (string, error) {
+	return RenderForChannel(it.Text, it.TextType, ch)
+}
+
+// RenderText renders the nudge's text for a channel. Nudge text is
+// Markdown.
+func (nu Nudge) RenderText(ch Channel) (string, error) {
+	return RenderForChannel(nu.Text, TextTypeMarkdown, ch)
+}
+
+// RenderText renders the message's text for a channel. Message text is
+// Markdown.
+func (msg Message) RenderText(ch Channel) (string, error) {
+	return RenderForChannel(msg.Text, TextTypeMarkdown, ch)
+}
+
+// listState tracks an HTML list that is being converted
+type listState struct {
+	ordered bool
+	index   int
+}
+
+// textConverter writes a parsed HTML fragment as Markdown or plain text
+type textConverter struct {
+	out      strings.Builder
+	markdown bool
+	lists    []listState
+	pre      int
+
+	// what the output ends with, so that it doesn't have to be inspected:
+	// whether anything other than spaces has been written, how many line
+	// breaks there are at the end (ignoring trailing spaces) and whether the
+	// last character is a space
+	written       bool
+	breaks        int
+	trailingSpace bool
+}
+
+// htmlToText converts an HTML fragment to Markdown or plain text
+func htmlToText(s string, markdown bool) string {
+	nodes, err := xhtml.ParseFragment(strings.NewReader(s), &xhtml.Node{
+		Type:     xhtml.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		// the parser only fails when reading fails, which a string reader
+		// does not do
+		return s
+	}
+	c := &textConverter{markdown: markdown}
+	for _, n := range nodes {
+		c.node(n)
+	}
+	return c.String()
+}
+
+// String returns the converted text with trailing spaces and extra blank
+// lines removed
+func (c *textConverter) String() string {
+	lines := strings.Split(c.out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func (c *textConverter) write(s string) {
+	if s == "" {
+		return
+	}
+	c.out.WriteString(s)
+
+	trimmed := strings.TrimRight(s, " ")
+	if trimmed == "" {
+		c.trailingSpace = true
+		return
+	}
+	breaks := len(trimmed) - len(strings.TrimRight(trimmed, "\n"))
+	if breaks == len(trimmed) && !c.trailingSpace {
+		c.breaks += breaks
+	} else {
+		c.breaks = breaks
+	}
+	c.written = true
+	c.trailingSpace = len(trimmed) < len(s)
+}
+
+func (c *textConverter) atLineStart() bool {
+	return !c.written || c.breaks > 0
+}
+
+// newline starts a new line unless we are already at the start of one
+func (c *textConverter) newline() {
+	if !c.atLineStart() {
+		c.write("\n")
+	}
+}
+
+// blankLine separates blocks e.g paragraphs
+func (c *textConverter) blankLine() {
+	if !c.written || c.breaks >= 2 {
+		return
+	}
+	if c.breaks == 1 {
+		c.write("\n")
+		return
+	}
+	c.write("\n\n")
+}
+
+// inline converts a node's children on their own e.g the text of a link
+func (c *textConverter) inline(n *xhtml.Node) string {
+	sub := &textConverter{markdown: c.markdown, pre: c.pre}
+	sub.children(n)
+	return sub.String()
+}
+
+func (c *textConverter) children(n *xhtml.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.node(child)
+	}
+}
+
+func (c *textConverter) node(n *xhtml.Node) {
+	switch n.Type {
+	case xhtml.TextNode:
+		c.text(n.Data)
+	case xhtml.ElementNode:
+		c.element(n)
+	case xhtml.DocumentNode:
+		c.children(n)
+	}
+}
+
+func (c *textConverter) text(s string) {
+	if c.pre > 0 {
+		c.write(s)
+		return
+	}
+	s = whitespacePattern.ReplaceAllString(s, " ")
+	if c.atLineStart() || c.trailingSpace {
+		s = strings.TrimLeft(s, " ")
+	}
+	if c.markdown {
+		s = markdownTextEscaper.Replace(s)
+	}
+	c.write(s)
+}
+
+// wrap writes a node's children between Markdown delimiters e.g **. Plain
+// text only gets the children.
+func (c *textConverter) wrap(n *xhtml.Node, delimiter string) {
+	if !c.markdown || c.pre > 0 {
+		c.children(n)
+		return
+	}
+	text := c.inline(n)
+	if text == "" {
+		return
+	}
+	c.write(delimiter + text + delimiter)
+}
+
+func (c *textConverter) element(n *xhtml.Node) {
+	if renderPolicy.isDropped(n.Data) {
+		return
+	}
+	switch n.Data {
+	case "head", "title", "meta", "link":
+		return
+	case "br":
+		if c.markdown && c.pre == 0 {
+			c.write("\\")
+		}
+		c.write("\n")
+	case "hr":
+		c.blankLine()
+		if c.markdown {
+			c.write("---")
+		}
+		c.blankLine()
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.blankLine()
+		if c.markdown {
+			level, _ := strconv.Atoi(n.Data[1:])
+			c.write(strings.Repeat("#", level) + " ")
+		}
+		c.children(n)
+		c.blankLine()
+	case "ul", "ol":
+		if len(c.lists) == 0 {
+			c.blankLine()
+		} else {
+			c.newline()
+		}
+		c.lists = append(c.lists, listState{ordered: n.Data == "ol"})
+		c.children(n)
+		c.lists = c.lists[:len(c.lists)-1]
+		if len(c.lists) == 0 {
+			c.blankLine()
+		} else {
+			c.newline()
+		}
+	case "li":
+		c.listItem(n)
+	case "tr":
+		c.newline()
+		c.children(n)
+		c.newline()
+	case "td", "th":
+		c.children(n)
+		c.write(" ")
+	case "a":
+		c.link(n, attribute(n, "href"), c.inline(n), false)
+	case "img":
+		c.link(n, attribute(n, "src"), strings.TrimSpace(attribute(n, "alt")), true)
+	case "strong", "b":
+		c.wrap(n, "**")
+	case "em", "i":
+		c.wrap(n, "_")
+	case "s", "del", "strike":
+		c.wrap(n, "~~")
+	case "code":
+		if c.markdown && c.pre == 0 {
+			c.write("`" + whitespacePattern.ReplaceAllString(textContent(n), " ") + "`")
+			return
+		}
+		c.children(n)
+	case "pre":
+		c.blankLine()
+		if c.markdown {
+			c.write("```\n")
+		}
+		c.pre++
+		c.children(n)
+		c.pre--
+		c.newline()
+		if c.markdown {
+			c.write("```")
+		}
+		c.blankLine()
+	case "blockquote":
+		c.blankLine()
+		quoted := c.inline(n)
+		if c.markdown {
+			quoted = "> " + strings.ReplaceAll(quoted, "\n", "\n> ")
+		}
+		c.write(quoted)
+		c.blankLine()
+	case "p", "div", "section", "article", "header", "footer", "aside", "nav",
+		"main", "figure", "figcaption", "table", "thead", "tbody", "tfoot",
+		"dl", "dt", "dd", "address":
+		c.blankLine()
+		c.children(n)
+		c.blankLine()
+	default:
+		c.children(n)
+	}
+}
+
+// listItem writes a list item with its marker, indented as per its nesting
+func (c *textConverter) listItem(n *xhtml.Node) {
+	c.newline()
+	marker := "- "
+	depth := len(c.lists)
+	if depth > 0 {
+		list := &c.lists[depth-1]
+		if list.ordered {
+			list.index++
+			marker = fmt.Sprintf("%d. ", list.index)
+		}
+		indent := "  "
+		if c.markdown {
+			indent = "    "
+		}
+		c.write(strings.Repeat(indent, depth-1))
+	}
+	c.write(marker)
+	c.children(n)
+	c.newline()
+}
+
+// link writes a link or image. Links whose URLs are not allowed by the
+// sanitisation policy are written as their text only.
+func (c *textConverter) link(n *xhtml.Node, href string, text string, image bool) {
+	href = strings.TrimSpace(href)
+	if href == "" || !renderPolicy.isAllowedURL(href) {
+		c.write(text)
+		return
+	}
+	if c.markdown {
+		prefix := ""
+		if image {
+			prefix = "!"
+		}
+		if text == "" && !image {
+			text = markdownTextEscaper.Replace(href)
+		}
+		c.write(fmt.Sprintf("%s[%s](%s)", prefix, text, markdownURLEscaper.Replace(href)))
+		return
+	}
+	c.write(plainLink(text, href))
+}
+
+// plainLink writes a link as plain text e.g "text (URL)"
+func plainLink(text string, href string) string {
+	if text == "" || text == href || "mailto:"+text == href || "tel:"+text == href {
+		return href
+	}
+	return text + " (" + href + ")"
+}
+
+// attribute returns the value of a node's attribute
+func attribute(n *xhtml.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// textContent returns all the text inside a node
+func textContent(n *xhtml.Node) string {
+	if n.Type == xhtml.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
+
+// patterns that recognise Markdown blocks
+var (
+	mdFencePattern      = regexp.MustCompile("^ {0,3}(```|~~~)")
+	mdRulePattern       = regexp.MustCompile(`^ {0,3}([-*_])( *[-*_]){2,} *$`)
+	mdHeadingPattern    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:\s+(.*?))?(?:\s+#+)?\s*$`)
+	mdQuotePattern      = regexp.MustCompile(`^ {0,3}> ?`)
+	mdBulletPattern     = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	mdOrderedPattern    = regexp.MustCompile(`^(\s*)(\d{1,9})[.)]\s+(.*)$`)
+	mdReferencePattern  = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:\s*<?([^\s>]*)>?(?:\s+["'(].*["')])?\s*$`)
+	mdHardBreakPattern  = regexp.MustCompile(`(\\| {2,})$`)
+	mdPlaceholderMarker = regexp.MustCompile("\x00([0-9]+)\x00")
+)
+
+// patterns that recognise inline Markdown
+var (
+	mdCodeSpanPattern = regexp.MustCompile("`([^`]+)`")
+	mdEscapePattern   = regexp.MustCompile(`\\([!-/:-@\[-` + "`" + `{-~])`)
+	mdRefLinkPattern  = regexp.MustCompile(`(!?)\[([^\]]+)\]\[([^\]]*)\]`)
+	mdAutolinkPattern = regexp.MustCompile(
+		`<((?:[A-Za-z][A-Za-z0-9+.\-]*:[^\s<>]+)|(?:[^\s@<>]+@[^\s@<>]+\.[^\s@<>]+))>`)
+	mdTagPattern          = regexp.MustCompile(`</?[A-Za-z][A-Za-z0-9\-]*(?:\s[^<>]*)?/?>`)
+	mdBreakTagPattern     = regexp.MustCompile(`(?i)<br\s*/?>`)
+	mdStrongPattern       = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	mdStarEmPattern       = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`)
+	mdUnderscoreEmPattern = regexp.MustCompile(`(^|[^\pL\pN_])_(\S(?:[^_]*?\S)?)_([^\pL\pN_]|$)`)
+	mdStrikePattern       = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+)
+
+// replaceInlineLinks replaces the inline Markdown links, or images, in s
+// e.g [text](https://example.com "title") with what replace returns for
+// their text and destination.
+//
+// As in CommonMark, a destination that is not between angle brackets may
+// contain balanced parentheses e.g https://example.com/(foo).
+func replaceInlineLinks(
+	s string, image bool, replace func(text string, href string) string) string {
+	opener := "["
+	if image {
+		opener = "!["
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(s, opener)
+		if start < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		text, href, end, ok := parseInlineLink(s, start+len(opener), image)
+		if !ok {
+			b.WriteString(s[:start+len(opener)])
+			s = s[start+len(opener):]
+			continue
+		}
+		b.WriteString(s[:start])
+		b.WriteString(replace(text, href))
+		s = s[end:]
+	}
+}
+
+// parseInlineLink parses the rest of an inline link that starts at i, just
+// after its opening bracket. It returns the link's text and destination and
+// the index just after the link.
+func parseInlineLink(s string, i int, image bool) (string, string, int, bool) {
+	textEnd := strings.IndexByte(s[i:], ']')
+	if textEnd < 0 || (textEnd == 0 && !image) {
+		return "", "", 0, false
+	}
+	text := s[i : i+textEnd]
+	i += textEnd + 1
+	if i >= len(s) || s[i] != '(' {
+		return "", "", 0, false
+	}
+	i = skipSpaces(s, i+1)
+
+	var href string
+	if i < len(s) && s[i] == '<' {
+		destEnd := strings.IndexAny(s[i+1:], "<>\n")
+		if destEnd < 0 || s[i+1+destEnd] != '>' {
+			return "", "", 0, false
+		}
+		href = s[i+1 : i+1+destEnd]
+		i += destEnd + 2
+	} else {
+		start, depth := i, 0
+	dest:
+		for ; i < len(s); i++ {
+			switch s[i] {
+			case ' ', '\t', '\n':
+				break dest
+			case '(':
+				depth++
+			case ')':
+				if depth == 0 {
+					break dest
+				}
+				depth--
+			}
+		}
+		if depth != 0 {
+			return "", "", 0, false
+		}
+		href = s[start:i]
+	}
+
+	// an optional title, which is not used
+	if titleStart := skipSpaces(s, i); titleStart > i && titleStart < len(s) && s[titleStart] == '"' {
+		titleEnd := strings.IndexByte(s[titleStart+1:], '"')
+		if titleEnd < 0 {
+			return "", "", 0, false
+		}
+		i = titleStart + titleEnd + 2
+	}
+	i = skipSpaces(s, i)
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return text, href, i + 1, true
+}
+
+// skipSpaces returns the index of the first character at or after i that is
+// not a space or tab
+func skipSpaces(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// placeholders stand in for text that later replacements must not touch
+// e.g code spans
+type placeholders []string
+
+func (p *placeholders) add(s string) string {
+	*p = append(*p, s)
+	return fmt.Sprintf("\x00%d\x00", len(*p)-1)
+}
+
+func (p placeholders) restore(s string) string {
+	return mdPlaceholderMarker.ReplaceAllStringFunc(s, func(m string) string {
+		i, err := strconv.Atoi(m[1 : len(m)-1])
+		if err != nil || i >= len(p) {
+			return ""
+		}
+		return p[i]
+	})
+}
+
+// markdownLines splits Markdown into lines and pulls out its link reference
+// definitions, keyed by their lower case labels
+func markdownLines(s string) ([]string, map[string]string) {
+	s = strings.ReplaceAll(s, "\x00", "")
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+	lines := []string{}
+	refs := map[string]string{}
+	fenced := false
+	for _, line := range strings.Split(s, "\n") {
+		if mdFencePattern.MatchString(line) {
+			fenced = !fenced
+		}
+		if !fenced {
+			if m := mdReferencePattern.FindStringSubmatch(line); m != nil {
+				refs[strings.ToLower(m[1])] = m[2]
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, refs
+}
+
+// referenceURL looks up the URL of a reference link. An empty label means
+// that the link text is the label.
+func referenceURL(refs map[string]string, text string, label string) (string, bool) {
+	if label == "" {
+		label = text
+	}
+	url, ok := refs[strings.ToLower(label)]
+	return url, ok
+}
+
+// markdownToPlain converts Markdown to plain text
+func markdownToPlain(s string) string {
+	lines, refs := markdownLines(s)
+	out := []string{}
+	fenced := false
+	for _, line := range lines {
+		if mdFencePattern.MatchString(line) {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			out = append(out, line)
+			continue
+		}
+		if mdRulePattern.MatchString(line) {
+			out = append(out, "")
+			continue
+		}
+		line = mdQuotePattern.ReplaceAllString(line, "")
+		if m := mdHeadingPattern.FindStringSubmatch(line); m != nil {
+			line = m[2]
+		}
+		line = mdHardBreakPattern.ReplaceAllString(line, "")
+		if m := mdBulletPattern.FindStringSubmatch(line); m != nil {
+			line = m[1] + "- " + inlineMarkdownToPlain(m[2], refs)
+		} else if m := mdOrderedPattern.FindStringSubmatch(line); m != nil {
+			line = m[1] + m[2] + ". " + inlineMarkdownToPlain(m[3], refs)
+		} else {
+			line = inlineMarkdownToPlain(line, refs)
+		}
+		out = append(out, strings.TrimRight(line, " \t"))
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(out, "\n"), "\n\n"))
+}
+
+// inlineMarkdownToPlain removes inline Markdown formatting from a line and
+// writes its links as "text (URL)"
+func inlineMarkdownToPlain(s string, refs map[string]string) string {
+	var p placeholders
+	s = mdCodeSpanPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return p.add(mdCodeSpanPattern.FindStringSubmatch(m)[1])
+	})
+	s = mdEscapePattern.ReplaceAllStringFunc(s, func(m string) string {
+		return p.add(m[1:])
+	})
+	s = replaceInlineLinks(s, true, func(text string, href string) string {
+		return p.add(plainLink(text, href))
+	})
+	s = replaceInlineLinks(s, false, func(text string, href string) string {
+		return plainLinkText(&p, text, href)
+	})
+	s = mdRefLinkPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := mdRefLinkPattern.FindStringSubmatch(m)
+		url, _ := referenceURL(refs, sub[2], sub[3])
+		return plainLinkText(&p, sub[2], url)
+	})
+	s = mdAutolinkPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return p.add(m[1 : len(m)-1])
+	})
+	s = mdBreakTagPattern.ReplaceAllString(s, " ")
+	s = mdTagPattern.ReplaceAllString(s, "")
+	s = mdStrongPattern.ReplaceAllString(s, "$2")
+	s = mdStrikePattern.ReplaceAllString(s, "$1")
+	s = mdStarEmPattern.ReplaceAllString(s, "$1")
+	s = mdUnderscoreEmPattern.ReplaceAllString(s, "$1$2$3")
+	return p.restore(html.UnescapeString(s))
+}
+
+// plainLinkText replaces a Markdown link with its text followed by its URL.
+// The text is left in place so that it is formatted with the rest of the line.
+func plainLinkText(p *placeholders, text string, href string) string {
+	if href == "" {
+		return text
+	}
+	if plainLink(text, href) == href {
+		return p.add(href)
+	}
+	return text + p.add(" ("+href+")")
+}
+
+// markdownList tracks a Markdown list that is being converted to HTML
+type markdownList struct {
+	tag    string
+	indent int
+}
+
+// markdownToHTML converts Markdown to an HTML fragment
+func markdownToHTML(s string) string {
+	lines, refs := markdownLines(s)
+	var b strings.Builder
+	paragraph := []string{}
+	lists := []markdownList{}
+	quote := []string{}
+	code := []string{}
+	fenced := false
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + strings.Join(paragraph, "\n") + "</p>\n")
+			paragraph = []string{}
+		}
+	}
+	flushQuote := func() {
+		if len(quote) > 0 {
+			b.WriteString("<blockquote>\n" + markdownToHTML(strings.Join(quote, "\n")) + "\n</blockquote>\n")
+			quote = []string{}
+		}
+	}
+	closeLists := func(indent int) {
+		for len(lists) > 0 && lists[len(lists)-1].indent >= indent {
+			b.WriteString("</li>\n</" + lists[len(lists)-1].tag + ">\n")
+			lists = lists[:len(lists)-1]
+		}
+	}
+
+	for _, line := range lines {
+		if fenced {
+			if mdFencePattern.MatchString(line) {
+				fenced = false
+				b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+				code = []string{}
+				continue
+			}
+			code = append(code, line)
+			continue
+		}
+		if mdQuotePattern.MatchString(line) {
+			flushParagraph()
+			closeLists(0)
+			quote = append(quote, mdQuotePattern.ReplaceAllString(line, ""))
+			continue
+		}
+		flushQuote()
+
+		if strings.TrimSpace(line) == "" {
+			flushParagraph()
+			continue
+		}
+		if mdFencePattern.MatchString(line) {
+			flushParagraph()
+			closeLists(0)
+			fenced = true
+			continue
+		}
+		if mdRulePattern.MatchString(line) {
+			flushParagraph()
+			closeLists(0)
+			b.WriteString("<hr>\n")
+			continue
+		}
+		if m := mdHeadingPattern.FindStringSubmatch(line); m != nil {
+			flushParagraph()
+			closeLists(0)
+			tag := fmt.Sprintf("h%d", len(m[1]))
+			b.WriteString("<" + tag + ">" + inlineMarkdownToHTML(m[2], refs) + "</" + tag + ">\n")
+			continue
+		}
+
+		tag, indent, content := "", 0, ""
+		if m := mdBulletPattern.FindStringSubmatch(line); m != nil {
+			tag, indent, content = "ul", len(m[1]), m[2]
+		} else if m := mdOrderedPattern.FindStringSubmatch(line); m != nil {
+			tag, indent, content = "ol", len(m[1]), m[3]
+		}
+		if tag != "" {
+			flushParagraph()
+			closeLists(indent + 1)
+			top := len(lists) - 1
+			switch {
+			case top >= 0 && lists[top].indent == indent && lists[top].tag == tag:
+				b.WriteString("</li>\n<li>")
+			case top >= 0 && lists[top].indent == indent:
+				closeLists(indent)
+				lists = append(lists, markdownList{tag: tag, indent: indent})
+				b.WriteString("<" + tag + ">\n<li>")
+			default:
+				lists = append(lists, markdownList{tag: tag, indent: indent})
+				b.WriteString("<" + tag + ">\n<li>")
+			}
+			b.WriteString(inlineMarkdownToHTML(content, refs))
+			continue
+		}
+
+		if len(lists) > 0 && line != strings.TrimLeft(line, " \t") {
+			// an indented line continues the list item
+			b.WriteString("\n" + inlineMarkdownToHTML(strings.TrimSpace(line), refs))
+			continue
+		}
+		closeLists(0)
+		paragraph = append(paragraph, inlineMarkdownLineToHTML(line, refs))
+	}
+	if fenced {
+		b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+	}
+	flushParagraph()
+	flushQuote()
+	closeLists(0)
+	return strings.TrimSpace(b.String())
+}
+
+// inlineMarkdownLineToHTML converts a line of a paragraph, keeping hard
+// line breaks
+func inlineMarkdownLineToHTML(line string, refs map[string]string) string {
+	trimmed := strings.TrimSpace(line)
+	if mdHardBreakPattern.MatchString(line) {
+		return inlineMarkdownToHTML(strings.TrimSuffix(trimmed, `\`), refs) + "<br>"
+	}
+	return inlineMarkdownToHTML(trimmed, refs)
+}
+
+// htmlLink writes a link or image. Links whose URLs are not allowed by the
+// sanitisation policy are written as their text only.
+func htmlLink(text string, href string, image bool) string {
+	if href == "" || !renderPolicy.isAllowedURL(href) {
+		return text
+	}
+	if image {
+		return fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(href), text)
+	}
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), text)
+}
+
+// htmlLinkText replaces a Markdown link with HTML tags around its text. The
+// text is left in place so that it is formatted with the rest of the line.
+func htmlLinkText(p *placeholders, text string, href string) string {
+	if href == "" || !renderPolicy.isAllowedURL(href) {
+		return text
+	}
+	return p.add(fmt.Sprintf(`<a href="%s">`, html.EscapeString(href))) + text + p.add("</a>")
+}
+
+// inlineMarkdownToHTML converts inline Markdown formatting to HTML. Raw
+// HTML is escaped.
+func inlineMarkdownToHTML(s string, refs map[string]string) string {
+	var p placeholders
+	s = mdCodeSpanPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return p.add("<code>" + html.EscapeString(mdCodeSpanPattern.FindStringSubmatch(m)[1]) + "</code>")
+	})
+	s = mdEscapePattern.ReplaceAllStringFunc(s, func(m string) string {
+		return p.add(html.EscapeString(m[1:]))
+	})
+	s = replaceInlineLinks(s, true, func(text string, href string) string {
+		return p.add(htmlLink(html.EscapeString(text), href, true))
+	})
+	s = replaceInlineLinks(s, false, func(text string, href string) string {
+		return htmlLinkText(&p, text, href)
+	})
+	s = mdRefLinkPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := mdRefLinkPattern.FindStringSubmatch(m)
+		url, ok := referenceURL(refs, sub[2], sub[3])
+		if !ok {
+			return p.add(html.EscapeString(m))
+		}
+		if sub[1] == "!" {
+			return p.add(htmlLink(html.EscapeString(sub[2]), url, true))
+		}
+		return htmlLinkText(&p, sub[2], url)
+	})
+	s = mdAutolinkPattern.ReplaceAllStringFunc(s, func(m string) string {
+		dest := m[1 : len(m)-1]
+		href := dest
+		if isEmailAutolink(dest) && !strings.Contains(dest, ":") {
+			href = "mailto:" + dest
+		}
+		return p.add(htmlLink(html.EscapeString(dest), href, false))
+	})
+	s = html.EscapeString(s)
+	s = mdStrongPattern.ReplaceAllString(s, "<strong>$2</strong>")
+	s = mdStrikePattern.ReplaceAllString(s, "<del>$1</del>")
+	s = mdStarEmPattern.ReplaceAllString(s, "<em>$1</em>")
+	s = mdUnderscoreEmPattern.ReplaceAllString(s, "$1<em>$2</em>$3")
+	return p.restore(s)
+}
+
+// plainToHTML converts plain text to HTML paragraphs, keeping line breaks
+func plainToHTML(s string) string {
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+	paragraphs := []string{}
+	for _, para := range paragraphBreakPattern.Split(strings.TrimSpace(s), -1) {
+		if para == "" {
+			continue
+		}
+		lines := strings.Split(para, "\n")
+		for i, line := range lines {
+			lines[i] = html.EscapeString(line)
+		}
+		paragraphs = append(paragraphs, "<p>"+strings.Join(lines, "<br>\n")+"</p>")
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+// plainToMarkdown escapes plain text so that it is rendered literally,
+// keeping line breaks
+func plainToMarkdown(s string) string {
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		lines[i] = markdownEscaper.Replace(line)
+		if i < len(lines)-1 && strings.TrimSpace(line) != "" &&
+			strings.TrimSpace(lines[i+1]) != "" {
+			lines[i] += `\`
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,251 @@
+package feedlib_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertText(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		from    feedlib.TextType
+		to      feedlib.TextType
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "HTML to plain text",
+			in: `<h1>Diabetes</h1><p>Take <strong>2</strong> tablets &amp; ` +
+				`<a href="https://example.com/read">read more</a>.</p>` +
+				`<ul><li>eat well</li><li>exercise<ol><li>walk</li><li>swim</li></ol></li></ul>` +
+				`<script>alert(1)</script><p>Call<br><a href="tel:0700000000">0700000000</a></p>`,
+			from: feedlib.TextTypeHTML,
+			to:   feedlib.TextTypePlain,
+			want: "Diabetes\n\nTake 2 tablets & read more (https://example.com/read).\n\n" +
+				"- eat well\n- exercise\n  1. walk\n  2. swim\n\nCall\ntel:0700000000",
+		},
+		{
+			name: "unsafe links are written as text",
+			in:   `<a href="javascript:alert(1)">click</a> <img src="/a.png" alt="chart">`,
+			from: feedlib.TextTypeHTML,
+			to:   feedlib.TextTypePlain,
+			want: "click chart (/a.png)",
+		},
+		{
+			name: "HTML to Markdown",
+			in: `<h2>Tips</h2><p><em>Drink</em> water_daily, see <a href="https://example.com/a b">this</a></p>` +
+				`<ol><li>one</li><li>two</li></ol><blockquote><p>quoted</p></blockquote><pre>a  b</pre>`,
+			from: feedlib.TextTypeHTML,
+			to:   feedlib.TextTypeMarkdown,
+			want: "## Tips\n\n_Drink_ water\\_daily, see [this](https://example.com/a%20b)\n\n" +
+				"1. one\n2. two\n\n> quoted\n\n```\na  b\n```",
+		},
+		{
+			name: "Markdown to plain text",
+			in: "# Diabetes\n\nTake **2** _tablets_ & `a*b` \\*daily\\*, see [the site](https://example.com/a_b) " +
+				"or <https://example.org> or [the guide][1].\n\n* eat well\n+ exercise\n  1) walk\n\n" +
+				"> quoted <b>text</b>\n\n[1]: https://example.com/guide\n",
+			from: feedlib.TextTypeMarkdown,
+			to:   feedlib.TextTypePlain,
+			want: "Diabetes\n\nTake 2 tablets & a*b *daily*, see the site (https://example.com/a_b) " +
+				"or https://example.org or the guide (https://example.com/guide).\n\n" +
+				"- eat well\n- exercise\n  1. walk\n\nquoted text",
+		},
+		{
+			name: "Markdown to HTML",
+			in: "## Tips\n\n**Drink** <b>water</b>, see [the *site*](https://example.com/?a=1&b=2) " +
+				"[bad](javascript:x)\n\n- one\n- two\n\n```\n<code>\n```\n",
+			from: feedlib.TextTypeMarkdown,
+			to:   feedlib.TextTypeHTML,
+			want: "<h2>Tips</h2>\n<p><strong>Drink</strong> &lt;b&gt;water&lt;/b&gt;, see " +
+				`<a href="https://example.com/?a=1&amp;b=2">the <em>site</em></a> bad</p>` + "\n" +
+				"<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n<pre><code>&lt;code&gt;</code></pre>",
+		},
+		{
+			name: "Markdown link destinations with balanced parentheses",
+			in: "[a](https://x.com/(foo)) and ![b](/img/(1).png \"title\") " +
+				"[c](<https://x.com/a b>) [d](https://x.com/(open",
+			from: feedlib.TextTypeMarkdown,
+			to:   feedlib.TextTypeHTML,
+			want: `<p><a href="https://x.com/(foo)">a</a> and <img src="/img/(1).png" alt="b"> ` +
+				`<a href="https://x.com/a b">c</a> [d](https://x.com/(open</p>`,
+		},
+		{
+			name: "Markdown link destinations with balanced parentheses to plain text",
+			in:   "see [a](https://x.com/(foo)) now",
+			from: feedlib.TextTypeMarkdown,
+			to:   feedlib.TextTypePlain,
+			want: "see a (https://x.com/(foo)) now",
+		},
+		{
+			name: "plain text to HTML",
+			in:   "a < b\nc\n\nnew paragraph",
+			from: feedlib.TextTypePlain,
+			to:   feedlib.TextTypeHTML,
+			want: "<p>a &lt; b<br>\nc</p>\n<p>new paragraph</p>",
+		},
+		{
+			name: "plain text to Markdown",
+			in:   "2 * 3\nis 6",
+			from: feedlib.TextTypePlain,
+			to:   feedlib.TextTypeMarkdown,
+			want: "2 \\* 3\\\nis 6",
+		},
+		{
+			name: "same type",
+			in:   "<p>unchanged</p>",
+			from: feedlib.TextTypeHTML,
+			to:   feedlib.TextTypeHTML,
+			want: "<p>unchanged</p>",
+		},
+		{
+			name:    "invalid text type",
+			in:      "text",
+			from:    feedlib.TextType("RTF"),
+			to:      feedlib.TextTypePlain,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := feedlib.ConvertText(tt.in, tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ConvertText() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConvertText_LongHTML(t *testing.T) {
+	long := strings.Repeat("<p>word <b>bold</b></p><ul><li>item</li></ul>", 20000)
+	got, err := feedlib.ConvertText(long, feedlib.TextTypeHTML, feedlib.TextTypePlain)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.TrimSpace(strings.Repeat("word bold\n\n- item\n\n", 20000)), got)
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		limit int
+		want  string
+	}{
+		{
+			name:  "short enough",
+			in:    "Your results are ready",
+			limit: 22,
+			want:  "Your results are ready",
+		},
+		{
+			name:  "cut at a word boundary",
+			in:    "Your results are ready for collection",
+			limit: 20,
+			want:  "Your results are...",
+		},
+		{
+			name:  "URLs are not cut",
+			in:    "Read your results at https://example.com/results/123456789 today",
+			limit: 45,
+			want:  "Read your results at...",
+		},
+		{
+			name:  "characters are not bytes",
+			in:    "Habari yako? Karibu 😀😀😀😀😀😀",
+			limit: 22,
+			want:  "Habari yako? Karibu...",
+		},
+		{
+			name:  "tiny limit",
+			in:    "Hello",
+			limit: 2,
+			want:  "He",
+		},
+		{
+			name:  "no limit",
+			in:    "Hello",
+			limit: 0,
+			want:  "Hello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := feedlib.TruncateText(tt.in, tt.limit)
+			assert.Equal(t, tt.want, got)
+			if tt.limit > 0 {
+				assert.LessOrEqual(t, utf8.RuneCountInString(got), tt.limit)
+			}
+		})
+	}
+}
+
+func TestRenderForChannel(t *testing.T) {
+	long := "<p>" + strings.Repeat("word ", 100) + "</p>"
+
+	sms, err := feedlib.RenderForChannel(long, feedlib.TextTypeHTML, feedlib.ChannelSms)
+	assert.Nil(t, err)
+	assert.Len(t, sms, 157)
+	assert.True(t, strings.HasSuffix(sms, feedlib.Ellipsis))
+	assert.NotContains(t, sms, "<p>")
+
+	email, err := feedlib.RenderForChannel(long, feedlib.TextTypeHTML, feedlib.ChannelEmail)
+	assert.Nil(t, err)
+	assert.Equal(t, long, email, "email can display HTML of any length")
+
+	_, err = feedlib.RenderForChannel(long, feedlib.TextTypeHTML, feedlib.Channel("PIGEON"))
+	assert.NotNil(t, err)
+}
+
+func TestElement_RenderText(t *testing.T) {
+	it := feedtest.Item("item-1", 1)
+	it.TextType = feedlib.TextTypeHTML
+	it.Text = `<p>See <a href="https://example.com">the clinic</a></p>`
+	got, err := it.RenderText(feedlib.ChannelWhatsapp)
+	assert.Nil(t, err)
+	assert.Equal(t, "See the clinic (https://example.com)", got)
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.Text = "**Set** a PIN"
+	got, err = nu.RenderText(feedlib.ChannelSms)
+	assert.Nil(t, err)
+	assert.Equal(t, "Set a PIN", got)
+
+	msg := it.Conversations[0]
+	msg.Text = "_thanks_"
+	got, err = msg.RenderText(feedlib.ChannelFcm)
+	assert.Nil(t, err)
+	assert.Equal(t, "thanks", got)
+}
+
+func TestDispatcher_RendersForChannel(t *testing.T) {
+	sms := feedlib.NewMemoryNotifier(nil)
+	email := feedlib.NewMemoryNotifier(nil)
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{
+		feedlib.ChannelSms:   sms,
+		feedlib.ChannelEmail: email,
+	})
+
+	it := feedtest.Item("item-1", 1)
+	it.TextType = feedlib.TextTypeMarkdown
+	it.Users = []string{"user-1"}
+	it.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms, feedlib.ChannelEmail}
+	it.NotificationBody = feedlib.NotificationBody{
+		PublishMessage: "**New:** [{{title}}](https://example.com/items/1)",
+	}
+
+	_, err := d.DispatchItem(context.Background(), it, feedlib.NotificationTypePublish)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		"New: "+it.Tagline+" (https://example.com/items/1)", sms.Sent()[0].Message)
+	assert.Equal(t,
+		`<p><strong>New:</strong> <a href="https://example.com/items/1">`+it.Tagline+`</a></p>`,
+		email.Sent()[0].Message, "email is sent as HTML")
+}
@@ -0,0 +1,492 @@
+package feedlib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FeedRepository stores the actions, nudges and items in users' feeds.
+//
+// Every element is scoped to a user and a feed flavour, so the same element ID
+// can be used in different users' feeds. Implementations must be safe for
+// concurrent use and must wrap ErrElementNotFound when an element that is
+// looked up or deleted does not exist.
+type FeedRepository interface {
+	// GetAction returns a global action from a user's feed
+	GetAction(ctx context.Context, uid string, flavour Flavour, id string) (*Action, error)
+
+	// PutAction creates or replaces a global action in a user's feed
+	PutAction(ctx context.Context, uid string, flavour Flavour, action Action) error
+
+	// DeleteAction removes a global action from a user's feed
+	DeleteAction(ctx context.Context, uid string, flavour Flavour, id string) error
+
+	// ListActions returns the global actions in a user's feed, ordered by
+	// sequence number
+	ListActions(ctx context.Context, uid string, flavour Flavour) ([]Action, error)
+
+	// GetNudge returns a nudge from a user's feed
+	GetNudge(ctx context.Context, uid string, flavour Flavour, id string) (*Nudge, error)
+
+	// PutNudge creates or replaces a nudge in a user's feed
+	PutNudge(ctx context.Context, uid string, flavour Flavour, nudge Nudge) error
+
+	// DeleteNudge removes a nudge from a user's feed
+	DeleteNudge(ctx context.Context, uid string, flavour Flavour, id string) error
+
+	// ListNudges returns the nudges in a user's feed that match the filter,
+	// ordered by sequence number. A nil filter matches every nudge and an
+	// invalid filter is an error.
+	ListNudges(ctx context.Context, uid string, flavour Flavour, filter *FeedFilter) ([]Nudge, error)
+
+	// GetItem returns an item from a user's feed
+	GetItem(ctx context.Context, uid string, flavour Flavour, id string) (*Item, error)
+
+	// PutItem creates or replaces an item in a user's feed
+	PutItem(ctx context.Context, uid string, flavour Flavour, item Item) error
+
+	// DeleteItem removes an item from a user's feed
+	DeleteItem(ctx context.Context, uid string, flavour Flavour, id string) error
+
+	// ListItems returns the items in a user's feed that match the filter,
+	// ordered by sequence number. A nil filter matches every item and an
+	// invalid filter is an error.
+	ListItems(ctx context.Context, uid string, flavour Flavour, filter *FeedFilter) ([]Item, error)
+}
+
+// feedKey identifies a single user's feed of a given flavour
+type feedKey struct {
+	uid     string
+	flavour Flavour
+}
+
+// memoryFeed holds the elements of a single feed in a MemoryFeedRepository
+type memoryFeed struct {
+	actions map[string]Action
+	nudges  map[string]Nudge
+	items   map[string]Item
+}
+
+// MemoryFeedRepository is a goroutine-safe, in-memory FeedRepository.
+//
+// It is meant for tests and as a reference implementation. Elements are
+// copied on the way in and out so callers can't mutate stored elements.
+type MemoryFeedRepository struct {
+	mu    sync.RWMutex
+	feeds map[feedKey]*memoryFeed
+}
+
+// NewMemoryFeedRepository initializes an empty in-memory feed repository
+func NewMemoryFeedRepository() *MemoryFeedRepository {
+	return &MemoryFeedRepository{
+		feeds: map[feedKey]*memoryFeed{},
+	}
+}
+
+// checkPreconditions makes sure that a feed can be addressed and that the
+// context has not been cancelled
+func checkPreconditions(ctx context.Context, uid string, flavour Flavour) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if uid == "" {
+		return fmt.Errorf("a user ID is required")
+	}
+	if !flavour.IsValid() {
+		return fmt.Errorf("%s is not a valid Flavour", flavour)
+	}
+	return nil
+}
+
+// feed returns the feed for the supplied key, creating it if asked to.
+// The caller must hold the lock.
+func (r *MemoryFeedRepository) feed(uid string, flavour Flavour, create bool) *memoryFeed {
+	key := feedKey{uid: uid, flavour: flavour}
+	fe, ok := r.feeds[key]
+	if !ok && create {
+		fe = &memoryFeed{
+			actions: map[string]Action{},
+			nudges:  map[string]Nudge{},
+			items:   map[string]Item{},
+		}
+		r.feeds[key] = fe
+	}
+	return fe
+}
+
+// GetAction returns a global action from a user's feed
+func (r *MemoryFeedRepository) GetAction(
+	ctx context.Context, uid string, flavour Flavour, id string) (*Action, error) {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	fe := r.feed(uid, flavour, false)
+	if fe == nil {
+		return nil, fmt.Errorf("action %s: %w", id, ErrElementNotFound)
+	}
+	ac, ok := fe.actions[id]
+	if !ok {
+		return nil, fmt.Errorf("action %s: %w", id, ErrElementNotFound)
+	}
+	ac = copyAction(ac)
+	return &ac, nil
+}
+
+// PutAction creates or replaces a global action in a user's feed
+func (r *MemoryFeedRepository) PutAction(
+	ctx context.Context, uid string, flavour Flavour, action Action) error {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return err
+	}
+	if action.ID == "" {
+		return fmt.Errorf("an action ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.feed(uid, flavour, true).actions[action.ID] = copyAction(action)
+	return nil
+}
+
+// DeleteAction removes a global action from a user's feed
+func (r *MemoryFeedRepository) DeleteAction(
+	ctx context.Context, uid string, flavour Flavour, id string) error {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fe := r.feed(uid, flavour, false)
+	if fe == nil {
+		return fmt.Errorf("action %s: %w", id, ErrElementNotFound)
+	}
+	if _, ok := fe.actions[id]; !ok {
+		return fmt.Errorf("action %s: %w", id, ErrElementNotFound)
+	}
+	delete(fe.actions, id)
+	return nil
+}
+
+// ListActions returns the global actions in a user's feed, ordered by
+// sequence number
+func (r *MemoryFeedRepository) ListActions(
+	ctx context.Context, uid string, flavour Flavour) ([]Action, error) {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	actions := []Action{}
+	fe := r.feed(uid, flavour, false)
+	if fe == nil {
+		return actions, nil
+	}
+	for _, ac := range fe.actions {
+		actions = append(actions, copyAction(ac))
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return lessBySequence(
+			actions[i].SequenceNumber, actions[i].ID,
+			actions[j].SequenceNumber, actions[j].ID,
+		)
+	})
+	return actions, nil
+}
+
+// GetNudge returns a nudge from a user's feed
+func (r *MemoryFeedRepository) GetNudge(
+	ctx context.Context, uid string, flavour Flavour, id string) (*Nudge, error) {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	fe := r.feed(uid, flavour, false)
+	if fe == nil {
+		return nil, fmt.Errorf("nudge %s: %w", id, ErrElementNotFound)
+	}
+	nu, ok := fe.nudges[id]
+	if !ok {
+		return nil, fmt.Errorf("nudge %s: %w", id, ErrElementNotFound)
+	}
+	nu = copyNudge(nu)
+	return &nu, nil
+}
+
+// PutNudge creates or replaces a nudge in a user's feed
+func (r *MemoryFeedRepository) PutNudge(
+	ctx context.Context, uid string, flavour Flavour, nudge Nudge) error {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return err
+	}
+	if nudge.ID == "" {
+		return fmt.Errorf("a nudge ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.feed(uid, flavour, true).nudges[nudge.ID] = copyNudge(nudge)
+	return nil
+}
+
+// DeleteNudge removes a nudge from a user's feed
+func (r *MemoryFeedRepository) DeleteNudge(
+	ctx context.Context, uid string, flavour Flavour, id string) error {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fe := r.feed(uid, flavour, false)
+	if fe == nil {
+		return fmt.Errorf("nudge %s: %w", id, ErrElementNotFound)
+	}
+	if _, ok := fe.nudges[id]; !ok {
+		return fmt.Errorf("nudge %s: %w", id, ErrElementNotFound)
+	}
+	delete(fe.nudges, id)
+	return nil
+}
+
+// ListNudges returns the nudges in a user's feed that match the filter,
+// ordered by sequence number
+func (r *MemoryFeedRepository) ListNudges(
+	ctx context.Context, uid string, flavour Flavour, filter *FeedFilter) ([]Nudge, error) {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return nil, err
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nudges := []Nudge{}
+	fe := r.feed(uid, flavour, false)
+	if fe == nil || !filter.matchesFlavour(flavour) {
+		return nudges, nil
+	}
+	for _, nu := range fe.nudges {
+		if filter.MatchesNudge(nu) {
+			nudges = append(nudges, copyNudge(nu))
+		}
+	}
+	sort.Slice(nudges, func(i, j int) bool {
+		return lessBySequence(
+			nudges[i].SequenceNumber, nudges[i].ID,
+			nudges[j].SequenceNumber, nudges[j].ID,
+		)
+	})
+	return nudges, nil
+}
+
+// GetItem returns an item from a user's feed
+func (r *MemoryFeedRepository) GetItem(
+	ctx context.Context, uid string, flavour Flavour, id string) (*Item, error) {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	fe := r.feed(uid, flavour, false)
+	if fe == nil {
+		return nil, fmt.Errorf("item %s: %w", id, ErrElementNotFound)
+	}
+	it, ok := fe.items[id]
+	if !ok {
+		return nil, fmt.Errorf("item %s: %w", id, ErrElementNotFound)
+	}
+	it = copyItem(it)
+	return &it, nil
+}
+
+// PutItem creates or replaces an item in a user's feed
+func (r *MemoryFeedRepository) PutItem(
+	ctx context.Context, uid string, flavour Flavour, item Item) error {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return err
+	}
+	if item.ID == "" {
+		return fmt.Errorf("an item ID is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.feed(uid, flavour, true).items[item.ID] = copyItem(item)
+	return nil
+}
+
+// DeleteItem removes an item from a user's feed
+func (r *MemoryFeedRepository) DeleteItem(
+	ctx context.Context, uid string, flavour Flavour, id string) error {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fe := r.feed(uid, flavour, false)
+	if fe == nil {
+		return fmt.Errorf("item %s: %w", id, ErrElementNotFound)
+	}
+	if _, ok := fe.items[id]; !ok {
+		return fmt.Errorf("item %s: %w", id, ErrElementNotFound)
+	}
+	delete(fe.items, id)
+	return nil
+}
+
+// ListItems returns the items in a user's feed that match the filter,
+// ordered by sequence number
+func (r *MemoryFeedRepository) ListItems(
+	ctx context.Context, uid string, flavour Flavour, filter *FeedFilter) ([]Item, error) {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return nil, err
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	items := []Item{}
+	fe := r.feed(uid, flavour, false)
+	if fe == nil || !filter.matchesFlavour(flavour) {
+		return items, nil
+	}
+	for _, it := range fe.items {
+		if filter.MatchesItem(it) {
+			items = append(items, copyItem(it))
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return lessBySequence(
+			items[i].SequenceNumber, items[i].ID,
+			items[j].SequenceNumber, items[j].ID,
+		)
+	})
+	return items, nil
+}
+
+// lessBySequence orders elements by sequence number, breaking ties by ID
+func lessBySequence(seqA int, idA string, seqB int, idB string) bool {
+	if seqA != seqB {
+		return seqA < seqB
+	}
+	return idA < idB
+}
+
+// copyAction returns a copy of an action that does not share any slices or
+// maps with it. Nil slices and maps stay nil and empty ones stay empty.
+func copyAction(ac Action) Action {
+	ac.RequiredRoles = append(ac.RequiredRoles[:0:0], ac.RequiredRoles...)
+	ac.LocalisedName = copyLocalisedText(ac.LocalisedName)
+	return ac
+}
+
+// copyActions returns a deep copy of a slice of actions
+func copyActions(actions []Action) []Action {
+	if actions == nil {
+		return nil
+	}
+	copied := make([]Action, len(actions))
+	for i, ac := range actions {
+		copied[i] = copyAction(ac)
+	}
+	return copied
+}
+
+// copyNudge returns a copy of a nudge that does not share any slices, maps or
+// pointers with it. Nil slices and maps stay nil and empty ones stay empty.
+func copyNudge(nu Nudge) Nudge {
+	nu.PublishAt = copyTime(nu.PublishAt)
+	nu.Links = append(nu.Links[:0:0], nu.Links...)
+	nu.Actions = copyActions(nu.Actions)
+	nu.Users = append(nu.Users[:0:0], nu.Users...)
+	nu.Groups = append(nu.Groups[:0:0], nu.Groups...)
+	nu.ExcludedUsers = append(nu.ExcludedUsers[:0:0], nu.ExcludedUsers...)
+	nu.ExcludedGroups = append(nu.ExcludedGroups[:0:0], nu.ExcludedGroups...)
+	nu.NotificationChannels = append(nu.NotificationChannels[:0:0], nu.NotificationChannels...)
+	nu.LocalisedTitle = copyLocalisedText(nu.LocalisedTitle)
+	nu.LocalisedNotificationBody = copyLocalisedNotificationBody(nu.LocalisedNotificationBody)
+	return nu
+}
+
+// copyItem returns a copy of an item that does not share any slices, maps or
+// pointers with it
+func copyItem(it Item) Item {
+	it.PublishAt = copyTime(it.PublishAt)
+	it.Links = append(it.Links[:0:0], it.Links...)
+	it.Actions = copyActions(it.Actions)
+	it.Conversations = append(it.Conversations[:0:0], it.Conversations...)
+	it.Users = append(it.Users[:0:0], it.Users...)
+	it.Groups = append(it.Groups[:0:0], it.Groups...)
+	it.ExcludedUsers = append(it.ExcludedUsers[:0:0], it.ExcludedUsers...)
+	it.ExcludedGroups = append(it.ExcludedGroups[:0:0], it.ExcludedGroups...)
+	it.NotificationChannels = append(it.NotificationChannels[:0:0], it.NotificationChannels...)
+	it.LocalisedTagline = copyLocalisedText(it.LocalisedTagline)
+	it.LocalisedText = copyLocalisedText(it.LocalisedText)
+	it.LocalisedNotificationBody = copyLocalisedNotificationBody(it.LocalisedNotificationBody)
+	return it
+}
+
+// copyTime returns a copy of an optional time
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+// copyLocalisedText returns a copy of localised text that does not share its map
+func copyLocalisedText(lt LocalisedText) LocalisedText {
+	if lt == nil {
+		return nil
+	}
+	copied := make(LocalisedText, len(lt))
+	for tag, text := range lt {
+		copied[tag] = text
+	}
+	return copied
+}
+
+// copyLocalisedNotificationBody returns a copy of localised notification
+// bodies that does not share its map
+func copyLocalisedNotificationBody(lb LocalisedNotificationBody) LocalisedNotificationBody {
+	if lb == nil {
+		return nil
+	}
+	copied := make(LocalisedNotificationBody, len(lb))
+	for tag, body := range lb {
+		copied[tag] = body
+	}
+	return copied
+}
+
+// Feeds returns the feeds that have been written to, in no particular order.
+// It can be used as the FeedListerFunc of an ExpiryWorker.
+func (r *MemoryFeedRepository) Feeds(ctx context.Context) ([]FeedRef, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	refs := []FeedRef{}
+	for key := range r.feeds {
+		refs = append(refs, FeedRef{UID: key.uid, Flavour: key.flavour})
+	}
+	return refs, nil
+}
@@ -0,0 +1,14 @@
+package feedlib_test
+
+import (
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+)
+
+func TestMemoryFeedRepository(t *testing.T) {
+	feedtest.RunFeedRepositoryTests(t, func() feedlib.FeedRepository {
+		return feedlib.NewMemoryFeedRepository()
+	})
+}
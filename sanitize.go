@@ -0,0 +1,485 @@
+package feedlib
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// SanitizePolicy is an allowlist of the HTML that may appear in rich text.
+// Everything that is not explicitly allowed is removed.
+type SanitizePolicy struct {
+	// The elements that are kept, and the attributes that are kept on each
+	// of them. Other elements are unwrapped: their tags are removed but
+	// their content is kept.
+	AllowedElements map[string][]string
+
+	// Elements that are removed together with everything inside them,
+	// e.g script and style
+	DroppedElements []string
+
+	// The URL schemes that are allowed in URL attributes (e.g href and src)
+	// and in Markdown link destinations. Relative URLs are always allowed.
+	AllowedURLSchemes []string
+}
+
+// urlAttributes are the attributes whose values are URLs
+var urlAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"cite":       true,
+	"action":     true,
+	"formaction": true,
+	"poster":     true,
+	"background": true,
+}
+
+// NewSanitizePolicy returns a conservative policy that allows basic text
+// formatting, lists, headings, quotes, code, links and images over http(s)
+// and mailto and tel links. Scripts, styles, embedded content, event handler
+// attributes and inline styles are removed.
+//
+// The returned policy can be modified before it is used.
+func NewSanitizePolicy() *SanitizePolicy {
+	return &SanitizePolicy{
+		AllowedElements: map[string][]string{
+			"p": {}, "br": {}, "hr": {}, "span": {}, "div": {},
+			"b": {}, "strong": {}, "i": {}, "em": {}, "u": {}, "s": {},
+			"sub": {}, "sup": {}, "small": {},
+			"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {},
+			"ul": {}, "ol": {}, "li": {},
+			"blockquote": {"cite"}, "code": {}, "pre": {},
+			"table": {}, "thead": {}, "tbody": {}, "tr": {}, "th": {}, "td": {},
+			"a":   {"href", "title"},
+			"img": {"src", "alt", "title", "width", "height"},
+		},
+		DroppedElements: []string{
+			"script", "style", "iframe", "object", "embed", "applet",
+			"noscript", "template", "frame", "frameset", "svg", "math",
+			"xmp", "title", "textarea", "noembed", "noframes", "plaintext",
+		},
+		AllowedURLSchemes: []string{"http", "https", "mailto", "tel"},
+	}
+}
+
+// Removal records something that sanitisation removed from rich text
+type Removal struct {
+	// A JSON pointer (RFC 6901) to the field that was sanitised e.g /text
+	Field string `json:"field"`
+
+	// The element that was removed, or that the attribute or URL was on
+	// e.g script or a
+	Element string `json:"element"`
+
+	// The attribute that was removed, if any e.g onclick
+	Attribute string `json:"attribute,omitempty"`
+
+	// The removed attribute value or URL, if any
+	Value string `json:"value,omitempty"`
+}
+
+func (r Removal) String() string {
+	target := "<" + r.Element + ">"
+	if r.Attribute != "" {
+		target = fmt.Sprintf("%s attribute %s=%q", target, r.Attribute, r.Value)
+	}
+	return fmt.Sprintf("%s: removed %s", r.Field, target)
+}
+
+// isAllowedURL returns true if the URL is relative or uses an allowed scheme.
+// Whitespace and control characters are ignored as browsers do, so that
+// e.g "java\tscript:" is recognised.
+func (p *SanitizePolicy) isAllowedURL(raw string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, html.UnescapeString(raw))
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		return true
+	}
+	for _, scheme := range p.AllowedURLSchemes {
+		if strings.EqualFold(scheme, u.Scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDropped returns true if the element should be removed with its content
+func (p *SanitizePolicy) isDropped(element string) bool {
+	for _, dropped := range p.DroppedElements {
+		if dropped == element {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeHTML removes everything that the policy does not allow from an
+// HTML fragment. Text is left exactly as it was written, except for the
+// content of raw text elements (e.g xmp and title) that the policy keeps or
+// unwraps, which is escaped so that it stays text.
+func (p *SanitizePolicy) SanitizeHTML(s string) (string, []Removal) {
+	return p.sanitizeTags(s)
+}
+
+// markdownLinkPattern matches the start of an inline Markdown link or image
+// destination e.g the "](" in [text](https://example.com)
+var markdownLinkPattern = regexp.MustCompile(`(!?)(\]\(\s*<?)([^\s)>]*)`)
+
+// markdownReferencePattern matches a Markdown link reference definition
+// e.g [id]: https://example.com, including one inside blockquotes or list
+// items and one whose destination is on the next line
+var markdownReferencePattern = regexp.MustCompile(
+	`(?m)^((?:[ \t]*(?:>|(?:[-+*]|\d{1,9}[.)])[ \t]))*[ \t]*\[[^\]]+\]:[ \t]*(?:\r?\n[ \t]*(?:>[ \t]*)*)?<?)([^\s>]*)`)
+
+// markdownAutolinkPattern matches a Markdown autolink e.g <https://example.com>
+// or <someone@example.com>
+var markdownAutolinkPattern = regexp.MustCompile(
+	`<(?:[A-Za-z][A-Za-z0-9+.\-]{1,31}:[^\s<>]*|[^\s<>@:]+@[^\s<>@]+)>`)
+
+// isEmailAutolink returns true for the destination of an email autolink
+func isEmailAutolink(dest string) bool {
+	return !strings.Contains(dest, ":") && strings.Contains(dest, "@")
+}
+
+// SanitizeMarkdown removes everything that the policy does not allow from
+// Markdown: raw HTML is sanitised as per SanitizeHTML and link and image
+// destinations must use an allowed URL scheme.
+//
+// Raw HTML is recognised everywhere, including inside code spans and blocks,
+// so disallowed tags that are shown as code are removed too.
+func (p *SanitizePolicy) SanitizeMarkdown(s string) (string, []Removal) {
+	removals := []Removal{}
+	var b strings.Builder
+	// autolinks look like tags to an HTML tokenizer, so they are handled
+	// separately from the HTML around them
+	start := 0
+	for _, loc := range markdownAutolinkPattern.FindAllStringIndex(s, -1) {
+		segment, found := p.sanitizeTags(s[start:loc[0]])
+		b.WriteString(segment)
+		removals = append(removals, found...)
+
+		autolink := s[loc[0]:loc[1]]
+		dest := autolink[1 : len(autolink)-1]
+		if isEmailAutolink(dest) || p.isAllowedURL(dest) {
+			b.WriteString(autolink)
+		} else {
+			removals = append(removals, Removal{Element: "a", Attribute: "href", Value: dest})
+		}
+		start = loc[1]
+	}
+	segment, found := p.sanitizeTags(s[start:])
+	b.WriteString(segment)
+	removals = append(removals, found...)
+	sanitized := b.String()
+
+	sanitized = markdownLinkPattern.ReplaceAllStringFunc(sanitized, func(match string) string {
+		parts := markdownLinkPattern.FindStringSubmatch(match)
+		bang, prefix, dest := parts[1], parts[2], parts[3]
+		if p.isAllowedURL(dest) {
+			return match
+		}
+		element, attribute := "a", "href"
+		if bang != "" {
+			element, attribute = "img", "src"
+		}
+		removals = append(removals, Removal{Element: element, Attribute: attribute, Value: dest})
+		return bang + prefix
+	})
+	sanitized = markdownReferencePattern.ReplaceAllStringFunc(sanitized, func(match string) string {
+		parts := markdownReferencePattern.FindStringSubmatch(match)
+		prefix, dest := parts[1], parts[2]
+		if p.isAllowedURL(dest) {
+			return match
+		}
+		removals = append(removals, Removal{Element: "a", Attribute: "href", Value: dest})
+		return prefix
+	})
+	return sanitized, removals
+}
+
+// isRawTextElement returns true for elements whose content the tokenizer
+// returns as a single text token, without looking for tags in it
+func isRawTextElement(element string) bool {
+	switch element {
+	case "iframe", "noembed", "noframes", "noscript", "plaintext", "script",
+		"style", "textarea", "title", "xmp":
+		return true
+	}
+	return false
+}
+
+// sanitizeTags applies the policy to the HTML tags in s. Text between tags
+// is copied as is, but the content of raw text elements is escaped: it may
+// contain markup that a browser would parse once the element's tags are
+// removed.
+func (p *SanitizePolicy) sanitizeTags(s string) (string, []Removal) {
+	removals := []Removal{}
+	var out strings.Builder
+	// the names of the dropped elements that we are inside of
+	dropping := []string{}
+	// the raw text element that we are inside of, if any
+	rawText := ""
+
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break // io.EOF; the tokenizer does not fail on malformed HTML
+		}
+		raw := string(z.Raw())
+		tok := z.Token()
+
+		switch tt {
+		case xhtml.TextToken:
+			if len(dropping) > 0 {
+				continue
+			}
+			if rawText != "" {
+				out.WriteString(html.EscapeString(tok.Data))
+			} else {
+				out.WriteString(raw)
+			}
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if isRawTextElement(tok.Data) {
+				// the tokenizer switches to raw text even for <xmp/>
+				rawText = tok.Data
+			}
+			if len(dropping) > 0 {
+				if tt == xhtml.StartTagToken && tok.Data == dropping[len(dropping)-1] {
+					dropping = append(dropping, tok.Data)
+				}
+				continue
+			}
+			if p.isDropped(tok.Data) {
+				removals = append(removals, Removal{Element: tok.Data})
+				if tt == xhtml.StartTagToken && !isVoidElement(tok.Data) {
+					dropping = append(dropping, tok.Data)
+				}
+				continue
+			}
+			allowedAttrs, ok := p.AllowedElements[tok.Data]
+			if !ok {
+				removals = append(removals, Removal{Element: tok.Data})
+				continue
+			}
+			out.WriteString(p.openingTag(tok, tt, allowedAttrs, &removals))
+		case xhtml.EndTagToken:
+			if tok.Data == rawText {
+				rawText = ""
+			}
+			if len(dropping) > 0 {
+				if tok.Data == dropping[len(dropping)-1] {
+					dropping = dropping[:len(dropping)-1]
+				}
+				continue
+			}
+			if _, ok := p.AllowedElements[tok.Data]; ok {
+				out.WriteString("</" + tok.Data + ">")
+			}
+		case xhtml.CommentToken:
+			if len(dropping) == 0 {
+				removals = append(removals, Removal{Element: "!--"})
+			}
+		case xhtml.DoctypeToken:
+			removals = append(removals, Removal{Element: "!doctype"})
+		}
+	}
+	return out.String(), removals
+}
+
+// openingTag writes an allowed start tag with only its allowed attributes
+func (p *SanitizePolicy) openingTag(
+	tok xhtml.Token,
+	tt xhtml.TokenType,
+	allowedAttrs []string,
+	removals *[]Removal,
+) string {
+	var b strings.Builder
+	b.WriteString("<" + tok.Data)
+	for _, attr := range tok.Attr {
+		key := strings.ToLower(attr.Key)
+		allowed := false
+		for _, a := range allowedAttrs {
+			if a == key {
+				allowed = true
+				break
+			}
+		}
+		if allowed && urlAttributes[key] && !p.isAllowedURL(attr.Val) {
+			allowed = false
+		}
+		if !allowed {
+			*removals = append(*removals, Removal{
+				Element:   tok.Data,
+				Attribute: key,
+				Value:     attr.Val,
+			})
+			continue
+		}
+		b.WriteString(fmt.Sprintf(` %s="%s"`, key, html.EscapeString(attr.Val)))
+	}
+	if tt == xhtml.SelfClosingTagToken {
+		b.WriteString(" />")
+	} else {
+		b.WriteString(">")
+	}
+	return b.String()
+}
+
+// isVoidElement returns true for elements that never have content
+func isVoidElement(element string) bool {
+	switch element {
+	case "area", "base", "br", "col", "embed", "hr", "img", "input",
+		"link", "meta", "param", "source", "track", "wbr":
+		return true
+	}
+	return false
+}
+
+// sanitizeRichText sanitises a rich text field as per its text type and
+// records the field that the removals came from. Plain text is not
+// sanitised because clients don't interpret it. Rich text without a type is
+// treated as Markdown, which also covers HTML.
+func (p *SanitizePolicy) sanitizeRichText(
+	field string, s *string, textType TextType, removals *[]Removal) {
+	var sanitized string
+	var found []Removal
+	switch textType {
+	case TextTypePlain:
+		return
+	case TextTypeHTML:
+		sanitized, found = p.SanitizeHTML(*s)
+	default:
+		sanitized, found = p.SanitizeMarkdown(*s)
+	}
+	for _, r := range found {
+		r.Field = field
+		*removals = append(*removals, r)
+	}
+	*s = sanitized
+}
+
+// sanitizeLocalisedText sanitises every translation of a piece of rich
+// text as per its text type, reporting removals against field/<locale>
+func (p *SanitizePolicy) sanitizeLocalisedText(
+	field string, lt LocalisedText, textType TextType, removals *[]Removal) {
+	for _, locale := range lt.Locales() {
+		text := lt[locale]
+		p.sanitizeRichText(field+"/"+escapeJSONPointer(locale), &text, textType, removals)
+		lt[locale] = text
+	}
+}
+
+// Sanitizable is a feed element whose rich text can be sanitised
+type Sanitizable interface {
+	Element
+
+	// Sanitize removes everything that the policy does not allow from the
+	// element's rich text and reports what was removed
+	Sanitize(p *SanitizePolicy) []Removal
+}
+
+// UnmarshalOption changes what ValidateAndUnmarshal, or an element's own
+// ValidateAndUnmarshal method, does with an element once it has been
+// validated and unmarshalled
+type UnmarshalOption func(*unmarshalOptions)
+
+// unmarshalOptions holds the UnmarshalOptions passed to ValidateAndUnmarshal
+type unmarshalOptions struct {
+	sanitizePolicy *SanitizePolicy
+	removals       *[]Removal
+}
+
+// WithSanitization makes ValidateAndUnmarshal sanitise the rich text of
+// Sanitizable elements with the supplied policy and append what was removed
+// to removals, which may be nil. A nil policy skips sanitisation.
+// Elements without rich text are left as they are.
+func WithSanitization(p *SanitizePolicy, removals *[]Removal) UnmarshalOption {
+	return func(o *unmarshalOptions) {
+		o.sanitizePolicy = p
+		o.removals = removals
+	}
+}
+
+// sanitize applies the sanitisation option, if any, to an element. It
+// returns true if the element was sanitised.
+func (o *unmarshalOptions) sanitize(el Element) bool {
+	if o.sanitizePolicy == nil {
+		return false
+	}
+	s, ok := el.(Sanitizable)
+	if !ok {
+		return false
+	}
+	removals := s.Sanitize(o.sanitizePolicy)
+	if o.removals != nil {
+		*o.removals = append(*o.removals, removals...)
+	}
+	return true
+}
+
+// Sanitize sanitises the message's text
+func (msg *Message) Sanitize(p *SanitizePolicy) []Removal {
+	removals := []Removal{}
+	p.sanitizeRichText("/text", &msg.Text, "", &removals)
+	return removals
+}
+
+// Sanitize sanitises the nudge's title and text, and their translations
+func (nu *Nudge) Sanitize(p *SanitizePolicy) []Removal {
+	removals := []Removal{}
+	p.sanitizeRichText("/title", &nu.Title, "", &removals)
+	p.sanitizeLocalisedText("/localisedTitle", nu.LocalisedTitle, "", &removals)
+	p.sanitizeRichText("/text", &nu.Text, "", &removals)
+	return removals
+}
+
+// Sanitize sanitises the item's rich text: its tagline, summary, text (as
+// per its text type), the translations of its tagline and text and the text
+// of its conversation. Plain strings that identify someone or something e.g
+// the author are left as they are.
+func (it *Item) Sanitize(p *SanitizePolicy) []Removal {
+	removals := []Removal{}
+	p.sanitizeRichText("/tagline", &it.Tagline, "", &removals)
+	p.sanitizeLocalisedText("/localisedTagline", it.LocalisedTagline, "", &removals)
+	p.sanitizeRichText("/summary", &it.Summary, "", &removals)
+	p.sanitizeRichText("/text", &it.Text, it.TextType, &removals)
+	p.sanitizeLocalisedText("/localisedText", it.LocalisedText, it.TextType, &removals)
+	for i := range it.Conversations {
+		for _, r := range it.Conversations[i].Sanitize(p) {
+			r.Field = fmt.Sprintf("/conversations/%d%s", i, r.Field)
+			removals = append(removals, r)
+		}
+	}
+	return removals
+}
+
+// Sanitize sanitises the rich text of the feed's nudges and items
+func (fe *Feed) Sanitize(p *SanitizePolicy) []Removal {
+	removals := []Removal{}
+	for i := range fe.Nudges {
+		for _, r := range fe.Nudges[i].Sanitize(p) {
+			r.Field = fmt.Sprintf("/nudges/%d%s", i, r.Field)
+			removals = append(removals, r)
+		}
+	}
+	for i := range fe.Items {
+		for _, r := range fe.Items[i].Sanitize(p) {
+			r.Field = fmt.Sprintf("/items/%d%s", i, r.Field)
+			removals = append(removals, r)
+		}
+	}
+	return removals
+}
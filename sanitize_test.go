@@ -0,0 +1,373 @@
+package feedlib_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizePolicy_SanitizeHTML(t *testing.T) {
+	p := feedlib.NewSanitizePolicy()
+	tests := []struct {
+		name         string
+		in           string
+		want         string
+		wantRemovals []feedlib.Removal
+	}{
+		{
+			name:         "allowed markup is kept",
+			in:           `<p>Take <strong>2</strong> tablets &amp; rest</p><br/>`,
+			want:         `<p>Take <strong>2</strong> tablets &amp; rest</p><br />`,
+			wantRemovals: []feedlib.Removal{},
+		},
+		{
+			name: "scripts are dropped with their content",
+			in:   `<p>Hi</p><script>alert("x")</script><style>p{}</style>`,
+			want: `<p>Hi</p>`,
+			wantRemovals: []feedlib.Removal{
+				{Element: "script"},
+				{Element: "style"},
+			},
+		},
+		{
+			name: "unknown elements are unwrapped",
+			in:   `<font color="red">important</font>`,
+			want: `important`,
+			wantRemovals: []feedlib.Removal{
+				{Element: "font"},
+			},
+		},
+		{
+			name: "dangerous attributes are removed",
+			in:   `<a href="https://example.com" onclick="steal()" style="color:red">link</a>`,
+			want: `<a href="https://example.com">link</a>`,
+			wantRemovals: []feedlib.Removal{
+				{Element: "a", Attribute: "onclick", Value: "steal()"},
+				{Element: "a", Attribute: "style", Value: "color:red"},
+			},
+		},
+		{
+			name: "javascript URLs are removed",
+			in:   `<a href=" java&#x09;script:alert(1)">x</a><img src="data:image/png;base64,AAAA">`,
+			want: `<a>x</a><img>`,
+			wantRemovals: []feedlib.Removal{
+				{Element: "a", Attribute: "href", Value: " java\tscript:alert(1)"},
+				{Element: "img", Attribute: "src", Value: "data:image/png;base64,AAAA"},
+			},
+		},
+		{
+			name:         "relative URLs are allowed",
+			in:           `<a href="/library?topic=diabetes&amp;page=2">more</a>`,
+			want:         `<a href="/library?topic=diabetes&amp;page=2">more</a>`,
+			wantRemovals: []feedlib.Removal{},
+		},
+		{
+			name: "comments are removed",
+			in:   `before<!-- <script>x</script> -->after`,
+			want: `beforeafter`,
+			wantRemovals: []feedlib.Removal{
+				{Element: "!--"},
+			},
+		},
+		{
+			name: "nested dropped elements",
+			in:   `<object><object></object>inside</object>outside`,
+			want: `outside`,
+			wantRemovals: []feedlib.Removal{
+				{Element: "object"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removals := p.SanitizeHTML(tt.in)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantRemovals, removals)
+		})
+	}
+}
+
+func TestSanitizePolicy_SanitizeMarkdown(t *testing.T) {
+	p := feedlib.NewSanitizePolicy()
+	tests := []struct {
+		name         string
+		in           string
+		want         string
+		wantRemovals int
+	}{
+		{
+			name: "plain markdown is untouched",
+			in:   "# Title\n\n* a < b && c > d\n* [site](https://example.com) ![pic](/a.png)\n",
+			want: "# Title\n\n* a < b && c > d\n* [site](https://example.com) ![pic](/a.png)\n",
+		},
+		{
+			name:         "raw HTML is sanitised",
+			in:           "Hello <script>alert(1)</script><b onmouseover=\"x()\">world</b>",
+			want:         "Hello <b>world</b>",
+			wantRemovals: 2,
+		},
+		{
+			name:         "javascript link destinations are removed",
+			in:           "[click](javascript:alert%281%29) and ![img](vbscript:x)",
+			want:         "[click]() and ![img]()",
+			wantRemovals: 2,
+		},
+		{
+			name:         "reference definitions are checked",
+			in:           "[click][1]\n\n[1]: javascript:alert(1)\n[2]: https://example.com\n",
+			want:         "[click][1]\n\n[1]: \n[2]: https://example.com\n",
+			wantRemovals: 1,
+		},
+		{
+			name:         "reference definitions in blockquotes are checked",
+			in:           "[click][x]\n\n> [x]: javascript:alert(1)\n",
+			want:         "[click][x]\n\n> [x]: \n",
+			wantRemovals: 1,
+		},
+		{
+			name:         "reference definitions in list items are checked",
+			in:           "- [click][x]\n- [x]: javascript:alert(1)\n1. [y]: javascript:alert(2)\n",
+			want:         "- [click][x]\n- [x]: \n1. [y]: \n",
+			wantRemovals: 2,
+		},
+		{
+			name:         "reference destinations on the next line are checked",
+			in:           "[click][x]\n\n[x]:\njavascript:alert(1)\n\n> [y]:\n> javascript:alert(2)\n",
+			want:         "[click][x]\n\n[x]:\n\n\n> [y]:\n> \n",
+			wantRemovals: 2,
+		},
+		{
+			name:         "autolinks",
+			in:           "<https://example.com> <someone@example.com> <javascript:alert(1)>",
+			want:         "<https://example.com> <someone@example.com> ",
+			wantRemovals: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removals := p.SanitizeMarkdown(tt.in)
+			assert.Equal(t, tt.want, got)
+			assert.Len(t, removals, tt.wantRemovals)
+		})
+	}
+}
+
+func TestSanitizePolicy_RawTextElements(t *testing.T) {
+	payloads := map[string]string{
+		"xmp":       `<xmp><img src=x onerror=alert(1)></xmp>after`,
+		"title":     `<title><script>alert(1)</script></title>after`,
+		"textarea":  `<textarea><img src=x onerror=alert(1)></textarea>after`,
+		"noembed":   `<noembed><img src=x onerror=alert(1)></noembed>after`,
+		"noframes":  `<noframes><img src=x onerror=alert(1)></noframes>after`,
+		"plaintext": `<plaintext><img src=x onerror=alert(1)>`,
+	}
+	for element, in := range payloads {
+		t.Run(element, func(t *testing.T) {
+			p := feedlib.NewSanitizePolicy()
+			want := "after"
+			if element == "plaintext" {
+				want = "" // plaintext never ends
+			}
+
+			got, removals := p.SanitizeHTML(in)
+			assert.Equal(t, want, got)
+			assert.Equal(t, []feedlib.Removal{{Element: element}}, removals)
+
+			got, removals = p.SanitizeMarkdown(in)
+			assert.Equal(t, want, got)
+			assert.Equal(t, []feedlib.Removal{{Element: element}}, removals)
+
+			// a policy that unwraps the element keeps its content as text
+			p.DroppedElements = []string{}
+			got, _ = p.SanitizeHTML(in)
+			assert.NotContains(t, got, "<img")
+			assert.NotContains(t, got, "<script")
+			got, _ = p.SanitizeMarkdown(in)
+			assert.NotContains(t, got, "<img")
+			assert.NotContains(t, got, "<script")
+		})
+	}
+
+	p := feedlib.NewSanitizePolicy()
+	p.DroppedElements = []string{}
+	got, _ := p.SanitizeHTML(`<xmp><img src=x onerror=alert(1)></xmp><b>bold</b>`)
+	assert.Equal(t, `&lt;img src=x onerror=alert(1)&gt;<b>bold</b>`, got)
+	got, _ = p.SanitizeHTML(`<xmp/><img src=x onerror=alert(1)></xmp>`)
+	assert.Equal(t, `&lt;img src=x onerror=alert(1)&gt;`, got)
+}
+
+func TestSanitizePolicy_Configurable(t *testing.T) {
+	p := feedlib.NewSanitizePolicy()
+	p.AllowedElements["span"] = []string{"class"}
+	delete(p.AllowedElements, "img")
+	p.AllowedURLSchemes = []string{"https"}
+
+	got, removals := p.SanitizeHTML(
+		`<span class="x" id="y">a</span><img src="/a.png"><a href="http://example.com">b</a>`)
+	assert.Equal(t, `<span class="x">a</span><a>b</a>`, got)
+	assert.Len(t, removals, 3)
+}
+
+func TestItem_Sanitize(t *testing.T) {
+	p := feedlib.NewSanitizePolicy()
+
+	it := feedtest.Item("item-1", 1)
+	it.TextType = feedlib.TextTypeHTML
+	it.Text = `<p onclick="x()">Hi</p>`
+	it.Summary = `**bold**<script>x</script>`
+	it.Conversations[0].Text = `<img src=x onerror="alert(1)">`
+	it.Author = `Dr. <b>Jane</b> & co`
+
+	removals := it.Sanitize(p)
+	assert.Equal(t, `Dr. <b>Jane</b> & co`, it.Author, "plain strings are left alone")
+	assert.Equal(t, `<p>Hi</p>`, it.Text)
+	assert.Equal(t, `**bold**`, it.Summary)
+	assert.Equal(t, `<img src="x">`, it.Conversations[0].Text)
+
+	fields := []string{}
+	for _, r := range removals {
+		fields = append(fields, r.Field)
+	}
+	assert.Equal(t, []string{"/summary", "/text", "/conversations/0/text"}, fields)
+
+	plain := feedtest.Item("item-2", 1)
+	plain.TextType = feedlib.TextTypePlain
+	plain.Text = "<script> is how you write a script tag"
+	assert.Empty(t, plain.Sanitize(p))
+	assert.Equal(t, "<script> is how you write a script tag", plain.Text)
+}
+
+func TestSanitize_LocalisedText(t *testing.T) {
+	p := feedlib.NewSanitizePolicy()
+
+	it := feedtest.Item("item-1", 1)
+	it.TextType = feedlib.TextTypeHTML
+	it.Text = `<p>Hi</p>`
+	it.LocalisedText = feedlib.LocalisedText{
+		"sw": `<p onclick="x()">Habari</p><script>alert(1)</script>`,
+	}
+	it.LocalisedTagline = feedlib.LocalisedText{"sw": `[bonyeza](javascript:alert%281%29)`}
+
+	removals := it.Sanitize(p)
+	fields := []string{}
+	for _, r := range removals {
+		fields = append(fields, r.Field)
+	}
+	assert.Equal(t, []string{
+		"/localisedTagline/sw", "/localisedText/sw", "/localisedText/sw"}, fields)
+	localised := it.Localise("sw")
+	assert.Equal(t, `<p>Habari</p>`, localised.Text)
+	assert.Equal(t, `[bonyeza]()`, localised.Tagline)
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.LocalisedTitle = feedlib.LocalisedText{"sw": `Badilisha PIN<img src=x onerror="alert(1)">`}
+	removals = nu.Sanitize(p)
+	assert.Len(t, removals, 1)
+	assert.Equal(t, "/localisedTitle/sw", removals[0].Field)
+	assert.Equal(t, `Badilisha PIN<img src="x">`, nu.Localise("sw").Title)
+}
+
+func TestFeed_Sanitize(t *testing.T) {
+	fe := getTestFeed()
+	fe.Nudges[0].Text = `<script>x</script>Update your PIN`
+	fe.Items[0].TextType = feedlib.TextTypeMarkdown
+	fe.Items[0].Text = `[x](javascript:y)`
+
+	removals := fe.Sanitize(feedlib.NewSanitizePolicy())
+	assert.Len(t, removals, 2)
+	assert.Equal(t, "/nudges/0/text", removals[0].Field)
+	assert.Equal(t, "/items/0/text", removals[1].Field)
+	assert.Equal(t, "Update your PIN", fe.Nudges[0].Text)
+}
+
+func TestValidateAndUnmarshal_WithSanitization(t *testing.T) {
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.Text = `Set a PIN <a href="javascript:x()">now</a>`
+	b, err := json.Marshal(nu)
+	assert.Nil(t, err)
+
+	unsanitized := &feedlib.Nudge{}
+	err = feedlib.ValidateAndUnmarshal(feedlib.NudgeSchemaFile, b, unsanitized)
+	assert.Nil(t, err)
+	assert.Equal(t, nu.Text, unsanitized.Text, "sanitisation is opt in")
+
+	removals := []feedlib.Removal{}
+	unsanitized = &feedlib.Nudge{}
+	err = feedlib.ValidateAndUnmarshal(
+		feedlib.NudgeSchemaFile, b, unsanitized, feedlib.WithSanitization(nil, &removals))
+	assert.Nil(t, err)
+	assert.Empty(t, removals)
+	assert.Equal(t, nu.Text, unsanitized.Text, "a nil policy should not sanitise")
+
+	sanitized := &feedlib.Nudge{}
+	err = feedlib.ValidateAndUnmarshal(
+		feedlib.NudgeSchemaFile, b, sanitized,
+		feedlib.WithSanitization(feedlib.NewSanitizePolicy(), &removals))
+	assert.Nil(t, err)
+	assert.Equal(t, `Set a PIN <a>now</a>`, sanitized.Text)
+	assert.Equal(t, []feedlib.Removal{
+		{Field: "/text", Element: "a", Attribute: "href", Value: "javascript:x()"},
+	}, removals)
+	assert.Equal(t, `/text: removed <a> attribute href="javascript:x()"`, removals[0].String())
+
+	sanitized = &feedlib.Nudge{}
+	err = feedlib.ValidateAndUnmarshal(
+		feedlib.NudgeSchemaFile, b, sanitized,
+		feedlib.WithSanitization(feedlib.NewSanitizePolicy(), nil))
+	assert.Nil(t, err)
+	assert.Equal(t, `Set a PIN <a>now</a>`, sanitized.Text, "removals need not be collected")
+
+	ac := feedtest.Action("action-1", 1)
+	b, err = json.Marshal(ac)
+	assert.Nil(t, err)
+	err = feedlib.ValidateAndUnmarshal(
+		feedlib.ActionSchemaFile, b, &feedlib.Action{},
+		feedlib.WithSanitization(feedlib.NewSanitizePolicy(), &removals))
+	assert.Nil(t, err, "elements without rich text are left alone")
+
+	err = feedlib.ValidateAndUnmarshal(
+		feedlib.MessageSchemaFile, []byte("{}"), &feedlib.Message{},
+		feedlib.WithSanitization(feedlib.NewSanitizePolicy(), &removals))
+	assert.NotNil(t, err, "invalid elements are not sanitised")
+	assert.Len(t, removals, 1)
+}
+
+func TestItem_ValidateAndUnmarshal_WithSanitization(t *testing.T) {
+	p := feedlib.NewSanitizePolicy()
+
+	it := feedtest.Item("item-1", 1)
+	it.TextType = feedlib.TextTypeHTML
+	it.Text = `<p onclick="x()">Hi</p>`
+	b, err := json.Marshal(it)
+	assert.Nil(t, err)
+
+	removals := []feedlib.Removal{}
+	sanitized := &feedlib.Item{}
+	err = sanitized.ValidateAndUnmarshal(b, feedlib.WithSanitization(p, &removals))
+	assert.Nil(t, err)
+	assert.Equal(t, `<p>Hi</p>`, sanitized.Text)
+	assert.Len(t, removals, 1)
+
+	scriptOnly := feedtest.Item("item-2", 1)
+	scriptOnly.TextType = feedlib.TextTypeHTML
+	scriptOnly.Text = `<script>alert(1)</script>`
+	b, err = json.Marshal(scriptOnly)
+	assert.Nil(t, err)
+	err = (&feedlib.Item{}).ValidateAndUnmarshal(b)
+	assert.Nil(t, err, "the unsanitised item is valid")
+	err = (&feedlib.Item{}).ValidateAndUnmarshal(b, feedlib.WithSanitization(p, nil))
+	assert.NotNil(t, err, "an item left with empty text should be rejected")
+
+	svgIcon := feedtest.Item("item-3", 1)
+	svgIcon.TextType = feedlib.TextTypeHTML
+	svgIcon.Text = `<p onclick="x()">Hi</p>`
+	svgIcon.Icon = feedlib.GetSVGImageLink(
+		"https://example.com/a.svg", "title", "description", feedlib.BlankImageURL)
+	b, err = json.Marshal(svgIcon)
+	assert.Nil(t, err)
+	err = (&feedlib.Item{}).ValidateAndUnmarshal(b, feedlib.WithSanitization(p, nil))
+	assert.NotNil(t, err, "a sanitised item should still get the item checks")
+}
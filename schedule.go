@@ -0,0 +1,523 @@
+package feedlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// names of the events that are emitted when scheduled elements are published
+const (
+	EventNameItemPublished  = "ITEM_PUBLISHED"
+	EventNameNudgePublished = "NUDGE_PUBLISHED"
+)
+
+// IsDue returns true if the item has no publish time or one that is not
+// after now
+func (it Item) IsDue(now time.Time) bool {
+	return it.PublishAt == nil || !it.PublishAt.After(now)
+}
+
+// IsDue returns true if the nudge has no publish time or one that is not
+// after now
+func (nu Nudge) IsDue(now time.Time) bool {
+	return nu.PublishAt == nil || !nu.PublishAt.After(now)
+}
+
+// ScheduledElement is an item or a nudge that is waiting to be published to
+// a user's feed. Exactly one of Item and Nudge is set.
+type ScheduledElement struct {
+	Feed  FeedRef
+	Item  *Item
+	Nudge *Nudge
+}
+
+// ID returns the ID of the scheduled item or nudge
+func (se ScheduledElement) ID() string {
+	if se.Item != nil {
+		return se.Item.ID
+	}
+	return se.Nudge.ID
+}
+
+// PublishAt returns when the element is due. The zero time means that it is
+// due straight away.
+func (se ScheduledElement) PublishAt() time.Time {
+	var publishAt *time.Time
+	if se.Item != nil {
+		publishAt = se.Item.PublishAt
+	} else {
+		publishAt = se.Nudge.PublishAt
+	}
+	if publishAt == nil {
+		return time.Time{}
+	}
+	return *publishAt
+}
+
+func (se ScheduledElement) key() string {
+	kind := "nudge"
+	if se.Item != nil {
+		kind = "item"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", se.Feed.UID, se.Feed.Flavour, kind, se.ID())
+}
+
+// ScheduleStore persists the elements that a PublishScheduler is holding
+// until their publish time, so that a schedule survives restarts.
+// Implementations must be safe for concurrent use.
+type ScheduleStore interface {
+	// PutScheduled adds a scheduled element or replaces the one with the
+	// same feed, kind and ID
+	PutScheduled(ctx context.Context, se ScheduledElement) error
+
+	// DeleteScheduled removes a scheduled element. It returns
+	// ErrElementNotFound if the element is not scheduled, so that concurrent
+	// releases can use it to claim an element.
+	DeleteScheduled(ctx context.Context, se ScheduledElement) error
+
+	// ListScheduled returns every scheduled element
+	ListScheduled(ctx context.Context) ([]ScheduledElement, error)
+}
+
+// MemoryScheduleStore is an in-memory ScheduleStore. Its schedule is lost
+// when the process exits.
+type MemoryScheduleStore struct {
+	mu      sync.RWMutex
+	pending map[string]ScheduledElement
+}
+
+// NewMemoryScheduleStore initializes an empty in-memory schedule store
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{
+		pending: map[string]ScheduledElement{},
+	}
+}
+
+// PutScheduled adds or replaces a scheduled element
+func (m *MemoryScheduleStore) PutScheduled(ctx context.Context, se ScheduledElement) error {
+	if err := checkScheduled(ctx, se); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[se.key()] = copyScheduled(se)
+	return nil
+}
+
+// DeleteScheduled removes a scheduled element
+func (m *MemoryScheduleStore) DeleteScheduled(ctx context.Context, se ScheduledElement) error {
+	if err := checkScheduled(ctx, se); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := se.key()
+	if _, ok := m.pending[key]; !ok {
+		return fmt.Errorf("scheduled element %s: %w", se.ID(), ErrElementNotFound)
+	}
+	delete(m.pending, key)
+	return nil
+}
+
+// ListScheduled returns every scheduled element, ordered by publish time
+func (m *MemoryScheduleStore) ListScheduled(ctx context.Context) ([]ScheduledElement, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pending := []ScheduledElement{}
+	for _, se := range m.pending {
+		pending = append(pending, copyScheduled(se))
+	}
+	sortScheduled(pending)
+	return pending, nil
+}
+
+// checkScheduled checks that a scheduled element can be stored
+func checkScheduled(ctx context.Context, se ScheduledElement) error {
+	if (se.Item == nil) == (se.Nudge == nil) {
+		return fmt.Errorf("exactly one of a scheduled element's item and nudge must be set")
+	}
+	return checkPreconditions(ctx, se.Feed.UID, se.Feed.Flavour)
+}
+
+// copyScheduled returns a copy of a scheduled element that does not share
+// its item or nudge
+func copyScheduled(se ScheduledElement) ScheduledElement {
+	if se.Item != nil {
+		it := copyItem(*se.Item)
+		se.Item = &it
+	}
+	if se.Nudge != nil {
+		nu := copyNudge(*se.Nudge)
+		se.Nudge = &nu
+	}
+	return se
+}
+
+// requeueTimeout bounds how long a release spends putting an element that it
+// could not publish back into the schedule store
+const requeueTimeout = 10 * time.Second
+
+// PublishEventHandlerFunc receives the events that a PublishScheduler emits
+type PublishEventHandlerFunc func(ctx context.Context, events []*Event)
+
+// PublishScheduler holds items and nudges in a ScheduleStore until their
+// publish time and then puts them into their users' feeds, notifying the
+// owner of each feed over the element's notification channels.
+//
+// Released elements are given the next sequence number in their feed, so that
+// they sort after the elements that were published while they were waiting.
+//
+// Elements that expire before they are released are dropped without being
+// published. Elements that can't be put into a feed stay scheduled and are
+// retried on the next release. Notification failures are returned but don't
+// stop an element from being published.
+type PublishScheduler struct {
+	repo       FeedRepository
+	store      ScheduleStore
+	alloc      SequenceAllocator
+	dispatcher *Dispatcher
+	now        func() time.Time
+	onEvents   PublishEventHandlerFunc
+
+	runMu  sync.Mutex
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewPublishScheduler initializes a scheduler that keeps its schedule in the
+// supplied store, publishes into the supplied repository with sequence
+// numbers from the supplied allocator and uses the supplied clock to decide
+// what is due. A nil store means a MemoryScheduleStore, a nil allocator means
+// DefaultSequenceAllocator, a nil dispatcher means that no notifications are
+// sent and a nil clock means time.Now.
+func NewPublishScheduler(
+	repo FeedRepository,
+	store ScheduleStore,
+	alloc SequenceAllocator,
+	dispatcher *Dispatcher,
+	now func() time.Time,
+) (*PublishScheduler, error) {
+	if repo == nil {
+		return nil, fmt.Errorf("a repository is required")
+	}
+	if store == nil {
+		store = NewMemoryScheduleStore()
+	}
+	if alloc == nil {
+		alloc = DefaultSequenceAllocator
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &PublishScheduler{
+		repo:       repo,
+		store:      store,
+		alloc:      alloc,
+		dispatcher: dispatcher,
+		now:        now,
+	}, nil
+}
+
+// SetEventHandler sets the function that receives the events of each
+// release. A running scheduler keeps the handler that it was started with
+// until it is stopped.
+func (s *PublishScheduler) SetEventHandler(fn PublishEventHandlerFunc) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	s.onEvents = fn
+}
+
+// ScheduleItem schedules an item for publishing to a user's feed.
+// Scheduling an item again replaces the earlier schedule.
+func (s *PublishScheduler) ScheduleItem(
+	ctx context.Context, uid string, flavour Flavour, it Item) error {
+	if _, err := it.ValidateAndMarshal(); err != nil {
+		return fmt.Errorf("can't schedule item %s: %w", it.ID, err)
+	}
+	if it.IsExpired(s.now()) {
+		return fmt.Errorf("can't schedule item %s: it has already expired", it.ID)
+	}
+	return s.schedule(ctx, ScheduledElement{Feed: FeedRef{UID: uid, Flavour: flavour}, Item: &it})
+}
+
+// ScheduleNudge schedules a nudge for publishing to a user's feed.
+// Scheduling a nudge again replaces the earlier schedule.
+func (s *PublishScheduler) ScheduleNudge(
+	ctx context.Context, uid string, flavour Flavour, nu Nudge) error {
+	if _, err := nu.ValidateAndMarshal(); err != nil {
+		return fmt.Errorf("can't schedule nudge %s: %w", nu.ID, err)
+	}
+	if nu.IsExpired(s.now()) {
+		return fmt.Errorf("can't schedule nudge %s: it has already expired", nu.ID)
+	}
+	return s.schedule(ctx, ScheduledElement{Feed: FeedRef{UID: uid, Flavour: flavour}, Nudge: &nu})
+}
+
+func (s *PublishScheduler) schedule(ctx context.Context, se ScheduledElement) error {
+	if err := s.store.PutScheduled(ctx, se); err != nil {
+		return fmt.Errorf("can't schedule %s: %w", se.ID(), err)
+	}
+	return nil
+}
+
+// CancelItem removes a scheduled item. It returns false if the item was not
+// scheduled e.g because it has already been published.
+func (s *PublishScheduler) CancelItem(
+	ctx context.Context, uid string, flavour Flavour, id string) (bool, error) {
+	return s.cancelScheduled(ctx, ScheduledElement{
+		Feed: FeedRef{UID: uid, Flavour: flavour},
+		Item: &Item{ID: id},
+	})
+}
+
+// CancelNudge removes a scheduled nudge. It returns false if the nudge was
+// not scheduled e.g because it has already been published.
+func (s *PublishScheduler) CancelNudge(
+	ctx context.Context, uid string, flavour Flavour, id string) (bool, error) {
+	return s.cancelScheduled(ctx, ScheduledElement{
+		Feed:  FeedRef{UID: uid, Flavour: flavour},
+		Nudge: &Nudge{ID: id},
+	})
+}
+
+func (s *PublishScheduler) cancelScheduled(ctx context.Context, se ScheduledElement) (bool, error) {
+	err := s.store.DeleteScheduled(ctx, se)
+	if errors.Is(err, ErrElementNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("can't cancel %s: %w", se.ID(), err)
+	}
+	return true, nil
+}
+
+// Pending returns the elements that are waiting to be published, ordered by
+// publish time
+func (s *PublishScheduler) Pending(ctx context.Context) ([]ScheduledElement, error) {
+	pending, err := s.store.ListScheduled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't list scheduled elements: %w", err)
+	}
+	sortScheduled(pending)
+	return pending, nil
+}
+
+// Release publishes every element that is due, in order of publish time, and
+// returns the events that were emitted. The event handler is called with the
+// events of the release.
+//
+// Each due element is claimed by deleting it from the store before it is
+// published, so an element is published once even when several schedulers
+// share a store. Elements that can't be put into their feed are put back,
+// even if the supplied context has been cancelled in the meantime.
+//
+// The first error is returned after every due element has been tried.
+func (s *PublishScheduler) Release(ctx context.Context) ([]*Event, error) {
+	s.runMu.Lock()
+	onEvents := s.onEvents
+	s.runMu.Unlock()
+	return s.release(ctx, onEvents)
+}
+
+// release is Release with the event handler to use
+func (s *PublishScheduler) release(
+	ctx context.Context, onEvents PublishEventHandlerFunc) ([]*Event, error) {
+	scheduled, err := s.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	events := []*Event{}
+	var releaseErr error
+	record := func(err error) {
+		if err != nil && releaseErr == nil {
+			releaseErr = err
+		}
+	}
+	for _, se := range scheduled {
+		if err := ctx.Err(); err != nil {
+			record(err)
+			break
+		}
+		expired := (se.Item != nil && se.Item.IsExpired(now)) ||
+			(se.Nudge != nil && se.Nudge.IsExpired(now))
+		if !expired && se.PublishAt().After(now) {
+			continue
+		}
+		claimed, err := s.cancelScheduled(ctx, se)
+		if err != nil || !claimed || expired {
+			record(err)
+			continue
+		}
+
+		event, err := s.publish(ctx, se, now)
+		record(err)
+		if event != nil {
+			events = append(events, event)
+		} else if err := s.requeue(se); err != nil {
+			record(fmt.Errorf("can't reschedule %s: %w", se.ID(), err))
+		}
+	}
+	if len(events) > 0 && onEvents != nil {
+		onEvents(ctx, events)
+	}
+	return events, releaseErr
+}
+
+// requeue puts back an element that was claimed but could not be published.
+// It does not use the release's context: if that has been cancelled e.g
+// because a stop timed out, the claimed element would otherwise be lost.
+func (s *PublishScheduler) requeue(se ScheduledElement) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requeueTimeout)
+	defer cancel()
+	return s.store.PutScheduled(ctx, se)
+}
+
+// publish stamps a due element with the next sequence number in its feed,
+// puts it into the feed and notifies the feed's owner. The event is nil if
+// the element could not be put into the feed.
+//
+// Only the feed's owner is notified: an element that targets several users is
+// scheduled into each of their feeds, and each of those is published on its
+// own.
+func (s *PublishScheduler) publish(
+	ctx context.Context, se ScheduledElement, now time.Time) (*Event, error) {
+	evCtx := Context{UserID: se.Feed.UID, Flavour: se.Feed.Flavour, Timestamp: now}
+	owner := []string{se.Feed.UID}
+	if se.Item != nil {
+		it := *se.Item
+		if err := restampItem(ctx, s.alloc, se.Feed.UID, se.Feed.Flavour, &it); err != nil {
+			return nil, fmt.Errorf("can't publish item %s: %w", it.ID, err)
+		}
+		if err := s.repo.PutItem(ctx, se.Feed.UID, se.Feed.Flavour, it); err != nil {
+			return nil, fmt.Errorf("can't publish item %s: %w", it.ID, err)
+		}
+		event := newEvent(EventNameItemPublished, evCtx, map[string]interface{}{
+			"itemID":         it.ID,
+			"publishAt":      se.PublishAt().Format(time.RFC3339),
+			"sequenceNumber": it.SequenceNumber,
+		})
+		if s.dispatcher == nil {
+			return event, nil
+		}
+		it.Users = owner
+		if _, err := s.dispatcher.DispatchItem(ctx, it, NotificationTypePublish); err != nil {
+			return event, fmt.Errorf("can't notify users of item %s: %w", it.ID, err)
+		}
+		return event, nil
+	}
+
+	nu := *se.Nudge
+	if err := restampNudge(ctx, s.alloc, se.Feed.UID, se.Feed.Flavour, &nu); err != nil {
+		return nil, fmt.Errorf("can't publish nudge %s: %w", nu.ID, err)
+	}
+	if err := s.repo.PutNudge(ctx, se.Feed.UID, se.Feed.Flavour, nu); err != nil {
+		return nil, fmt.Errorf("can't publish nudge %s: %w", nu.ID, err)
+	}
+	event := newEvent(EventNameNudgePublished, evCtx, map[string]interface{}{
+		"nudgeID":        nu.ID,
+		"publishAt":      se.PublishAt().Format(time.RFC3339),
+		"sequenceNumber": nu.SequenceNumber,
+	})
+	if s.dispatcher == nil {
+		return event, nil
+	}
+	nu.Users = owner
+	if _, err := s.dispatcher.DispatchNudge(ctx, nu, NotificationTypePublish); err != nil {
+		return event, fmt.Errorf("can't notify users of nudge %s: %w", nu.ID, err)
+	}
+	return event, nil
+}
+
+// Start releases due elements once and then every interval in a background
+// goroutine, until Stop is called
+func (s *PublishScheduler) Start(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("the release interval must be positive, got %s", interval)
+	}
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if s.done != nil {
+		return fmt.Errorf("the publish scheduler is already running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(ctx, interval, s.onEvents, s.stop, s.done)
+	return nil
+}
+
+// Stop stops the scheduler gracefully, waiting for an in-flight release to
+// finish. If the supplied context is done first, the in-flight release is
+// cancelled and the context's error is returned. Stopping a scheduler that is
+// not running is a no-op.
+func (s *PublishScheduler) Stop(ctx context.Context) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if s.done == nil {
+		return nil
+	}
+	stop, done, cancel := s.stop, s.done, s.cancel
+	s.stop, s.done, s.cancel = nil, nil, nil
+	defer cancel()
+
+	close(stop)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// run is the scheduler's loop. Releases use ctx, which is only cancelled
+// when a graceful stop takes too long. The event handler is passed in because
+// Stop holds the scheduler's lock until the loop ends.
+func (s *PublishScheduler) run(
+	ctx context.Context,
+	interval time.Duration,
+	onEvents PublishEventHandlerFunc,
+	stop <-chan struct{},
+	done chan<- struct{},
+) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.release(ctx, onEvents); err != nil && ctx.Err() == nil {
+			log.Printf("scheduled publishing failed: %s", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sortScheduled orders scheduled elements by publish time, then by feed and ID
+func sortScheduled(elements []ScheduledElement) {
+	sort.Slice(elements, func(i, j int) bool {
+		a, b := elements[i].PublishAt(), elements[j].PublishAt()
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return elements[i].key() < elements[j].key()
+	})
+}
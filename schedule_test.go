@@ -0,0 +1,434 @@
+package feedlib_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingFeedRepository fails to put items into feeds
+type failingFeedRepository struct {
+	feedlib.FeedRepository
+}
+
+func (r failingFeedRepository) PutItem(
+	ctx context.Context, uid string, flavour feedlib.Flavour, it feedlib.Item) error {
+	return fmt.Errorf("the repository is unavailable")
+}
+
+func TestIsDue(t *testing.T) {
+	now := time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+
+	it := feedtest.Item("item-1", 1)
+	assert.True(t, it.IsDue(now), "items without a publish time are due")
+	it.PublishAt = &later
+	assert.False(t, it.IsDue(now))
+	assert.True(t, it.IsDue(later))
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	assert.True(t, nu.IsDue(now))
+	nu.PublishAt = &later
+	assert.False(t, nu.IsDue(now))
+}
+
+func TestPublishWindowValidation(t *testing.T) {
+	it := feedtest.Item("item-1", 1)
+	publishAt := it.Expiry.Add(time.Minute)
+	it.PublishAt = &publishAt
+
+	_, err := it.ValidateAndMarshal()
+	var vErr *feedlib.ValidationError
+	assert.True(t, errors.As(err, &vErr))
+	assert.Equal(t, feedlib.RulePublishWindow, vErr.Violations[0].Rule)
+	assert.Equal(t, "/publishAt", vErr.Violations[0].Field)
+
+	b, err := json.Marshal(it)
+	assert.Nil(t, err)
+	assert.NotNil(t, (&feedlib.Item{}).ValidateAndUnmarshal(b))
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.PublishAt = &nu.Expiry
+	_, err = nu.ValidateAndMarshal()
+	assert.True(t, errors.As(err, &vErr))
+
+	publishAt = nu.Expiry.Add(-time.Minute)
+	nu.PublishAt = &publishAt
+	b, err = nu.ValidateAndMarshal()
+	assert.Nil(t, err)
+	got := feedlib.Nudge{}
+	assert.Nil(t, got.ValidateAndUnmarshal(b))
+	assert.True(t, publishAt.Equal(*got.PublishAt))
+}
+
+func TestPublishScheduler(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	clock := func() time.Time { return now }
+
+	repo := feedlib.NewMemoryFeedRepository()
+	sms := feedlib.NewMemoryNotifier(nil)
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{feedlib.ChannelSms: sms})
+
+	_, err := feedlib.NewPublishScheduler(nil, nil, nil, d, clock)
+	assert.NotNil(t, err)
+	s, err := feedlib.NewPublishScheduler(repo, nil, nil, d, clock)
+	assert.Nil(t, err)
+
+	handled := []*feedlib.Event{}
+	s.SetEventHandler(func(ctx context.Context, events []*feedlib.Event) {
+		handled = append(handled, events...)
+	})
+
+	inTenMinutes := now.Add(10 * time.Minute)
+	inFiveMinutes := now.Add(5 * time.Minute)
+
+	it := feedtest.Item("item-1", 1)
+	it.PublishAt = &inTenMinutes
+	it.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+	it.NotificationBody = getTestNotificationBody()
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourConsumer, it))
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.PublishAt = &inFiveMinutes
+	nu.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+	nu.NotificationBody = getTestNotificationBody()
+	assert.Nil(t, s.ScheduleNudge(ctx, "user-1", feedlib.FlavourConsumer, nu))
+
+	cancelled := feedtest.Item("item-2", 1)
+	cancelled.PublishAt = &inFiveMinutes
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourConsumer, cancelled))
+	ok, err := s.CancelItem(ctx, "user-1", feedlib.FlavourConsumer, "item-2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = s.CancelItem(ctx, "user-1", feedlib.FlavourConsumer, "item-2")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	pending, err := s.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, "nudge-1", pending[0].ID(), "pending elements are ordered by publish time")
+
+	events, err := s.Release(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, events, "nothing is due yet")
+	_, err = repo.GetNudge(ctx, "user-1", feedlib.FlavourConsumer, "nudge-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+
+	now = inFiveMinutes
+	events, err = s.Release(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, feedlib.EventNameNudgePublished, events[0].Name)
+	_, err = repo.GetNudge(ctx, "user-1", feedlib.FlavourConsumer, "nudge-1")
+	assert.Nil(t, err)
+	assert.Len(t, sms.Sent(), 1)
+	assert.Equal(t, feedlib.NotificationTypePublish, sms.Sent()[0].Type)
+	assert.Equal(t, "publish message", sms.Sent()[0].Message)
+
+	now = inTenMinutes.Add(time.Minute)
+	events, err = s.Release(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, feedlib.EventNameItemPublished, events[0].Name)
+	assert.Equal(t, "item-1", events[0].Payload.Data["itemID"])
+	got, err := repo.GetItem(ctx, "user-1", feedlib.FlavourConsumer, "item-1")
+	assert.Nil(t, err)
+	assert.Equal(t, it.ID, got.ID)
+	assert.Len(t, sms.Sent(), 2)
+
+	pending, err = s.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+	assert.Len(t, handled, 2)
+}
+
+func TestPublishScheduler_Invalid(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	s, err := feedlib.NewPublishScheduler(
+		feedlib.NewMemoryFeedRepository(), nil, nil, nil, func() time.Time { return now })
+	assert.Nil(t, err)
+
+	expired := feedtest.Item("item-1", 1)
+	expired.Expiry = now.Add(-time.Hour)
+	assert.NotNil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, expired))
+
+	badWindow := feedtest.Nudge("nudge-1", 1)
+	publishAt := badWindow.Expiry.Add(time.Hour)
+	badWindow.PublishAt = &publishAt
+	assert.NotNil(t, s.ScheduleNudge(ctx, "user-1", feedlib.FlavourPro, badWindow))
+
+	assert.NotNil(t, s.ScheduleItem(ctx, "", feedlib.FlavourPro, feedtest.Item("item-2", 1)))
+	assert.NotNil(t, s.ScheduleItem(ctx, "user-1", feedlib.Flavour("bogus"), feedtest.Item("item-2", 1)))
+	pending, err := s.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestPublishScheduler_ExpiresBeforeRelease(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	repo := feedlib.NewMemoryFeedRepository()
+	s, err := feedlib.NewPublishScheduler(repo, nil, nil, nil, func() time.Time { return now })
+	assert.Nil(t, err)
+
+	it := feedtest.Item("item-1", 1)
+	publishAt := it.Expiry.Add(-time.Minute)
+	it.PublishAt = &publishAt
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, it))
+
+	now = it.Expiry.Add(time.Minute)
+	events, err := s.Release(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+	pending, err := s.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, pending, "expired elements are dropped")
+	_, err = repo.GetItem(ctx, "user-1", feedlib.FlavourPro, "item-1")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+}
+
+func TestPublishScheduler_RepositoryFailure(t *testing.T) {
+	ctx := context.Background()
+	repo := failingFeedRepository{FeedRepository: feedlib.NewMemoryFeedRepository()}
+	s, err := feedlib.NewPublishScheduler(repo, nil, nil, nil, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, feedtest.Item("item-1", 1)))
+	assert.Nil(t, s.ScheduleNudge(ctx, "user-1", feedlib.FlavourPro, feedtest.Nudge("nudge-1", 1)))
+
+	events, err := s.Release(ctx)
+	assert.NotNil(t, err)
+	assert.Len(t, events, 1, "other elements are still published")
+	assert.Equal(t, feedlib.EventNameNudgePublished, events[0].Name)
+
+	pending, err := s.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1, "failed elements are retried")
+	assert.Equal(t, "item-1", pending[0].ID())
+}
+
+func TestPublishScheduler_StartStop(t *testing.T) {
+	ctx := context.Background()
+	repo := feedlib.NewMemoryFeedRepository()
+	s, err := feedlib.NewPublishScheduler(repo, nil, nil, nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, feedtest.Item("item-1", 1)))
+
+	var mu sync.Mutex
+	handled := []*feedlib.Event{}
+	released := make(chan struct{}, 10)
+	s.SetEventHandler(func(ctx context.Context, events []*feedlib.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, events...)
+		released <- struct{}{}
+	})
+
+	assert.NotNil(t, s.Start(0))
+	assert.Nil(t, s.Start(10*time.Millisecond))
+	assert.NotNil(t, s.Start(10*time.Millisecond), "a running scheduler can't be started again")
+	<-released
+
+	// the running scheduler keeps the handler that it was started with
+	s.SetEventHandler(func(ctx context.Context, events []*feedlib.Event) {
+		t.Error("the running scheduler should not use a new handler")
+	})
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, feedtest.Item("item-2", 1)))
+	<-released
+	assert.Nil(t, s.Stop(ctx))
+	assert.Nil(t, s.Stop(ctx), "stopping a stopped scheduler is a no-op")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, handled, 2)
+	_, err = repo.GetItem(ctx, "user-1", feedlib.FlavourPro, "item-1")
+	assert.Nil(t, err)
+}
+
+func TestMemoryScheduleStore(t *testing.T) {
+	feedtest.RunScheduleStoreTests(t, func() feedlib.ScheduleStore {
+		return feedlib.NewMemoryScheduleStore()
+	})
+}
+
+// hookedFeedRepository calls a hook before putting items into feeds
+type hookedFeedRepository struct {
+	feedlib.FeedRepository
+	beforePutItem func(ctx context.Context)
+}
+
+func (r hookedFeedRepository) PutItem(
+	ctx context.Context, uid string, flavour feedlib.Flavour, it feedlib.Item) error {
+	r.beforePutItem(ctx)
+	return r.FeedRepository.PutItem(ctx, uid, flavour, it)
+}
+
+func TestPublishScheduler_SharedStore(t *testing.T) {
+	ctx := context.Background()
+	repo := feedlib.NewMemoryFeedRepository()
+	store := feedlib.NewMemoryScheduleStore()
+
+	first, err := feedlib.NewPublishScheduler(repo, store, nil, nil, nil)
+	assert.Nil(t, err)
+	it := feedtest.Item("item-1", 1)
+	later := time.Now().Add(time.Minute)
+	it.PublishAt = &later
+	assert.Nil(t, first.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, it))
+
+	restarted, err := feedlib.NewPublishScheduler(
+		repo, store, nil, nil, func() time.Time { return later })
+	assert.Nil(t, err)
+	pending, err := restarted.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1, "the schedule survives a restart")
+
+	events, err := restarted.Release(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	pending, err = first.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+	_, err = repo.GetItem(ctx, "user-1", feedlib.FlavourPro, "item-1")
+	assert.Nil(t, err)
+}
+
+func TestPublishScheduler_ReleaseDoesNotBlockScheduling(t *testing.T) {
+	ctx := context.Background()
+	var s *feedlib.PublishScheduler
+	repo := hookedFeedRepository{
+		FeedRepository: feedlib.NewMemoryFeedRepository(),
+		beforePutItem: func(ctx context.Context) {
+			// a slow write must not keep other callers out of the scheduler
+			assert.Nil(t, s.ScheduleNudge(ctx, "user-1", feedlib.FlavourPro, feedtest.Nudge("nudge-1", 1)))
+			ok, err := s.CancelNudge(ctx, "user-1", feedlib.FlavourPro, "nudge-1")
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		},
+	}
+	s, err := feedlib.NewPublishScheduler(repo, nil, nil, nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, feedtest.Item("item-1", 1)))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		events, err := s.Release(ctx)
+		assert.Nil(t, err)
+		assert.Len(t, events, 1)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("releasing deadlocked with scheduling")
+	}
+}
+
+func TestPublishScheduler_NotifiesFeedOwner(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	sms := feedlib.NewMemoryNotifier(nil)
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{feedlib.ChannelSms: sms})
+	s, err := feedlib.NewPublishScheduler(
+		feedlib.NewMemoryFeedRepository(), nil, nil, d, func() time.Time { return now })
+	assert.Nil(t, err)
+
+	// a campaign is scheduled into each of its recipients' feeds
+	users := []string{"user-1", "user-2", "user-3"}
+	it := feedtest.Item("item-1", 1)
+	it.Users = users
+	it.ExcludedUsers = []string{"user-2"}
+	it.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+	it.NotificationBody = getTestNotificationBody()
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.Users = users
+	nu.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+	nu.NotificationBody = getTestNotificationBody()
+	for _, uid := range users {
+		assert.Nil(t, s.ScheduleItem(ctx, uid, feedlib.FlavourConsumer, it))
+		assert.Nil(t, s.ScheduleNudge(ctx, uid, feedlib.FlavourConsumer, nu))
+	}
+
+	events, err := s.Release(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, events, 6)
+
+	notified := map[string]map[string]int{}
+	for _, n := range sms.Sent() {
+		if notified[n.UserID] == nil {
+			notified[n.UserID] = map[string]int{}
+		}
+		notified[n.UserID][n.ElementID]++
+	}
+	for _, uid := range []string{"user-1", "user-3"} {
+		assert.Equal(t, map[string]int{"item-1": 1, "nudge-1": 1}, notified[uid],
+			"%s should be notified once per element", uid)
+	}
+	assert.Equal(t, map[string]int{"nudge-1": 1}, notified["user-2"],
+		"excluded users should not be notified")
+}
+
+func TestPublishScheduler_RequeueAfterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := hookedFeedRepository{
+		FeedRepository: feedlib.NewMemoryFeedRepository(),
+		beforePutItem: func(context.Context) {
+			// e.g a stop that timed out while the item was being published
+			cancel()
+		},
+	}
+	s, err := feedlib.NewPublishScheduler(repo, nil, nil, nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, feedtest.Item("item-1", 1)))
+
+	events, err := s.Release(ctx)
+	assert.NotNil(t, err)
+	assert.Empty(t, events)
+
+	pending, err := s.Pending(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1, "a claimed element should be put back after cancellation")
+	assert.Equal(t, "item-1", pending[0].ID())
+	assert.Equal(t, 1, pending[0].Item.SequenceNumber,
+		"an element that was not published keeps its sequence number")
+}
+
+func TestPublishScheduler_SequenceNumbers(t *testing.T) {
+	ctx := context.Background()
+	repo := feedlib.NewMemoryFeedRepository()
+	alloc := feedlib.NewMemorySequenceAllocator()
+	s, err := feedlib.NewPublishScheduler(repo, nil, alloc, nil, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.ScheduleItem(ctx, "user-1", feedlib.FlavourPro, feedtest.Item("item-1", 1)))
+	assert.Nil(t, s.ScheduleNudge(ctx, "user-1", feedlib.FlavourPro, feedtest.Nudge("nudge-1", 2)))
+
+	// elements were published to the feed while these were waiting
+	assert.Nil(t, alloc.Observe(ctx, "user-1", feedlib.FlavourPro, 10))
+
+	events, err := s.Release(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+
+	it, err := repo.GetItem(ctx, "user-1", feedlib.FlavourPro, "item-1")
+	assert.Nil(t, err)
+	nu, err := repo.GetNudge(ctx, "user-1", feedlib.FlavourPro, "nudge-1")
+	assert.Nil(t, err)
+	assert.Greater(t, it.SequenceNumber, 10)
+	assert.Greater(t, nu.SequenceNumber, 10)
+	assert.NotEqual(t, it.SequenceNumber, nu.SequenceNumber)
+	for _, ev := range events {
+		assert.Greater(t, ev.Payload.Data["sequenceNumber"], 10)
+	}
+}
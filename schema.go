@@ -0,0 +1,276 @@
+package feedlib
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// schemaDir is the directory, relative to this package, that holds the JSON
+// schema files that ship with the library
+const schemaDir = "schema"
+
+//go:embed schema/*.schema.json
+var embeddedSchemas embed.FS
+
+// schemaFS returns the embedded schema files, rooted at the schema directory
+func schemaFS() fs.FS {
+	sub, err := fs.Sub(embeddedSchemas, schemaDir)
+	if err != nil {
+		// the directory is embedded at compile time so this can only
+		// happen if the embed directive above is changed incorrectly
+		panic(fmt.Sprintf("can't open embedded schema directory: %s", err))
+	}
+	return sub
+}
+
+// getSchemaLoader returns a loader for the named schema file.
+//
+// By default, schemas are loaded from the files embedded in this package and
+// any `$ref`s are resolved against the same embedded tree. This means that
+// validation does not touch the network at all.
+//
+// Setting the REMOTE_SCHEMAS environment variable to a true value opts in to
+// loading schemas (and their references) over the network instead. They are
+// loaded from SCHEMA_HOST, or FallbackSchemaHost when SCHEMA_HOST is not set.
+// SCHEMA_HOST on its own does not opt in.
+func getSchemaLoader(sch string) gojsonschema.JSONLoader {
+	if remoteSchemas() {
+		return gojsonschema.NewReferenceLoader(
+			fmt.Sprintf("%s/%s", schemaHost(), sch))
+	}
+	return gojsonschema.NewReferenceLoaderFileSystem(
+		fmt.Sprintf("file:///%s", sch), http.FS(schemaFS()))
+}
+
+// remoteSchemas returns true if the REMOTE_SCHEMAS environment variable opts
+// in to loading schemas over the network
+func remoteSchemas() bool {
+	remote, err := strconv.ParseBool(os.Getenv(RemoteSchemasEnvVarName))
+	return err == nil && remote
+}
+
+// schemaHost returns the host that remote schemas are loaded from
+func schemaHost() string {
+	host := strings.TrimSuffix(os.Getenv(SchemaHostEnvVarName), "/")
+	if host == "" {
+		return FallbackSchemaHost
+	}
+	return host
+}
+
+// AllSchemaFiles is the set of schema files that ship with the library
+var AllSchemaFiles = []string{
+	LinkSchemaFile,
+	MessageSchemaFile,
+	ActionSchemaFile,
+	NudgeSchemaFile,
+	ItemSchemaFile,
+	FeedSchemaFile,
+	ContextSchemaFile,
+	PayloadSchemaFile,
+	EventSchemaFile,
+	StatusSchemaFile,
+	VisibilitySchemaFile,
+	NotificationBodySchemaFile,
+	LocalisedTextSchemaFile,
+	LocalisedNotificationBodySchemaFile,
+}
+
+// DefaultSchemaRegistry is the registry that every feed element is validated
+// with. Its schemas never expire; call Invalidate after changing REMOTE_SCHEMAS
+// or SCHEMA_HOST.
+var DefaultSchemaRegistry = NewSchemaRegistry(0)
+
+// compiledSchema is a compiled schema and the time at which it was compiled
+type compiledSchema struct {
+	schema     *gojsonschema.Schema
+	compiledAt time.Time
+}
+
+// schemaCompilation is an in-flight compilation of a schema. Goroutines that
+// need the same schema wait for it instead of compiling it again.
+type schemaCompilation struct {
+	done   chan struct{}
+	gen    uint64
+	schema *gojsonschema.Schema
+	err    error
+}
+
+// SchemaRegistry is a concurrency-safe cache of compiled JSON schemas, keyed
+// by schema file name e.g ItemSchemaFile.
+//
+// A schema is loaded and compiled the first time that it is needed and reused
+// after that, until it is invalidated or its TTL runs out. Schemas are
+// compiled without holding the registry's lock, so a slow (e.g remote) schema
+// does not hold up validation against the others.
+//
+// Every schema file has a generation that Invalidate bumps. A compilation
+// that started before the schema was invalidated is not cached when it
+// finishes, so that a stale schema can't be written back into the cache.
+type SchemaRegistry struct {
+	mu          sync.RWMutex
+	ttl         time.Duration
+	now         func() time.Time
+	schemas     map[string]compiledSchema
+	inflight    map[string]*schemaCompilation
+	generations map[string]uint64
+}
+
+// NewSchemaRegistry initializes a schema registry. Compiled schemas are
+// recompiled once they are older than the supplied TTL. A TTL that is zero
+// or negative means that compiled schemas never expire.
+func NewSchemaRegistry(ttl time.Duration) *SchemaRegistry {
+	return &SchemaRegistry{
+		ttl:         ttl,
+		now:         time.Now,
+		schemas:     map[string]compiledSchema{},
+		inflight:    map[string]*schemaCompilation{},
+		generations: map[string]uint64{},
+	}
+}
+
+// Get returns the compiled schema for the named schema file, compiling it if
+// it is not cached yet or its TTL has run out.
+//
+// If an expired schema can't be recompiled (e.g because the remote schema
+// host is down), the previously compiled schema continues to be served.
+func (r *SchemaRegistry) Get(sch string) (*gojsonschema.Schema, error) {
+	r.mu.RLock()
+	cached, ok := r.schemas[sch]
+	r.mu.RUnlock()
+	if ok && !r.expired(cached) {
+		return cached.schema, nil
+	}
+
+	r.mu.Lock()
+	// another goroutine may have compiled it while we waited for the lock
+	cached, ok = r.schemas[sch]
+	if ok && !r.expired(cached) {
+		r.mu.Unlock()
+		return cached.schema, nil
+	}
+	c := r.await(sch)
+
+	if c.err != nil {
+		if ok {
+			log.Printf("can't refresh schema %s, using cached copy: %s", sch, c.err)
+			return cached.schema, nil
+		}
+		return nil, c.err
+	}
+	return c.schema, nil
+}
+
+// WarmUp compiles and caches the named schema files so that the first
+// validations do not pay the compilation cost. When no schema files are
+// supplied, all the schema files that ship with the library are warmed up.
+func (r *SchemaRegistry) WarmUp(schemaFiles ...string) error {
+	if len(schemaFiles) == 0 {
+		schemaFiles = AllSchemaFiles
+	}
+	for _, sch := range schemaFiles {
+		if _, err := r.Get(sch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh recompiles the named schema file and replaces any cached copy.
+// Unlike Get, a failure to compile the schema is returned to the caller
+// and the cached copy is left in place. A refresh that overlaps another
+// compilation of the same schema shares it.
+func (r *SchemaRegistry) Refresh(sch string) error {
+	r.mu.Lock()
+	return r.await(sch).err
+}
+
+// Invalidate drops the named schema files from the cache, so that they are
+// recompiled the next time they are needed. When no schema files are
+// supplied, the whole cache is cleared.
+//
+// Compilations that are still in flight are not cached when they finish.
+func (r *SchemaRegistry) Invalidate(schemaFiles ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(schemaFiles) == 0 {
+		for sch := range r.schemas {
+			schemaFiles = append(schemaFiles, sch)
+		}
+		for sch := range r.inflight {
+			if _, ok := r.schemas[sch]; !ok {
+				schemaFiles = append(schemaFiles, sch)
+			}
+		}
+	}
+	for _, sch := range schemaFiles {
+		r.generations[sch]++
+		delete(r.schemas, sch)
+		delete(r.inflight, sch)
+	}
+}
+
+// expired returns true if a cached schema has outlived the registry's TTL
+func (r *SchemaRegistry) expired(cached compiledSchema) bool {
+	if r.ttl <= 0 {
+		return false
+	}
+	return r.now().Sub(cached.compiledAt) >= r.ttl
+}
+
+// await joins the in-flight compilation of the named schema, or starts one if
+// there isn't any, and waits for it to finish. The caller must hold the lock;
+// it is released before waiting.
+func (r *SchemaRegistry) await(sch string) *schemaCompilation {
+	c, compiling := r.inflight[sch]
+	if !compiling {
+		c = &schemaCompilation{
+			done: make(chan struct{}),
+			gen:  r.generations[sch],
+		}
+		r.inflight[sch] = c
+	}
+	r.mu.Unlock()
+
+	if !compiling {
+		r.compile(sch, c)
+	}
+	<-c.done
+	return c
+}
+
+// compile loads and compiles a schema, then caches it unless the schema was
+// invalidated in the meantime. The caller must not hold the lock: loading a
+// schema may fetch it over the network.
+func (r *SchemaRegistry) compile(sch string, c *schemaCompilation) {
+	schema, err := gojsonschema.NewSchema(getSchemaLoader(sch))
+	if err != nil {
+		err = fmt.Errorf("can't compile schema %s: %w", sch, err)
+	}
+
+	r.mu.Lock()
+	if r.inflight[sch] == c {
+		delete(r.inflight, sch)
+	}
+	if err == nil && r.generations[sch] == c.gen {
+		r.schemas[sch] = compiledSchema{
+			schema:     schema,
+			compiledAt: r.now(),
+		}
+	}
+	r.mu.Unlock()
+
+	c.schema, c.err = schema, err
+	close(c.done)
+}
@@ -0,0 +1,360 @@
+package feedlib
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// setSchemaEnv sets the environment variables that control where schemas are
+// loaded from and returns a function that restores their initial values
+func setSchemaEnv(remote, host string) func() {
+	initialRemote := os.Getenv(RemoteSchemasEnvVarName)
+	initialHost := os.Getenv(SchemaHostEnvVarName)
+	os.Setenv(RemoteSchemasEnvVarName, remote)
+	os.Setenv(SchemaHostEnvVarName, host)
+	return func() {
+		os.Setenv(RemoteSchemasEnvVarName, initialRemote)
+		os.Setenv(SchemaHostEnvVarName, initialHost)
+	}
+}
+
+func TestSchemaFS(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	for _, sch := range AllSchemaFiles {
+		t.Run(sch, func(t *testing.T) {
+			b, err := fs.ReadFile(schemaFS(), sch)
+			assert.Nil(t, err)
+			assert.NotEmpty(t, b)
+
+			_, err = gojsonschema.NewSchema(getSchemaLoader(sch))
+			assert.Nil(t, err, "%s should compile from the embedded tree", sch)
+		})
+	}
+}
+
+func TestGetSchemaLoader(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	requested := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.Path)
+			http.FileServer(http.FS(schemaFS())).ServeHTTP(w, r)
+		}))
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		remote        string
+		schemaHost    string
+		wantRequested bool
+		wantErr       bool
+	}{
+		{
+			name:          "embedded schemas are the default",
+			remote:        "",
+			schemaHost:    "",
+			wantRequested: false,
+			wantErr:       false,
+		},
+		{
+			name:          "schema host on its own does not opt in",
+			remote:        "",
+			schemaHost:    srv.URL,
+			wantRequested: false,
+			wantErr:       false,
+		},
+		{
+			name:          "remote schemas opted out",
+			remote:        "false",
+			schemaHost:    srv.URL,
+			wantRequested: false,
+			wantErr:       false,
+		},
+		{
+			name:          "remote override",
+			remote:        "true",
+			schemaHost:    srv.URL + "/",
+			wantRequested: true,
+			wantErr:       false,
+		},
+		{
+			name:          "unreachable remote override",
+			remote:        "true",
+			schemaHost:    "http://127.0.0.1:1",
+			wantRequested: false,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requested = []string{}
+			os.Setenv(RemoteSchemasEnvVarName, tt.remote)
+			os.Setenv(SchemaHostEnvVarName, tt.schemaHost)
+
+			_, err := gojsonschema.NewSchema(getSchemaLoader(ItemSchemaFile))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getSchemaLoader() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantRequested {
+				assert.Contains(t, requested, "/"+ItemSchemaFile)
+				assert.Contains(t, requested, "/"+LinkSchemaFile)
+			} else {
+				assert.Empty(t, requested)
+			}
+		})
+	}
+}
+
+func TestSchemaRegistry_Get(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	r := NewSchemaRegistry(0)
+
+	first, err := r.Get(ItemSchemaFile)
+	assert.Nil(t, err)
+	assert.NotNil(t, first)
+
+	second, err := r.Get(ItemSchemaFile)
+	assert.Nil(t, err)
+	assert.Same(t, first, second, "a compiled schema should be reused")
+
+	_, err = r.Get("bogus.schema.json")
+	assert.NotNil(t, err)
+}
+
+func TestSchemaRegistry_Get_Concurrent(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	r := NewSchemaRegistry(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Get(NudgeSchemaFile)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, r.schemas, 1)
+}
+
+func TestSchemaRegistry_Get_SlowSchema(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	r := NewSchemaRegistry(0)
+	cached, err := r.Get(LinkSchemaFile)
+	assert.Nil(t, err)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			requests++
+			mu.Unlock()
+			<-release
+			http.FileServer(http.FS(schemaFS())).ServeHTTP(w, req)
+		}))
+	defer srv.Close()
+	setSchemaEnv("true", srv.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Get(PayloadSchemaFile)
+			assert.Nil(t, err)
+		}()
+	}
+
+	// a cached schema is served while another one is being compiled
+	got := make(chan *gojsonschema.Schema)
+	go func() {
+		schema, _ := r.Get(LinkSchemaFile)
+		got <- schema
+	}()
+	select {
+	case schema := <-got:
+		assert.Same(t, cached, schema)
+	case <-time.After(time.Second):
+		t.Fatal("a slow schema should not block access to cached schemas")
+	}
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, requests, "concurrent gets should share one compilation")
+}
+
+func TestSchemaRegistry_TTL(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	now := time.Now()
+	r := NewSchemaRegistry(time.Minute)
+	r.now = func() time.Time { return now }
+
+	first, err := r.Get(LinkSchemaFile)
+	assert.Nil(t, err)
+
+	now = now.Add(30 * time.Second)
+	second, err := r.Get(LinkSchemaFile)
+	assert.Nil(t, err)
+	assert.Same(t, first, second, "a fresh schema should not be recompiled")
+
+	now = now.Add(time.Minute)
+	third, err := r.Get(LinkSchemaFile)
+	assert.Nil(t, err)
+	assert.NotSame(t, first, third, "an expired schema should be recompiled")
+
+	// an expired schema that can't be recompiled is still served
+	setSchemaEnv("true", "http://127.0.0.1:1")
+	now = now.Add(time.Minute)
+	fourth, err := r.Get(LinkSchemaFile)
+	assert.Nil(t, err)
+	assert.Same(t, third, fourth)
+}
+
+func TestSchemaRegistry_WarmUp(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	r := NewSchemaRegistry(0)
+	assert.Nil(t, r.WarmUp(ItemSchemaFile, NudgeSchemaFile))
+	assert.Len(t, r.schemas, 2)
+
+	assert.Nil(t, r.WarmUp())
+	assert.Len(t, r.schemas, len(AllSchemaFiles))
+
+	assert.NotNil(t, r.WarmUp("bogus.schema.json"))
+}
+
+func TestSchemaRegistry_Refresh(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	r := NewSchemaRegistry(0)
+	first, err := r.Get(ActionSchemaFile)
+	assert.Nil(t, err)
+
+	assert.Nil(t, r.Refresh(ActionSchemaFile))
+	second, err := r.Get(ActionSchemaFile)
+	assert.Nil(t, err)
+	assert.NotSame(t, first, second)
+
+	setSchemaEnv("true", "http://127.0.0.1:1")
+	assert.NotNil(t, r.Refresh(ActionSchemaFile))
+	third, err := r.Get(ActionSchemaFile)
+	assert.Nil(t, err)
+	assert.Same(t, second, third, "a failed refresh should keep the cached copy")
+}
+
+func TestSchemaRegistry_Invalidate(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	r := NewSchemaRegistry(0)
+	assert.Nil(t, r.WarmUp())
+
+	r.Invalidate(ItemSchemaFile, NudgeSchemaFile)
+	assert.Len(t, r.schemas, len(AllSchemaFiles)-2)
+	_, ok := r.schemas[ItemSchemaFile]
+	assert.False(t, ok)
+
+	r.Invalidate()
+	assert.Empty(t, r.schemas)
+}
+
+func TestSchemaRegistry_Refresh_Concurrent(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			requests++
+			mu.Unlock()
+			<-release
+			http.FileServer(http.FS(schemaFS())).ServeHTTP(w, req)
+		}))
+	defer srv.Close()
+	setSchemaEnv("true", srv.URL)
+
+	r := NewSchemaRegistry(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, r.Refresh(PayloadSchemaFile))
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, requests, "concurrent refreshes should share one compilation")
+	assert.Len(t, r.schemas, 1)
+}
+
+func TestSchemaRegistry_Invalidate_InFlight(t *testing.T) {
+	defer setSchemaEnv("", "")()
+
+	tests := []struct {
+		name        string
+		schemaFiles []string
+	}{
+		{name: "named schema", schemaFiles: []string{PayloadSchemaFile}},
+		{name: "whole cache", schemaFiles: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			var once sync.Once
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, req *http.Request) {
+					once.Do(func() { close(started) })
+					<-release
+					http.FileServer(http.FS(schemaFS())).ServeHTTP(w, req)
+				}))
+			defer srv.Close()
+			setSchemaEnv("true", srv.URL)
+
+			r := NewSchemaRegistry(0)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_, err := r.Get(PayloadSchemaFile)
+				assert.Nil(t, err)
+			}()
+
+			<-started
+			r.Invalidate(tt.schemaFiles...)
+			close(release)
+			<-done
+
+			r.mu.RLock()
+			_, cached := r.schemas[PayloadSchemaFile]
+			r.mu.RUnlock()
+			assert.False(t, cached, "a compilation that started before the "+
+				"schema was invalidated should not be cached")
+
+			_, err := r.Get(PayloadSchemaFile)
+			assert.Nil(t, err)
+			assert.Len(t, r.schemas, 1, "a compilation that started after the "+
+				"schema was invalidated should be cached")
+		})
+	}
+}
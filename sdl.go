@@ -0,0 +1,379 @@
+package feedlib
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:generate go run ./cmd/feedsdl -o graphql/feed.graphql
+
+// sdlHeader marks generated SDL so that it is not edited by hand
+const sdlHeader = "# Code generated by feedsdl. DO NOT EDIT.\n"
+
+// sdlScalars are the custom scalars that the generated SDL uses
+var sdlScalars = []string{"Map", "Time"}
+
+// sdlRoots are the types that SDL is generated for. Types that they
+// reference e.g Context and Payload are generated too.
+var sdlRoots = []interface{}{
+	Item{},
+	Nudge{},
+	Action{},
+	Link{},
+	Message{},
+	Event{},
+	NotificationBody{},
+}
+
+// sdlInputRoots are the types that are passed to GraphQL fields as arguments
+// e.g to filter a listing. They are generated as input types, as are the
+// struct types that they reference.
+var sdlInputRoots = []interface{}{
+	FeedFilter{},
+}
+
+// sdlBuiltins are the scalars that every GraphQL schema has
+var sdlBuiltins = []string{"ID", "String", "Int", "Float", "Boolean"}
+
+// sdlNamePattern matches valid GraphQL names. Names that start with two
+// underscores are also reserved for introspection.
+var sdlNamePattern = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// sdlEnums maps each enum to its values
+var sdlEnums = map[reflect.Type][]string{
+	reflect.TypeOf(ActionType("")):       enumValues(AllActionType),
+	reflect.TypeOf(BooleanFilter("")):    enumValues(AllBooleanFilter),
+	reflect.TypeOf(Channel("")):          enumValues(AllChannel),
+	reflect.TypeOf(Command("")):          enumValues(AllCommand),
+	reflect.TypeOf(ExpiryPolicy("")):     enumValues(AllExpiryPolicy),
+	reflect.TypeOf(Flavour("")):          enumValues(AllFlavour),
+	reflect.TypeOf(Handling("")):         enumValues(AllHandling),
+	reflect.TypeOf(Keys("")):             enumValues(AllKeys),
+	reflect.TypeOf(LinkType("")):         enumValues(AllLinkType),
+	reflect.TypeOf(NotificationType("")): enumValues(AllNotificationType),
+	reflect.TypeOf(Status("")):           enumValues(AllStatus),
+	reflect.TypeOf(TextType("")):         enumValues(AllTextType),
+	reflect.TypeOf(ThreadOrder("")):      enumValues(AllThreadOrder),
+	reflect.TypeOf(Visibility("")):       enumValues(AllVisibility),
+}
+
+// enumValues returns the values of a slice of string enums e.g AllStatus
+func enumValues(all interface{}) []string {
+	v := reflect.ValueOf(all)
+	values := []string{}
+	for i := 0; i < v.Len(); i++ {
+		values = append(values, v.Index(i).String())
+	}
+	return values
+}
+
+// GenerateSDL returns a GraphQL schema (SDL) for the feed types and every
+// enum, so that services don't have to keep their own copy in sync.
+//
+// Field names follow the JSON tags. Pointers, slices and maps are nullable
+// and everything else is non-null. Maps are typed as the Map scalar and
+// times as the Time scalar. Apollo federation entities (see IsEntity) get a
+// @key directive on their id; an entity without an id is an error, because
+// nothing else identifies it.
+//
+// Filters e.g FeedFilter are generated as input types. Their fields are
+// also nullable when they are omitted from JSON when empty, so that callers
+// only need to supply the criteria that they care about.
+//
+// Types are named after their Go types. Type and field names must be valid
+// GraphQL names and a type's name must not clash with a scalar, an enum or
+// another type; a name that breaks these rules is an error.
+//
+// Two kinds of field need care: uint64 and uintptr fields are an error
+// because GraphQL's Int can't hold them, and interface{} fields quietly
+// become the Map scalar, whatever they hold at run time.
+func GenerateSDL() (string, error) {
+	g := &sdlGenerator{
+		types:  map[string]reflect.Type{},
+		inputs: map[string]reflect.Type{},
+	}
+	for _, root := range sdlRoots {
+		g.addType(g.types, reflect.TypeOf(root))
+	}
+	for _, root := range sdlInputRoots {
+		g.addType(g.inputs, reflect.TypeOf(root))
+	}
+	if err := g.checkNames(); err != nil {
+		return "", err
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(sdlHeader)
+	for _, scalar := range sdlScalars {
+		fmt.Fprintf(b, "\nscalar %s\n", scalar)
+	}
+
+	enums := []reflect.Type{}
+	for t := range sdlEnums {
+		enums = append(enums, t)
+	}
+	sort.Slice(enums, func(i, j int) bool { return enums[i].Name() < enums[j].Name() })
+	for _, t := range enums {
+		fmt.Fprintf(b, "\nenum %s {\n", t.Name())
+		for _, value := range sdlEnums[t] {
+			fmt.Fprintf(b, "  %s\n", value)
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, name := range sortedTypeNames(g.types) {
+		if err := g.writeType(b, g.types[name]); err != nil {
+			return "", err
+		}
+	}
+	for _, name := range sortedTypeNames(g.inputs) {
+		if err := g.writeInput(b, g.inputs[name]); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// sortedTypeNames returns the names of a set of types, sorted
+func sortedTypeNames(types map[string]reflect.Type) []string {
+	names := []string{}
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sdlGenerator collects the struct types that need to be generated
+type sdlGenerator struct {
+	types  map[string]reflect.Type
+	inputs map[string]reflect.Type
+
+	// the first clash between two Go types with the same name
+	err error
+}
+
+// addType records a struct type and the struct types that it references in
+// the supplied set of types
+func (g *sdlGenerator) addType(types map[string]reflect.Type, t reflect.Type) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return
+	}
+	if existing, ok := types[t.Name()]; ok {
+		if existing != t && g.err == nil {
+			g.err = fmt.Errorf(
+				"can't generate SDL: %s and %s are both named %s", existing, t, t.Name())
+		}
+		return
+	}
+	types[t.Name()] = t
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := sdlFieldName(t.Field(i)); ok {
+			g.addType(types, t.Field(i).Type)
+		}
+	}
+}
+
+// checkNames makes sure that every type has a valid name that is not taken
+func (g *sdlGenerator) checkNames() error {
+	if g.err != nil {
+		return g.err
+	}
+	taken := map[string]string{}
+	for _, name := range sdlBuiltins {
+		taken[name] = "a built in scalar"
+	}
+	for _, name := range sdlScalars {
+		taken[name] = "a scalar"
+	}
+	for t := range sdlEnums {
+		taken[t.Name()] = "an enum"
+	}
+	for _, name := range sortedTypeNames(g.types) {
+		if err := checkSDLName("type", name); err != nil {
+			return err
+		}
+		if what, ok := taken[name]; ok {
+			return fmt.Errorf("can't generate SDL for type %s: the name is taken by %s", name, what)
+		}
+		taken[name] = "a type"
+	}
+	for _, name := range sortedTypeNames(g.inputs) {
+		if err := checkSDLName("input", name); err != nil {
+			return err
+		}
+		if what, ok := taken[name]; ok {
+			return fmt.Errorf("can't generate SDL for input %s: the name is taken by %s", name, what)
+		}
+		taken[name] = "an input"
+	}
+	return nil
+}
+
+// checkSDLName returns an error if a name can't be used in GraphQL
+func checkSDLName(kind string, name string) error {
+	if !sdlNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("can't generate SDL: %q is not a valid GraphQL %s name", name, kind)
+	}
+	return nil
+}
+
+// writeType writes the SDL for a struct type
+func (g *sdlGenerator) writeType(b *strings.Builder, t reflect.Type) error {
+	if err := checkSDLName("type", t.Name()); err != nil {
+		return err
+	}
+	fields := []string{}
+	hasID := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := sdlFieldName(f)
+		if !ok {
+			continue
+		}
+		if err := checkSDLName("field", name); err != nil {
+			return fmt.Errorf("can't generate SDL for %s.%s: %w", t.Name(), f.Name, err)
+		}
+		typ, err := sdlFieldType(f.Type)
+		if err != nil {
+			return fmt.Errorf("can't generate SDL for %s.%s: %w", t.Name(), f.Name, err)
+		}
+		if name == "id" && f.Type.Kind() == reflect.String {
+			typ, hasID = "ID!", true
+		}
+		fields = append(fields, fmt.Sprintf("  %s: %s\n", name, typ))
+	}
+
+	directives := ""
+	if isEntity(t) {
+		if !hasID {
+			return fmt.Errorf(
+				"can't generate SDL for %s: federation entities need an id", t.Name())
+		}
+		directives = ` @key(fields: "id")`
+	}
+	fmt.Fprintf(b, "\ntype %s%s {\n", t.Name(), directives)
+	for _, field := range fields {
+		b.WriteString(field)
+	}
+	b.WriteString("}\n")
+	return nil
+}
+
+// writeInput writes the SDL for a struct type that is used as an input.
+// Fields that are omitted from JSON when empty are nullable.
+func (g *sdlGenerator) writeInput(b *strings.Builder, t reflect.Type) error {
+	if err := checkSDLName("input", t.Name()); err != nil {
+		return err
+	}
+	fmt.Fprintf(b, "\ninput %s {\n", t.Name())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := sdlFieldName(f)
+		if !ok {
+			continue
+		}
+		if err := checkSDLName("field", name); err != nil {
+			return fmt.Errorf("can't generate SDL for %s.%s: %w", t.Name(), f.Name, err)
+		}
+		typ, err := sdlFieldType(f.Type)
+		if err != nil {
+			return fmt.Errorf("can't generate SDL for %s.%s: %w", t.Name(), f.Name, err)
+		}
+		if isOmitEmpty(f) {
+			typ = strings.TrimSuffix(typ, "!")
+		}
+		fmt.Fprintf(b, "  %s: %s\n", name, typ)
+	}
+	b.WriteString("}\n")
+	return nil
+}
+
+// isOmitEmpty returns true if a field is left out of JSON when it is empty
+func isOmitEmpty(f reflect.StructField) bool {
+	for _, option := range strings.Split(f.Tag.Get("json"), ",")[1:] {
+		if option == "omitempty" {
+			return true
+		}
+	}
+	return false
+}
+
+// isEntity returns true if the type is marked as a federation entity
+func isEntity(t reflect.Type) bool {
+	entity := reflect.TypeOf((*interface{ IsEntity() })(nil)).Elem()
+	return t.Implements(entity) || reflect.PtrTo(t).Implements(entity)
+}
+
+// sdlFieldName returns a field's name as per its JSON tag. Unexported fields
+// and fields that are left out of JSON are skipped.
+func sdlFieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name, true
+}
+
+// sdlFieldType returns the GraphQL type of a field
+func sdlFieldType(t reflect.Type) (string, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return sdlNamedType(t.Elem())
+	case reflect.Slice:
+		elem, err := sdlFieldType(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasSuffix(elem, "!") {
+			elem += "!"
+		}
+		return fmt.Sprintf("[%s]", elem), nil
+	case reflect.Map, reflect.Interface:
+		return "Map", nil
+	}
+	named, err := sdlNamedType(t)
+	if err != nil {
+		return "", err
+	}
+	return named + "!", nil
+}
+
+// sdlNamedType returns the GraphQL name of a type, without nullability
+func sdlNamedType(t reflect.Type) (string, error) {
+	if _, ok := sdlEnums[t]; ok {
+		return t.Name(), nil
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return "Time", nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "String", nil
+	case reflect.Bool:
+		return "Boolean", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "Int", nil
+	case reflect.Float32, reflect.Float64:
+		return "Float", nil
+	case reflect.Struct:
+		return t.Name(), nil
+	case reflect.Map, reflect.Interface:
+		return "Map", nil
+	}
+	return "", fmt.Errorf("%s has no GraphQL equivalent", t)
+}
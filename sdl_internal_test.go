@@ -0,0 +1,108 @@
+package feedlib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// keylessEntity is a federation entity without an id
+type keylessEntity struct {
+	Name string `json:"name"`
+}
+
+func (keylessEntity) IsEntity() {}
+
+func TestSDLGenerator_WriteType_Entities(t *testing.T) {
+	typ := reflect.TypeOf(keylessEntity{})
+	g := &sdlGenerator{types: map[string]reflect.Type{}}
+
+	b := &strings.Builder{}
+	assert.NotNil(t, g.writeType(b, typ), "entities without an id are an error")
+	assert.Empty(t, b.String())
+}
+
+// invalidFieldName has a JSON field name that GraphQL does not allow
+type invalidFieldName struct {
+	Name string `json:"first-name"`
+}
+
+// Größe has a name that GraphQL does not allow
+type Größe struct {
+	Value int `json:"value"`
+}
+
+// unsignedCounter has a field that GraphQL's Int can't hold
+type unsignedCounter struct {
+	Count uint64 `json:"count"`
+}
+
+// untypedValue has a field that can hold anything
+type untypedValue struct {
+	Value interface{} `json:"value"`
+}
+
+// optionalFilter is a filter whose criteria are all optional
+type optionalFilter struct {
+	Label  string   `json:"label,omitempty"`
+	Status []Status `json:"status,omitempty"`
+	Limit  int      `json:"limit"`
+}
+
+func TestSDLGenerator_Names(t *testing.T) {
+	g := &sdlGenerator{types: map[string]reflect.Type{}}
+	b := &strings.Builder{}
+	assert.NotNil(t, g.writeType(b, reflect.TypeOf(invalidFieldName{})))
+	assert.NotNil(t, g.writeType(b, reflect.TypeOf(Größe{})))
+
+	assert.NotNil(t, checkSDLName("type", "__Type"), "double underscores are reserved")
+	assert.Nil(t, checkSDLName("type", "keylessEntity"))
+
+	// a struct with the same name as an enum
+	type Status struct {
+		Value string `json:"value"`
+	}
+	g = &sdlGenerator{
+		types:  map[string]reflect.Type{},
+		inputs: map[string]reflect.Type{},
+	}
+	g.addType(g.types, reflect.TypeOf(Status{}))
+	assert.NotNil(t, g.checkNames())
+
+	// two Go types with the same name
+	type untypedValue struct {
+		Other string `json:"other"`
+	}
+	g = &sdlGenerator{
+		types:  map[string]reflect.Type{},
+		inputs: map[string]reflect.Type{},
+	}
+	g.addType(g.types, reflect.TypeOf(untypedValue{}))
+	g.addType(g.types, reflect.TypeOf(untypedValueHolder{}))
+	assert.NotNil(t, g.checkNames())
+}
+
+// untypedValueHolder references the package level untypedValue
+type untypedValueHolder struct {
+	Value untypedValue `json:"value"`
+}
+
+func TestSDLGenerator_FieldTypes(t *testing.T) {
+	g := &sdlGenerator{types: map[string]reflect.Type{}}
+
+	b := &strings.Builder{}
+	assert.NotNil(t, g.writeType(b, reflect.TypeOf(unsignedCounter{})),
+		"uint64 has no GraphQL equivalent")
+
+	b = &strings.Builder{}
+	assert.Nil(t, g.writeType(b, reflect.TypeOf(untypedValue{})))
+	assert.Contains(t, b.String(), "  value: Map\n", "interface{} becomes Map")
+
+	b = &strings.Builder{}
+	assert.Nil(t, g.writeInput(b, reflect.TypeOf(optionalFilter{})))
+	assert.Equal(t,
+		"\ninput optionalFilter {\n  label: String\n  status: [Status!]\n  limit: Int!\n}\n",
+		b.String())
+}
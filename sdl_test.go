@@ -0,0 +1,57 @@
+package feedlib_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateSDL(t *testing.T) {
+	sdl, err := feedlib.GenerateSDL()
+	assert.Nil(t, err)
+
+	again, err := feedlib.GenerateSDL()
+	assert.Nil(t, err)
+	assert.Equal(t, sdl, again, "the SDL should be deterministic")
+
+	for _, want := range []string{
+		"scalar Time\n",
+		"type Item @key(fields: \"id\") {\n",
+		"type Nudge @key(fields: \"id\") {\n",
+		"type Action @key(fields: \"id\") {\n",
+		"type Event @key(fields: \"id\") {\n",
+		"type NotificationBody {\n",
+		"type Link {\n",
+		"type Context {\n",
+		"  status: Status!\n",
+		"  publishAt: Time\n",
+		"  notificationChannels: [Channel!]\n",
+		"  localisedText: Map\n",
+		"enum Handling {\n  INLINE\n  FULL_PAGE\n}\n",
+		"input FeedFilter {\n",
+		"  persistent: BooleanFilter\n",
+		"  status: [Status!]\n",
+		"  expiresAfter: Time\n",
+	} {
+		assert.Contains(t, sdl, want)
+	}
+
+	for _, enum := range []string{
+		"ActionType", "BooleanFilter", "Channel", "Command", "ExpiryPolicy", "Flavour", "Keys",
+		"LinkType", "NotificationType", "Status", "TextType", "ThreadOrder", "Visibility",
+	} {
+		assert.Contains(t, sdl, "enum "+enum+" {")
+	}
+}
+
+func TestGenerateSDL_UpToDate(t *testing.T) {
+	sdl, err := feedlib.GenerateSDL()
+	assert.Nil(t, err)
+
+	committed, err := ioutil.ReadFile("graphql/feed.graphql")
+	assert.Nil(t, err)
+	assert.Equal(t, sdl, string(committed),
+		"graphql/feed.graphql is out of date; run go generate ./...")
+}
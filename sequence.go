@@ -0,0 +1,255 @@
+package feedlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSequenceViolation is returned when sequence numbers are out of order
+// e.g a reply has a lower number than the message that it replies to
+var ErrSequenceViolation = errors.New("sequence numbers are not monotonic")
+
+// ErrInvalidSequenceNumber is returned when a feed's sequence numbers are
+// not positive and unique
+var ErrInvalidSequenceNumber = errors.New("sequence numbers are not positive and unique")
+
+// SequenceAllocator hands out sequence numbers for the elements of users'
+// feeds.
+//
+// Sequence numbers are scoped to a user's feed of a given flavour: within a
+// feed, every number that is handed out is higher than the numbers handed out
+// before it, across actions, nudges, items and messages. Implementations must
+// be safe for concurrent use; persistent implementations would typically use
+// an atomic increment in their datastore.
+type SequenceAllocator interface {
+	// Next returns the next sequence number for a user's feed
+	Next(ctx context.Context, uid string, flavour Flavour) (int, error)
+
+	// Observe tells the allocator about a sequence number that is already in
+	// use e.g when a feed is loaded, so that later numbers are higher than it
+	Observe(ctx context.Context, uid string, flavour Flavour, sequenceNumber int) error
+}
+
+// DefaultSequenceAllocator is the allocator that the lifecycle methods on Item
+// and Nudge take sequence numbers from. Services that persist their feeds
+// should call ObserveFeed on it when they load a feed, or use a
+// LifecycleEngine that is backed by their own allocator.
+var DefaultSequenceAllocator SequenceAllocator = NewMemorySequenceAllocator()
+
+// MemorySequenceAllocator is an in-memory SequenceAllocator backed by atomic
+// counters. Numbers start from 1 in every feed.
+type MemorySequenceAllocator struct {
+	mu       sync.RWMutex
+	counters map[feedKey]*int64
+}
+
+// NewMemorySequenceAllocator initializes an in-memory sequence allocator
+func NewMemorySequenceAllocator() *MemorySequenceAllocator {
+	return &MemorySequenceAllocator{
+		counters: map[feedKey]*int64{},
+	}
+}
+
+// counter returns the counter for a feed, creating it if necessary
+func (a *MemorySequenceAllocator) counter(uid string, flavour Flavour) *int64 {
+	key := feedKey{uid: uid, flavour: flavour}
+
+	a.mu.RLock()
+	c, ok := a.counters[key]
+	a.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c, ok = a.counters[key]
+	if !ok {
+		c = new(int64)
+		a.counters[key] = c
+	}
+	return c
+}
+
+// Next returns the next sequence number for a user's feed
+func (a *MemorySequenceAllocator) Next(
+	ctx context.Context, uid string, flavour Flavour) (int, error) {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return 0, err
+	}
+	return int(atomic.AddInt64(a.counter(uid, flavour), 1)), nil
+}
+
+// Observe raises the feed's counter to the supplied sequence number, if it is
+// not already higher
+func (a *MemorySequenceAllocator) Observe(
+	ctx context.Context, uid string, flavour Flavour, sequenceNumber int) error {
+	if err := checkPreconditions(ctx, uid, flavour); err != nil {
+		return err
+	}
+	c := a.counter(uid, flavour)
+	for {
+		current := atomic.LoadInt64(c)
+		if int64(sequenceNumber) <= current {
+			return nil
+		}
+		if atomic.CompareAndSwapInt64(c, current, int64(sequenceNumber)) {
+			return nil
+		}
+	}
+}
+
+// StampAction sets the action's sequence number to the next one in the feed
+func StampAction(
+	ctx context.Context, alloc SequenceAllocator, uid string, flavour Flavour, ac *Action) error {
+	seq, err := alloc.Next(ctx, uid, flavour)
+	if err != nil {
+		return fmt.Errorf("can't allocate a sequence number for action %s: %w", ac.ID, err)
+	}
+	ac.SequenceNumber = seq
+	return nil
+}
+
+// StampNudge sets the nudge's sequence number to the next one in the feed
+func StampNudge(
+	ctx context.Context, alloc SequenceAllocator, uid string, flavour Flavour, nu *Nudge) error {
+	seq, err := alloc.Next(ctx, uid, flavour)
+	if err != nil {
+		return fmt.Errorf("can't allocate a sequence number for nudge %s: %w", nu.ID, err)
+	}
+	nu.SequenceNumber = seq
+	return nil
+}
+
+// StampItem sets the item's sequence number to the next one in the feed
+func StampItem(
+	ctx context.Context, alloc SequenceAllocator, uid string, flavour Flavour, it *Item) error {
+	seq, err := alloc.Next(ctx, uid, flavour)
+	if err != nil {
+		return fmt.Errorf("can't allocate a sequence number for item %s: %w", it.ID, err)
+	}
+	it.SequenceNumber = seq
+	return nil
+}
+
+// StampMessage sets the message's sequence number to the next one in the feed
+func StampMessage(
+	ctx context.Context, alloc SequenceAllocator, uid string, flavour Flavour, msg *Message) error {
+	seq, err := alloc.Next(ctx, uid, flavour)
+	if err != nil {
+		return fmt.Errorf("can't allocate a sequence number for message %s: %w", msg.ID, err)
+	}
+	msg.SequenceNumber = seq
+	return nil
+}
+
+// restampNudge gives a changed nudge the next sequence number in its feed.
+// The nudge's current number is observed first so that the new number is
+// higher than it even if the allocator has not seen the feed yet.
+func restampNudge(
+	ctx context.Context, alloc SequenceAllocator, uid string, flavour Flavour, nu *Nudge) error {
+	if err := alloc.Observe(ctx, uid, flavour, nu.SequenceNumber); err != nil {
+		return fmt.Errorf("can't allocate a sequence number for nudge %s: %w", nu.ID, err)
+	}
+	return StampNudge(ctx, alloc, uid, flavour, nu)
+}
+
+// restampItem gives a changed item the next sequence number in its feed.
+// The item's current number is observed first so that the new number is
+// higher than it even if the allocator has not seen the feed yet.
+func restampItem(
+	ctx context.Context, alloc SequenceAllocator, uid string, flavour Flavour, it *Item) error {
+	if err := alloc.Observe(ctx, uid, flavour, it.SequenceNumber); err != nil {
+		return fmt.Errorf("can't allocate a sequence number for item %s: %w", it.ID, err)
+	}
+	return StampItem(ctx, alloc, uid, flavour, it)
+}
+
+// MaxSequenceNumber returns the highest sequence number used by the feed's
+// actions, nudges, items and their messages
+func (fe *Feed) MaxSequenceNumber() int {
+	max := 0
+	for _, ac := range fe.Actions {
+		if ac.SequenceNumber > max {
+			max = ac.SequenceNumber
+		}
+	}
+	for _, nu := range fe.Nudges {
+		if nu.SequenceNumber > max {
+			max = nu.SequenceNumber
+		}
+	}
+	for _, it := range fe.Items {
+		if it.SequenceNumber > max {
+			max = it.SequenceNumber
+		}
+		for _, msg := range it.Conversations {
+			if msg.SequenceNumber > max {
+				max = msg.SequenceNumber
+			}
+		}
+	}
+	return max
+}
+
+// VerifySequence checks that the feed's sequence numbers are consistent with
+// having been handed out by a SequenceAllocator: every number is positive and
+// is used by only one element.
+//
+// The order of the elements within the feed is not checked. A changed element
+// e.g a resolved item is given a new number in place, so "higher means later"
+// holds between numbers, not between positions.
+//
+// The returned error wraps ErrInvalidSequenceNumber.
+func (fe *Feed) VerifySequence() error {
+	seen := map[int]string{}
+	check := func(kind string, id string, seq int) error {
+		element := fmt.Sprintf("%s %s", kind, id)
+		if seq <= 0 {
+			return fmt.Errorf(
+				"%s has sequence number %d: %w", element, seq, ErrInvalidSequenceNumber)
+		}
+		if other, ok := seen[seq]; ok {
+			return fmt.Errorf(
+				"%s and %s share sequence number %d: %w", other, element, seq, ErrInvalidSequenceNumber)
+		}
+		seen[seq] = element
+		return nil
+	}
+
+	for _, ac := range fe.Actions {
+		if err := check("action", ac.ID, ac.SequenceNumber); err != nil {
+			return err
+		}
+	}
+	for _, nu := range fe.Nudges {
+		if err := check("nudge", nu.ID, nu.SequenceNumber); err != nil {
+			return err
+		}
+	}
+	for _, it := range fe.Items {
+		if err := check("item", it.ID, it.SequenceNumber); err != nil {
+			return err
+		}
+		for _, msg := range it.Conversations {
+			if err := check("message", msg.ID, msg.SequenceNumber); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ObserveFeed verifies a loaded feed's sequence numbers and tells the
+// allocator about them, so that elements that are added to the feed later get
+// higher sequence numbers
+func ObserveFeed(ctx context.Context, alloc SequenceAllocator, fe *Feed) error {
+	if err := fe.VerifySequence(); err != nil {
+		return err
+	}
+	return alloc.Observe(ctx, fe.UID, fe.Flavour, fe.MaxSequenceNumber())
+}
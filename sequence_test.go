@@ -0,0 +1,219 @@
+package feedlib_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySequenceAllocator_Next(t *testing.T) {
+	ctx := context.Background()
+	a := feedlib.NewMemorySequenceAllocator()
+
+	for want := 1; want <= 3; want++ {
+		got, err := a.Next(ctx, "user-1", feedlib.FlavourConsumer)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	got, err := a.Next(ctx, "user-1", feedlib.FlavourPro)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, got, "every feed has its own sequence")
+
+	got, err = a.Next(ctx, "user-2", feedlib.FlavourConsumer)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, got, "every user has their own sequence")
+
+	_, err = a.Next(ctx, "", feedlib.FlavourConsumer)
+	assert.NotNil(t, err)
+	_, err = a.Next(ctx, "user-1", feedlib.Flavour("bogus"))
+	assert.NotNil(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = a.Next(cancelled, "user-1", feedlib.FlavourConsumer)
+	assert.NotNil(t, err)
+}
+
+func TestMemorySequenceAllocator_Concurrency(t *testing.T) {
+	ctx := context.Background()
+	a := feedlib.NewMemorySequenceAllocator()
+
+	const workers, perWorker = 8, 50
+	var mu sync.Mutex
+	got := []int{}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				seq, err := a.Next(ctx, "user-1", feedlib.FlavourConsumer)
+				assert.Nil(t, err)
+				mu.Lock()
+				got = append(got, seq)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(got)
+	for i, seq := range got {
+		assert.Equal(t, i+1, seq, "sequence numbers should be unique and gapless")
+	}
+}
+
+func TestMemorySequenceAllocator_Observe(t *testing.T) {
+	ctx := context.Background()
+	a := feedlib.NewMemorySequenceAllocator()
+
+	assert.Nil(t, a.Observe(ctx, "user-1", feedlib.FlavourConsumer, 10))
+	assert.Nil(t, a.Observe(ctx, "user-1", feedlib.FlavourConsumer, 5), "lower numbers are ignored")
+
+	got, err := a.Next(ctx, "user-1", feedlib.FlavourConsumer)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, got)
+
+	assert.NotNil(t, a.Observe(ctx, "", feedlib.FlavourConsumer, 1))
+}
+
+func TestStamp(t *testing.T) {
+	ctx := context.Background()
+	a := feedlib.NewMemorySequenceAllocator()
+	uid, flavour := "user-1", feedlib.FlavourConsumer
+
+	ac := feedtest.Action("action-1", 0)
+	nu := feedtest.Nudge("nudge-1", 0)
+	it := feedtest.Item("item-1", 0)
+	msg := it.Conversations[0]
+
+	assert.Nil(t, feedlib.StampAction(ctx, a, uid, flavour, &ac))
+	assert.Nil(t, feedlib.StampNudge(ctx, a, uid, flavour, &nu))
+	assert.Nil(t, feedlib.StampItem(ctx, a, uid, flavour, &it))
+	assert.Nil(t, feedlib.StampMessage(ctx, a, uid, flavour, &msg))
+	assert.Equal(t, 1, ac.SequenceNumber)
+	assert.Equal(t, 2, nu.SequenceNumber)
+	assert.Equal(t, 3, it.SequenceNumber)
+	assert.Equal(t, 4, msg.SequenceNumber)
+
+	err := feedlib.StampItem(ctx, a, "", flavour, &it)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, it.SequenceNumber, "a failed stamp should not change the element")
+}
+
+func getSequencedTestFeed() *feedlib.Feed {
+	fe := getTestFeed()
+	fe.Actions = []feedlib.Action{feedtest.Action("action-1", 1), feedtest.Action("action-2", 4)}
+	fe.Nudges = []feedlib.Nudge{feedtest.Nudge("nudge-1", 2)}
+	it := feedtest.Item("item-1", 3)
+	it.Conversations[0].SequenceNumber = 5
+	fe.Items = []feedlib.Item{it}
+	return fe
+}
+
+func TestFeed_VerifySequence(t *testing.T) {
+	duplicate := getSequencedTestFeed()
+	duplicate.Nudges[0].SequenceNumber = 1
+
+	outOfOrder := getSequencedTestFeed()
+	outOfOrder.Actions[0], outOfOrder.Actions[1] = outOfOrder.Actions[1], outOfOrder.Actions[0]
+
+	unstamped := getSequencedTestFeed()
+	unstamped.Items[0].SequenceNumber = 0
+
+	tests := []struct {
+		name    string
+		fe      *feedlib.Feed
+		wantErr bool
+	}{
+		{
+			name:    "valid feed",
+			fe:      getSequencedTestFeed(),
+			wantErr: false,
+		},
+		{
+			name:    "empty feed",
+			fe:      &feedlib.Feed{},
+			wantErr: false,
+		},
+		{
+			name:    "duplicate sequence numbers across element kinds",
+			fe:      duplicate,
+			wantErr: true,
+		},
+		{
+			name:    "out of slice order",
+			fe:      outOfOrder,
+			wantErr: false,
+		},
+		{
+			name:    "unstamped element",
+			fe:      unstamped,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fe.VerifySequence()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Feed.VerifySequence() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, feedlib.ErrInvalidSequenceNumber))
+			}
+		})
+	}
+}
+
+func TestFeed_VerifySequence_ChangedElement(t *testing.T) {
+	ctx := context.Background()
+	a := feedlib.NewMemorySequenceAllocator()
+	le := feedlib.NewLifecycleEngine(a, nil)
+
+	fe := getTestFeed()
+	fe.Actions = nil
+	fe.Nudges = nil
+	fe.Items = nil
+	for _, id := range []string{"item-1", "item-2", "item-3"} {
+		it := feedtest.Item(id, 0)
+		it.Conversations = nil
+		assert.Nil(t, feedlib.StampItem(ctx, a, fe.UID, fe.Flavour, &it))
+		assert.Nil(t, fe.AddItem(it))
+	}
+
+	_, err := le.ApplyToItem(ctx, &fe.Items[1], feedlib.CommandResolve, fe.UID, fe.Flavour, getTestContext())
+	assert.Nil(t, err)
+	assert.Equal(t, 4, fe.Items[1].SequenceNumber)
+	assert.Nil(t, fe.VerifySequence(), "resolving a middle item should keep the feed valid")
+	assert.Nil(t, feedlib.ObserveFeed(ctx, a, fe))
+
+	hidden := fe.Items[0]
+	_, err = le.ApplyToItem(ctx, &hidden, feedlib.CommandHide, fe.UID, fe.Flavour, getTestContext())
+	assert.Nil(t, err)
+	assert.Nil(t, fe.ReplaceItem(hidden))
+	assert.Nil(t, fe.VerifySequence(), "replacing an item in place should keep the feed valid")
+}
+
+func TestObserveFeed(t *testing.T) {
+	ctx := context.Background()
+	a := feedlib.NewMemorySequenceAllocator()
+
+	fe := getSequencedTestFeed()
+	assert.Equal(t, 5, fe.MaxSequenceNumber())
+	assert.Nil(t, feedlib.ObserveFeed(ctx, a, fe))
+
+	got, err := a.Next(ctx, fe.UID, fe.Flavour)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, got)
+
+	fe.Nudges[0].SequenceNumber = 1
+	assert.NotNil(t, feedlib.ObserveFeed(ctx, a, fe))
+}
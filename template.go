@@ -0,0 +1,180 @@
+package feedlib
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// well known notification template placeholders e.g {{userName}}
+const (
+	PlaceholderUserName     = "userName"
+	PlaceholderTitle        = "title"
+	PlaceholderOrganisation = "organisation"
+	PlaceholderDueDate      = "dueDate"
+)
+
+// DueDateLayout is how an element's expiry is formatted for the
+// {{dueDate}} placeholder
+const DueDateLayout = "2 Jan 2006"
+
+// placeholderPattern matches a template placeholder e.g {{ userName }}
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z][A-Za-z0-9_]*)\s*\}\}`)
+
+// markdownEscaper escapes the characters that have a meaning in Markdown
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `{`, `\{`, `}`, `\}`,
+	`[`, `\[`, `]`, `\]`, `(`, `\(`, `)`, `\)`, `#`, `\#`, `+`, `\+`,
+	`-`, `\-`, `.`, `\.`, `!`, `\!`, `|`, `\|`, `<`, `\<`, `>`, `\>`,
+)
+
+// UnresolvedPlaceholdersError is returned when a template uses placeholders
+// that there is no data for
+type UnresolvedPlaceholdersError struct {
+	Placeholders []string
+}
+
+func (e *UnresolvedPlaceholdersError) Error() string {
+	return fmt.Sprintf(
+		"unresolved template placeholder(s): %s",
+		strings.Join(e.Placeholders, ", "),
+	)
+}
+
+// TemplatePlaceholders returns the distinct placeholders that a template uses,
+// in the order in which they first appear
+func TemplatePlaceholders(tmpl string) []string {
+	seen := map[string]bool{}
+	placeholders := []string{}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(tmpl, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			placeholders = append(placeholders, match[1])
+		}
+	}
+	return placeholders
+}
+
+// RenderTemplate replaces the placeholders in a template with the supplied
+// data. Substituted values are escaped so that they are rendered literally
+// by clients that render text of the given type; the template itself is
+// trusted and is not escaped.
+//
+// Every placeholder must resolve, otherwise an UnresolvedPlaceholdersError
+// is returned.
+func RenderTemplate(tmpl string, textType TextType, data map[string]string) (string, error) {
+	unresolved := []string{}
+	for _, p := range TemplatePlaceholders(tmpl) {
+		if _, ok := data[p]; !ok {
+			unresolved = append(unresolved, p)
+		}
+	}
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		return "", &UnresolvedPlaceholdersError{Placeholders: unresolved}
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(tmpl, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		return escapeText(data[name], textType)
+	}), nil
+}
+
+// escapeText escapes a value for inclusion in text of the given type
+func escapeText(value string, textType TextType) string {
+	switch textType {
+	case TextTypeHTML:
+		return html.EscapeString(value)
+	case TextTypeMarkdown:
+		return markdownEscaper.Replace(value)
+	}
+	return value
+}
+
+// RenderNotificationBody renders every message in a notification body.
+// The first message that fails to render aborts rendering.
+func RenderNotificationBody(
+	nb NotificationBody, textType TextType, data map[string]string) (NotificationBody, error) {
+	rendered := NotificationBody{}
+	fields := []struct {
+		name string
+		in   string
+		out  *string
+	}{
+		{name: "publishMessage", in: nb.PublishMessage, out: &rendered.PublishMessage},
+		{name: "deleteMessage", in: nb.DeleteMessage, out: &rendered.DeleteMessage},
+		{name: "resolveMessage", in: nb.ResolveMessage, out: &rendered.ResolveMessage},
+		{name: "unresolveMessage", in: nb.UnresolveMessage, out: &rendered.UnresolveMessage},
+		{name: "showMessage", in: nb.ShowMessage, out: &rendered.ShowMessage},
+		{name: "hideMessage", in: nb.HideMessage, out: &rendered.HideMessage},
+	}
+	for _, f := range fields {
+		out, err := RenderTemplate(f.in, textType, data)
+		if err != nil {
+			return NotificationBody{}, fmt.Errorf("can't render %s: %w", f.name, err)
+		}
+		*f.out = out
+	}
+	return rendered, nil
+}
+
+// ItemTemplateData returns the placeholder data that an item provides:
+// its tagline as the title and its expiry as the due date.
+func ItemTemplateData(it Item) map[string]string {
+	data := map[string]string{
+		PlaceholderTitle: it.Tagline,
+	}
+	if !it.Expiry.IsZero() {
+		data[PlaceholderDueDate] = it.Expiry.Format(DueDateLayout)
+	}
+	return data
+}
+
+// NudgeTemplateData returns the placeholder data that a nudge provides:
+// its title and its expiry as the due date.
+func NudgeTemplateData(nu Nudge) map[string]string {
+	data := map[string]string{
+		PlaceholderTitle: nu.Title,
+	}
+	if !nu.Expiry.IsZero() {
+		data[PlaceholderDueDate] = nu.Expiry.Format(DueDateLayout)
+	}
+	return data
+}
+
+// mergeTemplateData combines element data with caller supplied data.
+// Caller supplied values win.
+func mergeTemplateData(elementData map[string]string, data map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range elementData {
+		merged[k] = v
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+	return merged
+}
+
+// RenderItemNotification renders the item's message for a notification type,
+// using the item's own data plus the supplied data e.g the user's name.
+// Values are escaped as per the item's text type.
+func RenderItemNotification(it Item, t NotificationType, data map[string]string) (string, error) {
+	return RenderTemplate(
+		it.NotificationBody.Message(t),
+		it.TextType,
+		mergeTemplateData(ItemTemplateData(it), data),
+	)
+}
+
+// RenderNudgeNotification renders the nudge's message for a notification
+// type, using the nudge's own data plus the supplied data e.g the user's name.
+// Nudges do not have a text type so values are not escaped.
+func RenderNudgeNotification(nu Nudge, t NotificationType, data map[string]string) (string, error) {
+	return RenderTemplate(
+		nu.NotificationBody.Message(t),
+		TextTypePlain,
+		mergeTemplateData(NudgeTemplateData(nu), data),
+	)
+}
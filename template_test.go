@@ -0,0 +1,169 @@
+package feedlib_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplatePlaceholders(t *testing.T) {
+	assert.Equal(
+		t,
+		[]string{"userName", "title"},
+		feedlib.TemplatePlaceholders("Hi {{userName}}, {{ title }} is due. Bye {{userName}}"),
+	)
+	assert.Empty(t, feedlib.TemplatePlaceholders("no placeholders {here}"))
+}
+
+func TestRenderTemplate(t *testing.T) {
+	data := map[string]string{
+		feedlib.PlaceholderUserName:     "Juma <b>",
+		feedlib.PlaceholderOrganisation: "Be_Well *Clinic*",
+	}
+	tests := []struct {
+		name           string
+		tmpl           string
+		textType       feedlib.TextType
+		want           string
+		wantUnresolved []string
+	}{
+		{
+			name:     "plain text is not escaped",
+			tmpl:     "Hi {{userName}} from {{ organisation }}",
+			textType: feedlib.TextTypePlain,
+			want:     "Hi Juma <b> from Be_Well *Clinic*",
+		},
+		{
+			name:     "HTML values are escaped but the template is not",
+			tmpl:     "<p>Hi {{userName}}</p>",
+			textType: feedlib.TextTypeHTML,
+			want:     "<p>Hi Juma &lt;b&gt;</p>",
+		},
+		{
+			name:     "markdown values are escaped but the template is not",
+			tmpl:     "**{{organisation}}**",
+			textType: feedlib.TextTypeMarkdown,
+			want:     `**Be\_Well \*Clinic\***`,
+		},
+		{
+			name:     "no placeholders",
+			tmpl:     "Your profile is up to date",
+			textType: feedlib.TextTypePlain,
+			want:     "Your profile is up to date",
+		},
+		{
+			name:           "unresolved placeholders",
+			tmpl:           "Hi {{userName}}, {{title}} is due on {{dueDate}}",
+			textType:       feedlib.TextTypePlain,
+			wantUnresolved: []string{"dueDate", "title"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := feedlib.RenderTemplate(tt.tmpl, tt.textType, data)
+			if tt.wantUnresolved != nil {
+				var uErr *feedlib.UnresolvedPlaceholdersError
+				if !errors.As(err, &uErr) {
+					t.Errorf("expected an *feedlib.UnresolvedPlaceholdersError, got %v", err)
+					return
+				}
+				assert.Equal(t, tt.wantUnresolved, uErr.Placeholders)
+				assert.Contains(t, err.Error(), "dueDate, title")
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRenderNotificationBody(t *testing.T) {
+	nb := feedlib.NotificationBody{
+		PublishMessage: "Hi {{userName}}, you have a new task",
+		ResolveMessage: "Well done {{userName}}!",
+	}
+	got, err := feedlib.RenderNotificationBody(
+		nb, feedlib.TextTypePlain, map[string]string{feedlib.PlaceholderUserName: "Juma"})
+	assert.Nil(t, err)
+	assert.Equal(t, feedlib.NotificationBody{
+		PublishMessage: "Hi Juma, you have a new task",
+		ResolveMessage: "Well done Juma!",
+	}, got)
+
+	_, err = feedlib.RenderNotificationBody(nb, feedlib.TextTypePlain, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "publishMessage")
+}
+
+func TestRenderItemNotification(t *testing.T) {
+	it := feedtest.Item("item-1", 1)
+	it.Tagline = "Refill <prescription>"
+	it.Expiry = time.Date(2021, 9, 30, 0, 0, 0, 0, time.UTC)
+	it.TextType = feedlib.TextTypeHTML
+	it.NotificationBody = feedlib.NotificationBody{
+		PublishMessage: "{{userName}}: {{title}} is due on {{dueDate}}",
+	}
+
+	got, err := feedlib.RenderItemNotification(
+		it, feedlib.NotificationTypePublish, map[string]string{feedlib.PlaceholderUserName: "Juma"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Juma: Refill &lt;prescription&gt; is due on 30 Sep 2021", got)
+
+	it.Expiry = time.Time{}
+	_, err = feedlib.RenderItemNotification(
+		it, feedlib.NotificationTypePublish, map[string]string{feedlib.PlaceholderUserName: "Juma"})
+	assert.NotNil(t, err, "an item without an expiry has no due date")
+}
+
+func TestRenderNudgeNotification(t *testing.T) {
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.NotificationBody = feedlib.NotificationBody{
+		HideMessage: "{{title}} was hidden, {{userName}}",
+	}
+
+	got, err := feedlib.RenderNudgeNotification(
+		nu, feedlib.NotificationTypeHide, map[string]string{
+			feedlib.PlaceholderUserName: "Juma",
+			feedlib.PlaceholderTitle:    "Overridden title",
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, "Overridden title was hidden, Juma", got)
+}
+
+func TestDispatcher_Personalisation(t *testing.T) {
+	sms := feedlib.NewMemoryNotifier(nil)
+	d := feedlib.NewDispatcher(map[feedlib.Channel]feedlib.Notifier{
+		feedlib.ChannelSms: sms,
+	})
+	names := map[string]string{"user-1": "Juma", "user-2": "Wanjiku"}
+	d.SetRecipientData(func(ctx context.Context, uid string) (map[string]string, error) {
+		name, ok := names[uid]
+		if !ok {
+			return nil, errors.New("unknown user")
+		}
+		return map[string]string{feedlib.PlaceholderUserName: name}, nil
+	})
+
+	nu := feedtest.Nudge("nudge-1", 1)
+	nu.Users = []string{"user-1", "user-2", "user-3"}
+	nu.NotificationChannels = []feedlib.Channel{feedlib.ChannelSms}
+	nu.NotificationBody = feedlib.NotificationBody{
+		PublishMessage: "Hi {{userName}}, {{title}}",
+	}
+
+	sent, err := d.DispatchNudge(context.Background(), nu, feedlib.NotificationTypePublish)
+	assert.Len(t, sent, 2)
+	assert.Equal(t, "Hi Juma, Update your profile!", sent[0].Message)
+	assert.Equal(t, "Hi Wanjiku, Update your profile!", sent[1].Message)
+
+	var dErr *feedlib.DispatchError
+	assert.True(t, errors.As(err, &dErr))
+	assert.Len(t, dErr.Failures, 1)
+	assert.Equal(t, "user-3", dErr.Failures[0].Notification.UserID)
+	assert.Len(t, sms.Sent(), 2)
+}
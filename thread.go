@@ -0,0 +1,319 @@
+package feedlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ErrDanglingReply is returned when a message replies to a message that is
+// not in its thread
+var ErrDanglingReply = errors.New("reply to a message that is not in the thread")
+
+// ErrReplyCycle is returned when following the replies of a thread loops
+// back to where it started
+var ErrReplyCycle = errors.New("reply cycle")
+
+// ThreadOrder determines how the messages in a thread are ordered
+type ThreadOrder string
+
+// known thread orders
+const (
+	ThreadOrderSequence  ThreadOrder = "SEQUENCE"
+	ThreadOrderTimestamp ThreadOrder = "TIMESTAMP"
+)
+
+// AllThreadOrder is the set of all known thread orders
+var AllThreadOrder = []ThreadOrder{
+	ThreadOrderSequence,
+	ThreadOrderTimestamp,
+}
+
+// IsValid returns true only for valid thread orders
+func (e ThreadOrder) IsValid() bool {
+	switch e {
+	case ThreadOrderSequence, ThreadOrderTimestamp:
+		return true
+	}
+	return false
+}
+
+func (e ThreadOrder) String() string {
+	return string(e)
+}
+
+// UnmarshalGQL reads and validates a thread order from the supplied input
+func (e *ThreadOrder) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ThreadOrder(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ThreadOrder", str)
+	}
+	return nil
+}
+
+// MarshalGQL writes the thread order to the supplied writer
+func (e ThreadOrder) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// less orders two messages. Ties are broken by sequence number, then by ID,
+// so that the order is stable.
+func (e ThreadOrder) less(a Message, b Message) bool {
+	if e == ThreadOrderTimestamp && !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return lessBySequence(a.SequenceNumber, a.ID, b.SequenceNumber, b.ID)
+}
+
+// ThreadNode is a message and the replies to it
+type ThreadNode struct {
+	Message Message
+	Replies []*ThreadNode
+}
+
+// ThreadEntry is a message in a flattened thread
+type ThreadEntry struct {
+	Message Message `json:"message"`
+
+	// How deeply the message is nested: top level messages have depth 0,
+	// replies to them have depth 1 and so on
+	Depth int `json:"depth"`
+}
+
+// ThreadPage is a page of a flattened thread
+type ThreadPage struct {
+	Entries []ThreadEntry `json:"entries"`
+
+	// The number of messages in the whole thread
+	Total int `json:"total"`
+
+	// Whether there are more messages after this page
+	HasMore bool `json:"hasMore"`
+}
+
+// Thread is the reply tree of a conversation.
+//
+// Messages with an empty ReplyTo start new threads at the top level.
+type Thread struct {
+	roots []*ThreadNode
+	nodes map[string]*ThreadNode
+}
+
+// NewThread builds the reply tree of the supplied messages, which may be in
+// any order.
+//
+// It fails if message IDs are missing or repeated (ErrDuplicateElement), if a
+// message replies to a message that is not in the slice (ErrDanglingReply) or
+// if the replies loop (ErrReplyCycle).
+func NewThread(msgs []Message) (*Thread, error) {
+	th := &Thread{
+		roots: []*ThreadNode{},
+		nodes: map[string]*ThreadNode{},
+	}
+	for _, msg := range msgs {
+		if msg.ID == "" {
+			return nil, fmt.Errorf("a message ID is required")
+		}
+		if _, ok := th.nodes[msg.ID]; ok {
+			return nil, fmt.Errorf("message %s: %w", msg.ID, ErrDuplicateElement)
+		}
+		th.nodes[msg.ID] = &ThreadNode{Message: msg, Replies: []*ThreadNode{}}
+	}
+	for _, msg := range msgs {
+		if msg.ReplyTo != "" {
+			if _, ok := th.nodes[msg.ReplyTo]; !ok {
+				return nil, fmt.Errorf(
+					"message %s replies to %s: %w", msg.ID, msg.ReplyTo, ErrDanglingReply)
+			}
+		}
+	}
+	if err := th.checkCycles(msgs); err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		node := th.nodes[msg.ID]
+		if msg.ReplyTo == "" {
+			th.roots = append(th.roots, node)
+			continue
+		}
+		parent := th.nodes[msg.ReplyTo]
+		parent.Replies = append(parent.Replies, node)
+	}
+	return th, nil
+}
+
+// checkCycles follows the ReplyTo chain of every message and fails if any
+// chain loops. Every reference is known to resolve.
+func (th *Thread) checkCycles(msgs []Message) error {
+	// messages whose chains are known to end at a top level message
+	acyclic := map[string]bool{}
+	for _, msg := range msgs {
+		onPath := map[string]bool{}
+		for id := msg.ID; id != "" && !acyclic[id]; id = th.nodes[id].Message.ReplyTo {
+			if onPath[id] {
+				return fmt.Errorf("message %s: %w", id, ErrReplyCycle)
+			}
+			onPath[id] = true
+		}
+		for id := range onPath {
+			acyclic[id] = true
+		}
+	}
+	return nil
+}
+
+// Len returns the number of messages in the thread
+func (th *Thread) Len() int {
+	return len(th.nodes)
+}
+
+// Roots returns the top level messages of the thread and their replies
+func (th *Thread) Roots() []*ThreadNode {
+	return th.roots
+}
+
+// Get returns a message and its replies
+func (th *Thread) Get(id string) (*ThreadNode, error) {
+	node, ok := th.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("message %s: %w", id, ErrElementNotFound)
+	}
+	return node, nil
+}
+
+// Append adds a message to the thread after checking that it is a valid
+// message, that its ID is not in use and that, if it is a reply, it replies
+// to a message in the thread and comes after it.
+func (th *Thread) Append(msg Message) error {
+	if _, err := msg.ValidateAndMarshal(); err != nil {
+		return err
+	}
+	if _, ok := th.nodes[msg.ID]; ok {
+		return fmt.Errorf("message %s: %w", msg.ID, ErrDuplicateElement)
+	}
+	node := &ThreadNode{Message: msg, Replies: []*ThreadNode{}}
+	if msg.ReplyTo == "" {
+		th.roots = append(th.roots, node)
+		th.nodes[msg.ID] = node
+		return nil
+	}
+
+	parent, ok := th.nodes[msg.ReplyTo]
+	if !ok {
+		return fmt.Errorf(
+			"message %s replies to %s: %w", msg.ID, msg.ReplyTo, ErrDanglingReply)
+	}
+	if msg.SequenceNumber <= parent.Message.SequenceNumber {
+		return fmt.Errorf(
+			"message %s has sequence number %d but replies to %s with sequence number %d: %w",
+			msg.ID, msg.SequenceNumber, parent.Message.ID, parent.Message.SequenceNumber,
+			ErrSequenceViolation,
+		)
+	}
+	parent.Replies = append(parent.Replies, node)
+	th.nodes[msg.ID] = node
+	return nil
+}
+
+// Flatten returns the thread depth first: each message is followed by its
+// replies. Top level messages and the replies to each message are sorted in
+// the supplied order.
+func (th *Thread) Flatten(order ThreadOrder) ([]ThreadEntry, error) {
+	if !order.IsValid() {
+		return nil, fmt.Errorf("%s is not a valid ThreadOrder", order)
+	}
+	entries := []ThreadEntry{}
+	var walk func(nodes []*ThreadNode, depth int)
+	walk = func(nodes []*ThreadNode, depth int) {
+		for _, node := range sortedNodes(nodes, order) {
+			entries = append(entries, ThreadEntry{Message: node.Message, Depth: depth})
+			walk(node.Replies, depth+1)
+		}
+	}
+	walk(th.roots, 0)
+	return entries, nil
+}
+
+// Chronological returns every message in the thread, regardless of nesting,
+// sorted in the supplied order
+func (th *Thread) Chronological(order ThreadOrder) ([]Message, error) {
+	if !order.IsValid() {
+		return nil, fmt.Errorf("%s is not a valid ThreadOrder", order)
+	}
+	msgs := []Message{}
+	for _, node := range th.nodes {
+		msgs = append(msgs, node.Message)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return order.less(msgs[i], msgs[j])
+	})
+	return msgs, nil
+}
+
+// Page returns up to limit entries of the flattened thread, starting at
+// offset
+func (th *Thread) Page(order ThreadOrder, offset int, limit int) (*ThreadPage, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("the offset can't be negative, got %d", offset)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("the limit must be positive, got %d", limit)
+	}
+	entries, err := th.Flatten(order)
+	if err != nil {
+		return nil, err
+	}
+	page := &ThreadPage{
+		Entries: []ThreadEntry{},
+		Total:   len(entries),
+	}
+	if offset >= len(entries) {
+		return page, nil
+	}
+	end := offset + limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+	page.Entries = append(page.Entries, entries[offset:end]...)
+	page.HasMore = end < len(entries)
+	return page, nil
+}
+
+// sortedNodes returns a sorted copy of the supplied nodes
+func sortedNodes(nodes []*ThreadNode, order ThreadOrder) []*ThreadNode {
+	sorted := append([]*ThreadNode{}, nodes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return order.less(sorted[i].Message, sorted[j].Message)
+	})
+	return sorted
+}
+
+// Thread builds the reply tree of the item's conversation
+func (it Item) Thread() (*Thread, error) {
+	th, err := NewThread(it.Conversations)
+	if err != nil {
+		return nil, fmt.Errorf("invalid conversation on item %s: %w", it.ID, err)
+	}
+	return th, nil
+}
+
+// AddMessage validates a message against the item's conversation, as per
+// Thread.Append, and adds it to the conversation if it is valid
+func (it *Item) AddMessage(msg Message) error {
+	th, err := it.Thread()
+	if err != nil {
+		return err
+	}
+	if err := th.Append(msg); err != nil {
+		return fmt.Errorf("can't add message to item %s: %w", it.ID, err)
+	}
+	it.Conversations = append(it.Conversations, msg)
+	return nil
+}
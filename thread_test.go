@@ -0,0 +1,325 @@
+package feedlib_test
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/savannahghi/feedlib"
+	"github.com/savannahghi/feedlib/feedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+var threadStart = time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC)
+
+func getTestMessage(id string, seq int, replyTo string, minutes int) feedlib.Message {
+	return feedlib.Message{
+		ID:             id,
+		SequenceNumber: seq,
+		Text:           "message " + id,
+		ReplyTo:        replyTo,
+		PostedByUID:    "user-1",
+		PostedByName:   "User 1",
+		Timestamp:      threadStart.Add(time.Duration(minutes) * time.Minute),
+	}
+}
+
+// getTestConversation returns, in no particular order:
+//
+//	a (1)
+//	├── c (3)
+//	│   └── e (5)
+//	└── d (4)
+//	b (2)
+//
+// The timestamps of b and c are swapped relative to their sequence numbers.
+func getTestConversation() []feedlib.Message {
+	return []feedlib.Message{
+		getTestMessage("e", 5, "c", 5),
+		getTestMessage("d", 4, "a", 4),
+		getTestMessage("a", 1, "", 1),
+		getTestMessage("c", 3, "a", 2),
+		getTestMessage("b", 2, "", 3),
+	}
+}
+
+func entryIDs(entries []feedlib.ThreadEntry) []string {
+	ids := []string{}
+	for _, e := range entries {
+		ids = append(ids, e.Message.ID)
+	}
+	return ids
+}
+
+func TestThreadOrder_IsValid(t *testing.T) {
+	for _, o := range feedlib.AllThreadOrder {
+		assert.True(t, o.IsValid(), "%s should be valid", o)
+	}
+	assert.False(t, feedlib.ThreadOrder("bogus").IsValid())
+
+	o := feedlib.ThreadOrder("")
+	assert.Nil(t, o.UnmarshalGQL("TIMESTAMP"))
+	assert.NotNil(t, o.UnmarshalGQL("bogus"))
+	assert.NotNil(t, o.UnmarshalGQL(1))
+
+	w := &bytes.Buffer{}
+	feedlib.ThreadOrderSequence.MarshalGQL(w)
+	assert.Equal(t, strconv.Quote("SEQUENCE"), w.String())
+}
+
+func TestNewThread(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgs    []feedlib.Message
+		wantErr error
+	}{
+		{
+			name: "valid conversation",
+			msgs: getTestConversation(),
+		},
+		{
+			name: "empty conversation",
+			msgs: nil,
+		},
+		{
+			name: "duplicate message IDs",
+			msgs: []feedlib.Message{
+				getTestMessage("a", 1, "", 1),
+				getTestMessage("a", 2, "", 2),
+			},
+			wantErr: feedlib.ErrDuplicateElement,
+		},
+		{
+			name: "dangling reply",
+			msgs: []feedlib.Message{
+				getTestMessage("a", 1, "", 1),
+				getTestMessage("b", 2, "missing", 2),
+			},
+			wantErr: feedlib.ErrDanglingReply,
+		},
+		{
+			name: "self reply",
+			msgs: []feedlib.Message{
+				getTestMessage("a", 1, "a", 1),
+			},
+			wantErr: feedlib.ErrReplyCycle,
+		},
+		{
+			name: "longer cycle",
+			msgs: []feedlib.Message{
+				getTestMessage("root", 1, "", 1),
+				getTestMessage("a", 2, "c", 2),
+				getTestMessage("b", 3, "a", 3),
+				getTestMessage("c", 4, "b", 4),
+			},
+			wantErr: feedlib.ErrReplyCycle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th, err := feedlib.NewThread(tt.msgs)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				assert.Nil(t, th)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, len(tt.msgs), th.Len())
+		})
+	}
+
+	_, err := feedlib.NewThread([]feedlib.Message{getTestMessage("", 1, "", 1)})
+	assert.NotNil(t, err)
+}
+
+func TestThread_Structure(t *testing.T) {
+	th, err := feedlib.NewThread(getTestConversation())
+	assert.Nil(t, err)
+
+	assert.Len(t, th.Roots(), 2)
+	a, err := th.Get("a")
+	assert.Nil(t, err)
+	assert.Len(t, a.Replies, 2)
+
+	_, err = th.Get("missing")
+	assert.True(t, errors.Is(err, feedlib.ErrElementNotFound))
+}
+
+func TestThread_Flatten(t *testing.T) {
+	th, err := feedlib.NewThread(getTestConversation())
+	assert.Nil(t, err)
+
+	bySequence, err := th.Flatten(feedlib.ThreadOrderSequence)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "c", "e", "d", "b"}, entryIDs(bySequence))
+	assert.Equal(t, 0, bySequence[0].Depth)
+	assert.Equal(t, 1, bySequence[1].Depth)
+	assert.Equal(t, 2, bySequence[2].Depth)
+
+	byTimestamp, err := th.Flatten(feedlib.ThreadOrderTimestamp)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "c", "e", "d", "b"}, entryIDs(byTimestamp))
+
+	_, err = th.Flatten(feedlib.ThreadOrder("bogus"))
+	assert.NotNil(t, err)
+}
+
+func TestThread_Chronological(t *testing.T) {
+	th, err := feedlib.NewThread(getTestConversation())
+	assert.Nil(t, err)
+
+	bySequence, err := th.Chronological(feedlib.ThreadOrderSequence)
+	assert.Nil(t, err)
+	byTimestamp, err := th.Chronological(feedlib.ThreadOrderTimestamp)
+	assert.Nil(t, err)
+
+	ids := func(msgs []feedlib.Message) []string {
+		out := []string{}
+		for _, m := range msgs {
+			out = append(out, m.ID)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, ids(bySequence))
+	assert.Equal(t, []string{"a", "c", "b", "d", "e"}, ids(byTimestamp))
+}
+
+func TestThread_Page(t *testing.T) {
+	th, err := feedlib.NewThread(getTestConversation())
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name        string
+		offset      int
+		limit       int
+		wantIDs     []string
+		wantHasMore bool
+		wantErr     bool
+	}{
+		{
+			name:        "first page",
+			offset:      0,
+			limit:       2,
+			wantIDs:     []string{"a", "c"},
+			wantHasMore: true,
+		},
+		{
+			name:        "last page",
+			offset:      4,
+			limit:       2,
+			wantIDs:     []string{"b"},
+			wantHasMore: false,
+		},
+		{
+			name:    "past the end",
+			offset:  10,
+			limit:   2,
+			wantIDs: []string{},
+		},
+		{
+			name:    "negative offset",
+			offset:  -1,
+			limit:   2,
+			wantErr: true,
+		},
+		{
+			name:    "zero limit",
+			offset:  0,
+			limit:   0,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := th.Page(feedlib.ThreadOrderSequence, tt.offset, tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Thread.Page() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equal(t, tt.wantIDs, entryIDs(page.Entries))
+			assert.Equal(t, tt.wantHasMore, page.HasMore)
+			assert.Equal(t, 5, page.Total)
+		})
+	}
+}
+
+func TestThread_Append(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     feedlib.Message
+		wantErr error
+	}{
+		{
+			name: "new top level message",
+			msg:  getTestMessage("f", 6, "", 6),
+		},
+		{
+			name: "reply",
+			msg:  getTestMessage("f", 6, "e", 6),
+		},
+		{
+			name:    "duplicate ID",
+			msg:     getTestMessage("a", 6, "", 6),
+			wantErr: feedlib.ErrDuplicateElement,
+		},
+		{
+			name:    "dangling reply",
+			msg:     getTestMessage("f", 6, "missing", 6),
+			wantErr: feedlib.ErrDanglingReply,
+		},
+		{
+			name:    "reply that comes before its parent",
+			msg:     getTestMessage("f", 4, "e", 6),
+			wantErr: feedlib.ErrSequenceViolation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th, err := feedlib.NewThread(getTestConversation())
+			assert.Nil(t, err)
+
+			err = th.Append(tt.msg)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				assert.Equal(t, 5, th.Len())
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, 6, th.Len())
+			node, err := th.Get(tt.msg.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.msg, node.Message)
+		})
+	}
+
+	th, err := feedlib.NewThread(nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, th.Append(feedlib.Message{ID: "invalid"}), "the message should be validated")
+}
+
+func TestItem_AddMessage(t *testing.T) {
+	it := feedtest.Item("item-1", 1)
+	first := it.Conversations[0]
+
+	reply := getTestMessage("msg-2", first.SequenceNumber+1, first.ID, 1)
+	assert.Nil(t, it.AddMessage(reply))
+	assert.Len(t, it.Conversations, 2)
+
+	th, err := it.Thread()
+	assert.Nil(t, err)
+	node, err := th.Get(first.ID)
+	assert.Nil(t, err)
+	assert.Len(t, node.Replies, 1)
+
+	assert.NotNil(t, it.AddMessage(reply), "the reply is already in the conversation")
+	assert.Len(t, it.Conversations, 2)
+
+	it.Conversations = append(it.Conversations, getTestMessage("orphan", 9, "missing", 1))
+	_, err = it.Thread()
+	assert.True(t, errors.Is(err, feedlib.ErrDanglingReply))
+}